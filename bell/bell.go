@@ -0,0 +1,245 @@
+// Package bell implements the doorbell logic independently of
+// the hardware.
+//
+// A Machine is driven by calling its Handle method with events
+// (buttons being pressed and released, timers firing and tunes
+// finishing), and in response it calls methods on its Outputs to
+// make sounds and on its Clock to start and stop timers.
+//
+// The behaviour is as follows. When a button is pressed, its
+// "ding" sound is played. If it's released before the long-press
+// time, its "dong" sound is played; otherwise a tune is started
+// instead. Pressing the button again while the tune is playing
+// stops it and starts another one.
+//
+// Only one button is handled at a time. If a button is pressed
+// while another one is being handled (from its ding until its
+// dong or the end of its tune), the press is queued, and when
+// the current button has finished, queued presses are handled
+// in priority order. A queued press is handled as a short press
+// if the button has been released by then.
+package bell
+
+import (
+	"strconv"
+	"time"
+)
+
+// MaxButtons holds the maximum number of buttons
+// that a Machine can handle.
+const MaxButtons = 32
+
+// EventKind represents a kind of event.
+type EventKind uint8
+
+const (
+	// Pressed is the event sent when a button is pressed.
+	Pressed EventKind = iota
+
+	// Released is the event sent when a button is released.
+	Released
+
+	// TimerFired is the event sent when the timer started by
+	// Clock.StartTimer expires.
+	TimerFired
+
+	// TuneDone is the event sent when the tune started by
+	// Outputs.PlayTune has finished playing. It should not be sent
+	// for a tune that's been stopped with Outputs.StopTune.
+	TuneDone
+)
+
+var eventKindNames = []string{
+	Pressed:    "pressed",
+	Released:   "released",
+	TimerFired: "timer-fired",
+	TuneDone:   "tune-done",
+}
+
+// String implements fmt.Stringer.
+func (k EventKind) String() string {
+	if int(k) < len(eventKindNames) {
+		return eventKindNames[k]
+	}
+	return "EventKind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Event represents an event that's sent to a Machine.
+type Event struct {
+	Kind EventKind
+	// Button holds the button number for Pressed
+	// and Released events.
+	Button int
+}
+
+// Outputs represents the doorbell outputs. The methods
+// are called synchronously by Machine.Handle.
+type Outputs interface {
+	// Ding plays the sound for the given button being pressed.
+	Ding(button int)
+
+	// Dong plays the sound for the given button being released.
+	Dong(button int)
+
+	// PlayTune starts playing a tune for the given button.
+	// When the tune has finished, a TuneDone event
+	// should be sent.
+	PlayTune(button int)
+
+	// StopTune stops the currently playing tune.
+	StopTune()
+}
+
+// Clock represents the timer used by a Machine.
+type Clock interface {
+	// StartTimer starts the timer so that a TimerFired event
+	// will be sent after the given duration, cancelling any
+	// existing timer.
+	StartTimer(d time.Duration)
+
+	// StopTimer stops the timer so that no TimerFired
+	// event will be sent.
+	StopTimer()
+}
+
+// state represents the state of a Machine.
+type state uint8
+
+const (
+	// idle means that no button is being handled.
+	idle state = iota
+	// held means that the active button's ding has played
+	// and we're waiting for it to be released or for the
+	// long-press timer to fire.
+	held
+	// playing means that a tune is playing for the active button.
+	playing
+)
+
+// Machine implements the doorbell state machine.
+// The exported fields may be changed between calls to Handle.
+type Machine struct {
+	// Outputs is used to make sounds.
+	Outputs Outputs
+
+	// Clock is used to time long presses.
+	Clock Clock
+
+	// LongPress holds how long a button must be held
+	// to start a tune.
+	LongPress time.Duration
+
+	// Priorities holds the priority of each button, indexed by
+	// button number. Queued buttons with higher priorities
+	// are handled first; buttons with equal priority are handled
+	// in button order. Missing entries are treated as zero.
+	Priorities []int
+
+	state state
+	// active holds the button being handled.
+	active int
+	// pressed holds the set of buttons currently pressed.
+	pressed uint32
+	// waiting holds the set of buttons that have been
+	// pressed but not yet handled.
+	waiting uint32
+}
+
+// Handle handles the given event.
+func (m *Machine) Handle(e Event) {
+	switch e.Kind {
+	case Pressed:
+		if !validButton(e.Button) {
+			return
+		}
+		bit := uint32(1) << e.Button
+		if m.pressed&bit != 0 {
+			// Already pressed. Shouldn't happen.
+			return
+		}
+		m.pressed |= bit
+		switch {
+		case m.state == idle:
+			m.start(e.Button)
+		case m.state == playing && e.Button == m.active:
+			m.Outputs.StopTune()
+			m.Outputs.PlayTune(m.active)
+		case e.Button != m.active:
+			m.waiting |= bit
+		}
+	case Released:
+		if !validButton(e.Button) {
+			return
+		}
+		m.pressed &^= uint32(1) << e.Button
+		if m.state == held && e.Button == m.active {
+			m.Clock.StopTimer()
+			m.Outputs.Dong(m.active)
+			m.finish()
+		}
+	case TimerFired:
+		if m.state == held {
+			m.state = playing
+			m.Outputs.PlayTune(m.active)
+		}
+	case TuneDone:
+		if m.state == playing {
+			m.finish()
+		}
+	}
+}
+
+// Active returns the button currently being handled,
+// or -1 if there is none.
+func (m *Machine) Active() int {
+	if m.state == idle {
+		return -1
+	}
+	return m.active
+}
+
+// start starts handling the given button.
+func (m *Machine) start(button int) {
+	m.active = button
+	m.Outputs.Ding(button)
+	if m.pressed&(1<<button) == 0 {
+		// The button has already been released
+		// (it must have been queued).
+		m.Outputs.Dong(button)
+		m.finish()
+		return
+	}
+	m.state = held
+	m.Clock.StartTimer(m.LongPress)
+}
+
+// finish finishes handling the active button and
+// starts handling the next queued button if there is one.
+func (m *Machine) finish() {
+	m.state = idle
+	if m.waiting == 0 {
+		return
+	}
+	next := -1
+	for i := 0; i < MaxButtons; i++ {
+		if m.waiting&(1<<i) == 0 {
+			continue
+		}
+		if next == -1 || m.priority(i) > m.priority(next) {
+			next = i
+		}
+	}
+	m.waiting &^= 1 << next
+	m.start(next)
+}
+
+func (m *Machine) priority(button int) int {
+	if button < len(m.Priorities) {
+		return m.Priorities[button]
+	}
+	return 0
+}
+
+func validButton(button int) bool {
+	return button >= 0 && button < MaxButtons
+}
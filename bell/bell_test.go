@@ -0,0 +1,202 @@
+package bell
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func press(b int) Event {
+	return Event{Kind: Pressed, Button: b}
+}
+
+func release(b int) Event {
+	return Event{Kind: Released, Button: b}
+}
+
+var (
+	timerFired = Event{Kind: TimerFired}
+	tuneDone   = Event{Kind: TuneDone}
+)
+
+var machineTests = []struct {
+	testName string
+	events   []Event
+	// expect holds the expected output calls,
+	// one entry per event.
+	expect [][]string
+	// expectActive holds the expected active
+	// button after all the events.
+	expectActive int
+}{{
+	testName: "short-press",
+	events:   []Event{press(0), release(0)},
+	expect: [][]string{
+		{"ding 0", "start-timer 750ms"},
+		{"stop-timer", "dong 0"},
+	},
+	expectActive: -1,
+}, {
+	testName: "long-press",
+	events:   []Event{press(1), timerFired, release(1), tuneDone},
+	expect: [][]string{
+		{"ding 1", "start-timer 750ms"},
+		{"play-tune 1"},
+		nil,
+		nil,
+	},
+	expectActive: -1,
+}, {
+	testName: "repress-during-tune",
+	events:   []Event{press(1), timerFired, release(1), press(1), release(1), tuneDone},
+	expect: [][]string{
+		{"ding 1", "start-timer 750ms"},
+		{"play-tune 1"},
+		nil,
+		{"stop-tune", "play-tune 1"},
+		nil,
+		nil,
+	},
+	expectActive: -1,
+}, {
+	testName: "tune-still-playing",
+	events:   []Event{press(1), timerFired, release(1)},
+	expect: [][]string{
+		{"ding 1", "start-timer 750ms"},
+		{"play-tune 1"},
+		nil,
+	},
+	expectActive: 1,
+}, {
+	testName: "queued-short-press",
+	events:   []Event{press(0), press(2), release(2), release(0)},
+	expect: [][]string{
+		{"ding 0", "start-timer 750ms"},
+		nil,
+		nil,
+		{"stop-timer", "dong 0", "ding 2", "dong 2"},
+	},
+	expectActive: -1,
+}, {
+	testName: "queued-press-still-held",
+	events:   []Event{press(0), press(2), release(0), timerFired},
+	expect: [][]string{
+		{"ding 0", "start-timer 750ms"},
+		nil,
+		{"stop-timer", "dong 0", "ding 2", "start-timer 750ms"},
+		{"play-tune 2"},
+	},
+	expectActive: 2,
+}, {
+	testName: "queued-during-tune",
+	events:   []Event{press(0), timerFired, release(0), press(3), release(3), press(0), tuneDone},
+	expect: [][]string{
+		{"ding 0", "start-timer 750ms"},
+		{"play-tune 0"},
+		nil,
+		nil,
+		nil,
+		{"stop-tune", "play-tune 0"},
+		{"ding 3", "dong 3"},
+	},
+	expectActive: -1,
+}, {
+	testName: "priority",
+	events:   []Event{press(4), press(2), press(3), press(1), release(1), release(2), release(3), release(4)},
+	expect: [][]string{
+		{"ding 4", "start-timer 750ms"},
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		// Button 3 has a higher priority than the others;
+		// buttons 1 and 2 have the same priority so are
+		// handled in button order.
+		{"stop-timer", "dong 4", "ding 3", "dong 3", "ding 1", "dong 1", "ding 2", "dong 2"},
+	},
+	expectActive: -1,
+}, {
+	testName: "simultaneous-press",
+	events:   []Event{press(2), press(3), release(3), release(2)},
+	expect: [][]string{
+		{"ding 2", "start-timer 750ms"},
+		nil,
+		nil,
+		{"stop-timer", "dong 2", "ding 3", "dong 3"},
+	},
+	expectActive: -1,
+}, {
+	testName: "spurious-events",
+	events:   []Event{release(0), timerFired, tuneDone, press(-1), press(MaxButtons), press(0), press(0)},
+	expect: [][]string{
+		nil,
+		nil,
+		nil,
+		nil,
+		nil,
+		{"ding 0", "start-timer 750ms"},
+		nil,
+	},
+	expectActive: 0,
+}}
+
+func TestMachine(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range machineTests {
+		c.Run(test.testName, func(c *qt.C) {
+			c.Assert(test.events, qt.HasLen, len(test.expect))
+			var rec recorder
+			m := &Machine{
+				Outputs:    &rec,
+				Clock:      &rec,
+				LongPress:  750 * time.Millisecond,
+				Priorities: []int{0, 0, 0, 1},
+			}
+			for i, e := range test.events {
+				rec.calls = nil
+				m.Handle(e)
+				c.Assert(rec.calls, qt.DeepEquals, test.expect[i], qt.Commentf("event %d (%v %d)", i, e.Kind, e.Button))
+			}
+			c.Assert(m.Active(), qt.Equals, test.expectActive)
+		})
+	}
+}
+
+func TestEventKindString(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(TimerFired.String(), qt.Equals, "timer-fired")
+	c.Assert(EventKind(99).String(), qt.Equals, "EventKind(99)")
+}
+
+// recorder implements Outputs and Clock by recording the calls made.
+type recorder struct {
+	calls []string
+}
+
+func (r *recorder) Ding(button int) {
+	r.calls = append(r.calls, fmt.Sprint("ding ", button))
+}
+
+func (r *recorder) Dong(button int) {
+	r.calls = append(r.calls, fmt.Sprint("dong ", button))
+}
+
+func (r *recorder) PlayTune(button int) {
+	r.calls = append(r.calls, fmt.Sprint("play-tune ", button))
+}
+
+func (r *recorder) StopTune() {
+	r.calls = append(r.calls, "stop-tune")
+}
+
+func (r *recorder) StartTimer(d time.Duration) {
+	r.calls = append(r.calls, fmt.Sprint("start-timer ", d))
+}
+
+func (r *recorder) StopTimer() {
+	r.calls = append(r.calls, "stop-timer")
+}
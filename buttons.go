@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/rogpeppe/doorbell/gesture"
+	"github.com/rogpeppe/doorbell/sequence"
+)
+
+// buttonConfig holds the behaviour of a door button.
+type buttonConfig struct {
+	// name holds the colour of the button (see doc/board.txt).
+	name string
+
+	// ding and dong hold the actions played when the button
+	// is pressed and released respectively.
+	ding, dong []sequence.Action
+
+	// tunes holds the names of the tunes that can be played
+	// when the button is held down. If it's empty or none of
+	// the tunes exist, any tune can be played.
+	tunes []string
+
+	// priority determines which button is handled first when
+	// several are waiting (see bell.Machine.Priorities).
+	priority int
+}
+
+// buttonConfigs holds the configuration for each
+// button, indexed by button number.
+//
+// See the bell package for what happens when several
+// buttons are pressed at once: in short, presses are queued
+// so visitors at other doors always get at least their own
+// ding-dong, but they can't interrupt a tune that's playing.
+var buttonConfigs = []buttonConfig{{
+	name:     "black",
+	ding:     dingActions,
+	dong:     dongActions,
+	priority: 1,
+}, {
+	name: "white",
+	ding: chime(noteE2),
+	dong: chime(noteC2),
+}, {
+	name:  "red",
+	ding:  chime(noteD2),
+	dong:  chime(noteA1),
+	tunes: []string{"happy-birthday"},
+}, {
+	name:  "green",
+	ding:  chime(noteF2),
+	dong:  chime(noteF1),
+	tunes: []string{"ripple"},
+}, {
+	name:  "blue",
+	ding:  chime(noteB2),
+	dong:  chime(noteG2),
+	tunes: []string{"sequence"},
+}}
+
+// buttonName returns the name of the given button.
+func buttonName(button int) string {
+	if button < 0 || button >= len(buttonConfigs) {
+		return "?"
+	}
+	return buttonConfigs[button].name
+}
+
+// buttonPriorities returns the priority of each
+// button, indexed by button number.
+func buttonPriorities() []int {
+	priorities := make([]int, len(buttonConfigs))
+	for i := range buttonConfigs {
+		priorities[i] = buttonConfigs[i].priority
+	}
+	return priorities
+}
+
+// indoorGestures holds the gesture configuration
+// for the indoor buttons (see layout.Button.Indoor).
+var indoorGestures = gesture.Config{
+	DoubleClick: 400 * time.Millisecond,
+}
+
+// tunePool returns the tunes out of all that
+// can be played for the button.
+func (cfg *buttonConfig) tunePool(all []tune) []tune {
+	if len(cfg.tunes) == 0 {
+		return all
+	}
+	var pool []tune
+	for _, t := range all {
+		for _, name := range cfg.tunes {
+			if t.name == name {
+				pool = append(pool, t)
+				break
+			}
+		}
+	}
+	if len(pool) == 0 {
+		return all
+	}
+	return pool
+}
+
+// newButtonSelections returns a tune selection for
+// each button, indexed by button number.
+func newButtonSelections(tunes []tune, rand *rand.Rand) []*tuneSelection {
+	selections := make([]*tuneSelection, len(buttonConfigs))
+	for i := range buttonConfigs {
+		selections[i] = newTuneSelection(buttonConfigs[i].tunePool(tunes), rand)
+	}
+	return selections
+}
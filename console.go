@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rogpeppe/doorbell/console"
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/protect"
+	"github.com/rogpeppe/doorbell/sequence"
+	"github.com/rogpeppe/doorbell/timer"
+	"github.com/rogpeppe/doorbell/tunestore"
+)
+
+// consoleDoorbell implements console.Doorbell.
+type consoleDoorbell struct {
+	cfg       *settings
+	solenoids *protect.Protector
+	buttons   *buttonDevice
+	store     tunestore.Store
+	// newTunes is used to tell the player about
+	// changes to the tunes.
+	newTunes chan []tune
+	display  *statusDisplay
+
+	mu    sync.Mutex
+	timer *timer.Timer
+	// stop and done are non-nil when a tune
+	// started from the console might be playing.
+	stop chan struct{}
+	done chan struct{}
+}
+
+var _ console.Doorbell = (*consoleDoorbell)(nil)
+
+// Play implements console.Doorbell.Play.
+func (d *consoleDoorbell) Play(name string) error {
+	data, err := d.store.Load(name)
+	if err != nil {
+		return err
+	}
+	actions := sequence.ActionsForTune(d.solenoids.Len(), data, solenoidDuration)
+	actions = sequence.Resize(d.cfg.widths())(actions)
+	d.start(actions)
+	d.display.playing(name, actions)
+	return nil
+}
+
+// Stop implements console.Doorbell.Stop.
+func (d *consoleDoorbell) Stop() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopLocked()
+	d.display.stopped()
+	return nil
+}
+
+// Fire implements console.Doorbell.Fire.
+func (d *consoleDoorbell) Fire(ch int, dur time.Duration) error {
+	if ch < 0 || ch >= d.solenoids.Len() {
+		return errors.New("channel " + strconv.Itoa(ch) + " out of range")
+	}
+	d.start([]sequence.Action{{
+		Chan: uint8(ch),
+		On:   true,
+	}, {
+		Chan: uint8(ch),
+		On:   false,
+		When: dur,
+	}})
+	return nil
+}
+
+// Calibrate implements console.Doorbell.Calibrate.
+func (d *consoleDoorbell) Calibrate(chans []int, widths []time.Duration) error {
+	chans8 := make([]uint8, len(chans))
+	for i, ch := range chans {
+		if ch < 0 || ch >= d.solenoids.Len() {
+			return errors.New("channel " + strconv.Itoa(ch) + " out of range")
+		}
+		chans8[i] = uint8(ch)
+	}
+	actions := sequence.Calibration(chans8, widths, calibrationInterval)
+	d.start(actions)
+	d.display.playing("calibrate", actions)
+	return nil
+}
+
+// Buttons implements console.Doorbell.Buttons.
+func (d *consoleDoorbell) Buttons() (mcp23017.Pins, error) {
+	return d.buttons.buttons()
+}
+
+// TunesChanged implements console.Doorbell.TunesChanged
+// by reading the tunes again and sending them to the player.
+func (d *consoleDoorbell) TunesChanged() {
+	tunes, err := readTunes(d.store)
+	if err != nil {
+		println("cannot read tunes: ", err.Error())
+		return
+	}
+	// Replace any tunes that the player hasn't picked up yet.
+	select {
+	case <-d.newTunes:
+	default:
+	}
+	d.newTunes <- tunes
+}
+
+// start stops anything that's currently playing and
+// starts playing the given actions.
+func (d *consoleDoorbell) start(actions []sequence.Action) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopLocked()
+	if d.timer == nil {
+		d.timer = timer.NewTimer()
+	}
+	d.stop = make(chan struct{})
+	d.done = make(chan struct{}, 1)
+	go Play(d.timer, d.solenoids, actions, d.stop, d.done)
+}
+
+// stopLocked stops any currently playing tune and waits for
+// it to finish. It must be called with d.mu held.
+func (d *consoleDoorbell) stopLocked() {
+	if d.stop == nil {
+		return
+	}
+	select {
+	case d.stop <- struct{}{}:
+		<-d.done
+	case <-d.done:
+	}
+	d.stop, d.done = nil, nil
+}
+
+// serveConsole serves the console protocol on the serial port.
+func serveConsole(c *console.Console) {
+	r, w := getSerial()
+	for {
+		if err := c.Serve(r, w); err != nil {
+			println("console error: ", err.Error())
+		}
+		time.Sleep(time.Second)
+	}
+}
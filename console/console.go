@@ -0,0 +1,452 @@
+// Package console implements a line-oriented command protocol
+// for managing the doorbell over a serial port.
+//
+// Each command is a single line holding space-separated words.
+// The response to a command is zero or more lines of output
+// followed by a line holding "ok" if the command succeeded or
+// "error: " followed by a message if it failed.
+//
+// The available commands are:
+//
+//	help                  list the available commands
+//	list                  list the names of all the tunes
+//	play NAME             play the named tune
+//	stop                  stop any tune that's playing
+//	upload NAME HEX       store a tune in the format read by
+//	                      sequence.ActionsForTune, hex-encoded
+//	delete NAME           delete a tune
+//	fire CHAN [DURATION]  activate a solenoid (for testing)
+//	calibrate CHAN|all [WIDTH...]
+//	                      strike a solenoid, or each one in turn,
+//	                      once for each pulse width
+//	buttons               print the current button state
+//	config                print all configuration settings
+//	get KEY               print a configuration setting
+//	set KEY VALUE...      change a configuration setting; the
+//	                      value is the rest of the line
+//	log [hex|clear]       print the event log, print it hex-encoded
+//	                      (see eventlog.Decode) or clear it
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rogpeppe/doorbell/eventlog"
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/sequence"
+	"github.com/rogpeppe/doorbell/tunestore"
+)
+
+// MaxTuneSize holds the maximum size in bytes of a tune
+// that can be uploaded.
+const MaxTuneSize = 3 * 2048
+
+// maxLineLen holds the maximum length of a command line.
+// It's long enough for an upload command with the
+// longest name and the largest tune.
+const maxLineLen = len("upload  ") + tunestore.MaxNameLen + 2*MaxTuneSize
+
+// Doorbell represents the operations on the doorbell
+// that are available to the console.
+type Doorbell interface {
+	// Play starts the named tune playing.
+	Play(name string) error
+
+	// Stop stops any currently playing tune.
+	Stop() error
+
+	// Fire activates the solenoid on the given channel
+	// for the given length of time.
+	Fire(ch int, d time.Duration) error
+
+	// Calibrate strikes each of the given channels in turn,
+	// once for each of the given pulse widths.
+	Calibrate(chans []int, widths []time.Duration) error
+
+	// Buttons returns the current state of the door buttons.
+	Buttons() (mcp23017.Pins, error)
+
+	// TunesChanged is called when tunes have been
+	// added to or removed from the tune store.
+	TunesChanged()
+}
+
+// Config represents a set of configuration settings.
+type Config interface {
+	// Keys returns all the available setting names.
+	Keys() []string
+
+	// Get returns the value of the given setting.
+	Get(key string) (string, error)
+
+	// Set sets the value of the given setting.
+	Set(key, value string) error
+}
+
+// Console serves the command protocol.
+type Console struct {
+	// Doorbell is used to control the doorbell.
+	Doorbell Doorbell
+
+	// Tunes holds the tunes.
+	Tunes tunestore.Store
+
+	// Config holds the configuration settings.
+	Config Config
+
+	// ChanCount holds the number of available channels.
+	// It's used to check uploaded tunes and the fire command.
+	ChanCount int
+
+	// FireDuration holds the default duration
+	// for the fire command.
+	FireDuration time.Duration
+
+	// Events holds the event log. If it's nil,
+	// the log command fails.
+	Events *eventlog.Log
+}
+
+// Serve reads commands from r and writes responses to w
+// until r returns an error. It returns nil if r reached EOF.
+//
+// Lines can be terminated by a newline or a carriage return
+// so that it works with terminal programs that send either.
+// Empty lines are ignored. Lines longer than an upload
+// command for a tune of MaxTuneSize bytes get an error
+// response.
+func (c *Console) Serve(r io.Reader, w io.Writer) error {
+	lines := &lineSplitter{maxLen: maxLineLen}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxLineLen+1)
+	scanner.Split(lines.split)
+	bw := bufio.NewWriter(w)
+	for scanner.Scan() {
+		out := &output{w: bw}
+		if lines.tooLong {
+			out.println("error: line too long (maximum " + strconv.Itoa(maxLineLen) + " bytes)")
+		} else {
+			words := strings.Fields(scanner.Text())
+			if len(words) == 0 {
+				continue
+			}
+			if err := c.run(out, words); err != nil {
+				out.println("error: " + err.Error())
+			} else {
+				out.println("ok")
+			}
+		}
+		if err := bw.Flush(); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// command holds a command implementation.
+type command struct {
+	usage string
+	// minArgs and maxArgs hold the allowed number of arguments.
+	// If maxArgs is negative, there's no maximum.
+	minArgs, maxArgs int
+	run              func(c *Console, out *output, args []string) error
+}
+
+// commands holds all the available commands. It's initialized
+// in init to avoid an initialization loop with cmdHelp.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"help":      {"help", 0, 0, (*Console).cmdHelp},
+		"list":      {"list", 0, 0, (*Console).cmdList},
+		"play":      {"play NAME", 1, 1, (*Console).cmdPlay},
+		"stop":      {"stop", 0, 0, (*Console).cmdStop},
+		"upload":    {"upload NAME HEX", 2, 2, (*Console).cmdUpload},
+		"delete":    {"delete NAME", 1, 1, (*Console).cmdDelete},
+		"fire":      {"fire CHAN [DURATION]", 1, 2, (*Console).cmdFire},
+		"calibrate": {"calibrate CHAN|all [WIDTH...]", 1, -1, (*Console).cmdCalibrate},
+		"buttons":   {"buttons", 0, 0, (*Console).cmdButtons},
+		"config":    {"config", 0, 0, (*Console).cmdConfig},
+		"get":       {"get KEY", 1, 1, (*Console).cmdGet},
+		"set":       {"set KEY VALUE...", 2, -1, (*Console).cmdSet},
+		"log":       {"log [hex|clear]", 0, 1, (*Console).cmdLog},
+	}
+}
+
+// commandOrder holds the order that commands are listed by help.
+var commandOrder = []string{
+	"help",
+	"list",
+	"play",
+	"stop",
+	"upload",
+	"delete",
+	"fire",
+	"calibrate",
+	"buttons",
+	"config",
+	"get",
+	"set",
+	"log",
+}
+
+func (c *Console) run(out *output, words []string) error {
+	cmd, ok := commands[words[0]]
+	if !ok {
+		return errors.New("unknown command " + strconv.Quote(words[0]) + "; try help")
+	}
+	args := words[1:]
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		return errors.New("usage: " + cmd.usage)
+	}
+	return cmd.run(c, out, args)
+}
+
+func (c *Console) cmdHelp(out *output, args []string) error {
+	for _, name := range commandOrder {
+		out.println(commands[name].usage)
+	}
+	return nil
+}
+
+func (c *Console) cmdList(out *output, args []string) error {
+	names, err := c.Tunes.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		out.println(name)
+	}
+	return nil
+}
+
+func (c *Console) cmdPlay(out *output, args []string) error {
+	return c.Doorbell.Play(args[0])
+}
+
+func (c *Console) cmdStop(out *output, args []string) error {
+	return c.Doorbell.Stop()
+}
+
+func (c *Console) cmdUpload(out *output, args []string) error {
+	name := args[0]
+	if err := tunestore.CheckName(name); err != nil {
+		return err
+	}
+	data, err := hex.DecodeString(args[1])
+	if err != nil {
+		return errors.New("invalid hex data")
+	}
+	if len(data)%3 != 0 {
+		return errors.New("tune data length must be a multiple of 3")
+	}
+	if len(data) > MaxTuneSize {
+		return errors.New("tune too large (maximum " + strconv.Itoa(MaxTuneSize) + " bytes)")
+	}
+	for i := 2; i < len(data); i += 3 {
+		// Long gaps are padded with rests (see sequence.TuneForActions).
+		if int(data[i]) >= c.ChanCount && data[i] != sequence.RestChan {
+			return errors.New("channel " + strconv.Itoa(int(data[i])) + " out of range at entry " + strconv.Itoa(i/3))
+		}
+	}
+	if err := c.Tunes.Add(name, data); err != nil {
+		return err
+	}
+	c.Doorbell.TunesChanged()
+	return nil
+}
+
+func (c *Console) cmdDelete(out *output, args []string) error {
+	if err := c.Tunes.Delete(args[0]); err != nil {
+		return err
+	}
+	c.Doorbell.TunesChanged()
+	return nil
+}
+
+func (c *Console) cmdFire(out *output, args []string) error {
+	ch, err := strconv.Atoi(args[0])
+	if err != nil || ch < 0 || ch >= c.ChanCount {
+		return errors.New("invalid channel " + strconv.Quote(args[0]))
+	}
+	d := c.FireDuration
+	if len(args) > 1 {
+		d, err = time.ParseDuration(args[1])
+		if err != nil || d <= 0 {
+			return errors.New("invalid duration " + strconv.Quote(args[1]))
+		}
+	}
+	return c.Doorbell.Fire(ch, d)
+}
+
+// calibrationWidths holds the pulse widths used by
+// the calibrate command when none are specified.
+var calibrationWidths = []time.Duration{
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	150 * time.Millisecond,
+	200 * time.Millisecond,
+	250 * time.Millisecond,
+	300 * time.Millisecond,
+}
+
+func (c *Console) cmdCalibrate(out *output, args []string) error {
+	var chans []int
+	if args[0] == "all" {
+		for ch := 0; ch < c.ChanCount; ch++ {
+			chans = append(chans, ch)
+		}
+	} else {
+		ch, err := strconv.Atoi(args[0])
+		if err != nil || ch < 0 || ch >= c.ChanCount {
+			return errors.New("invalid channel " + strconv.Quote(args[0]))
+		}
+		chans = []int{ch}
+	}
+	widths := calibrationWidths
+	if len(args) > 1 {
+		widths = make([]time.Duration, len(args)-1)
+		for i, arg := range args[1:] {
+			d, err := time.ParseDuration(arg)
+			if err != nil || d <= 0 {
+				return errors.New("invalid duration " + strconv.Quote(arg))
+			}
+			widths[i] = d
+		}
+	}
+	return c.Doorbell.Calibrate(chans, widths)
+}
+
+func (c *Console) cmdButtons(out *output, args []string) error {
+	buttons, err := c.Doorbell.Buttons()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for i := 0; i < mcp23017.PinCount; i++ {
+		if buttons.Get(i) {
+			if buf.Len() > 0 {
+				buf.WriteByte(' ')
+			}
+			buf.WriteString(strconv.Itoa(i))
+		}
+	}
+	out.println("pressed: " + buf.String())
+	return nil
+}
+
+func (c *Console) cmdConfig(out *output, args []string) error {
+	for _, key := range c.Config.Keys() {
+		val, err := c.Config.Get(key)
+		if err != nil {
+			return err
+		}
+		out.println(key + " " + val)
+	}
+	return nil
+}
+
+func (c *Console) cmdGet(out *output, args []string) error {
+	val, err := c.Config.Get(args[0])
+	if err != nil {
+		return err
+	}
+	out.println(val)
+	return nil
+}
+
+func (c *Console) cmdSet(out *output, args []string) error {
+	// Note: the words have been split on white space,
+	// so any runs of white space in the value become single spaces.
+	return c.Config.Set(args[0], strings.Join(args[1:], " "))
+}
+
+func (c *Console) cmdLog(out *output, args []string) error {
+	if c.Events == nil {
+		return errors.New("no event log available")
+	}
+	if len(args) == 0 {
+		for _, e := range c.Events.Entries() {
+			out.println(e.String())
+		}
+		return nil
+	}
+	switch args[0] {
+	case "hex":
+		data, err := c.Events.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		out.println(hex.EncodeToString(data))
+	case "clear":
+		c.Events.Clear()
+	default:
+		return errors.New("usage: " + commands["log"].usage)
+	}
+	return nil
+}
+
+// output writes command output, ignoring errors because
+// they'll be reported when the output is flushed.
+type output struct {
+	w *bufio.Writer
+}
+
+func (out *output) println(s string) {
+	out.w.WriteString(s)
+	out.w.WriteByte('\n')
+}
+
+// lineSplitter splits lines terminated by \n, \r or \r\n.
+// Rather than failing on a line that's longer than maxLen,
+// it skips the line and sets tooLong.
+type lineSplitter struct {
+	maxLen int
+	// skipping holds whether the rest of a long
+	// line is being skipped.
+	skipping bool
+	// tooLong holds whether the most recent token
+	// replaced a line that was too long.
+	tooLong bool
+}
+
+// split implements bufio.SplitFunc. A line that's too long
+// is returned as an empty token with s.tooLong set.
+func (s *lineSplitter) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	s.tooLong = false
+	for i, b := range data {
+		if b != '\n' && b != '\r' {
+			continue
+		}
+		if s.skipping {
+			s.skipping = false
+			s.tooLong = true
+			return i + 1, data[:0], nil
+		}
+		// Note: an \r\n sequence is treated as two line endings
+		// with an empty line in between, which is then ignored.
+		return i + 1, data[:i], nil
+	}
+	if s.skipping || len(data) > s.maxLen {
+		// Discard what we've got so far so that the
+		// scanner's buffer doesn't overflow.
+		s.skipping = !atEOF
+		s.tooLong = atEOF
+		if atEOF {
+			return len(data), data[:0], nil
+		}
+		return len(data), nil, nil
+	}
+	if atEOF && len(data) > 0 {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
@@ -0,0 +1,383 @@
+package console
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/eventlog"
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/tunestore"
+)
+
+var serveTests = []struct {
+	testName     string
+	input        string
+	expectOutput string
+	expectCalls  []string
+	expectTunes  []string
+}{{
+	testName: "help",
+	input:    "help\n",
+	expectOutput: `
+help
+list
+play NAME
+stop
+upload NAME HEX
+delete NAME
+fire CHAN [DURATION]
+calibrate CHAN|all [WIDTH...]
+buttons
+config
+get KEY
+set KEY VALUE...
+log [hex|clear]
+ok
+`,
+}, {
+	testName: "list",
+	input:    "list\n",
+	expectOutput: `
+bar
+foo
+ok
+`,
+}, {
+	testName: "play-and-stop",
+	input:    "play foo\nplay other\nstop\n",
+	expectOutput: `
+ok
+error: tune not found
+ok
+`,
+	expectCalls: []string{"play foo", "play other", "stop"},
+}, {
+	testName: "carriage-returns-and-blank-lines",
+	input:    "stop\r\r\n   \nstop\r",
+	expectOutput: `
+ok
+ok
+`,
+	expectCalls: []string{"stop", "stop"},
+}, {
+	testName: "upload",
+	input:    "upload new 000102fa0003\nlist\n",
+	expectOutput: `
+ok
+bar
+foo
+new
+ok
+`,
+	expectCalls: []string{"tunes changed"},
+	expectTunes: []string{"bar", "foo", "new"},
+}, {
+	testName: "upload-with-rest",
+	input:    "upload long ffffff000102\n",
+	expectOutput: `
+ok
+`,
+	expectCalls: []string{"tunes changed"},
+	expectTunes: []string{"bar", "foo", "long"},
+}, {
+	testName: "upload-errors",
+	input: `
+upload new 0001
+upload new 00010x
+upload new 000118
+upload new 0001fe
+upload ../x 000101
+upload new
+`,
+	expectOutput: `
+error: tune data length must be a multiple of 3
+error: invalid hex data
+error: channel 24 out of range at entry 0
+error: channel 254 out of range at entry 0
+error: invalid tune name
+error: usage: upload NAME HEX
+`,
+	expectTunes: []string{"bar", "foo"},
+}, {
+	testName: "upload-largest",
+	input:    "upload " + strings.Repeat("x", tunestore.MaxNameLen) + " " + strings.Repeat("000001", MaxTuneSize/3) + "\n",
+	expectOutput: `
+ok
+`,
+	expectCalls: []string{"tunes changed"},
+	expectTunes: []string{"bar", "foo", strings.Repeat("x", tunestore.MaxNameLen)},
+}, {
+	testName: "upload-too-large",
+	input:    "upload new " + strings.Repeat("000001", MaxTuneSize/3+1) + "\n",
+	expectOutput: `
+error: tune too large (maximum 6144 bytes)
+`,
+}, {
+	testName: "line-too-long",
+	input:    "upload new " + strings.Repeat("000001", MaxTuneSize) + "\r\nlist\nupload x " + strings.Repeat("0", 2*maxLineLen),
+	expectOutput: `
+error: line too long (maximum 12360 bytes)
+bar
+foo
+ok
+error: line too long (maximum 12360 bytes)
+`,
+}, {
+	testName: "delete",
+	input:    "delete foo\ndelete foo\n",
+	expectOutput: `
+ok
+error: tune not found
+`,
+	expectCalls: []string{"tunes changed"},
+	expectTunes: []string{"bar"},
+}, {
+	testName: "fire",
+	input:    "fire 3\nfire 23 50ms\nfire 24\nfire 1 xx\nfire 1 -1s\n",
+	expectOutput: `
+ok
+ok
+error: invalid channel "24"
+error: invalid duration "xx"
+error: invalid duration "-1s"
+`,
+	expectCalls: []string{"fire 3 200ms", "fire 23 50ms"},
+}, {
+	testName: "calibrate",
+	input:    "calibrate 3\ncalibrate 23 20ms 1s\ncalibrate all 100ms\ncalibrate 24\ncalibrate 1 50ms x\ncalibrate\n",
+	expectOutput: `
+ok
+ok
+ok
+error: invalid channel "24"
+error: invalid duration "x"
+error: usage: calibrate CHAN|all [WIDTH...]
+`,
+	expectCalls: []string{
+		"calibrate 3: 50ms 100ms 150ms 200ms 250ms 300ms",
+		"calibrate 23: 20ms 1s",
+		"calibrate 0 1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20 21 22 23: 100ms",
+	},
+}, {
+	testName: "buttons",
+	input:    "buttons\n",
+	expectOutput: `
+pressed: 0 3
+ok
+`,
+}, {
+	testName: "config",
+	input:    "config\nget a\nset b 99\nget b\nget c\nset c 1\nset a x  y\nget a\n",
+	expectOutput: `
+a 1
+b 2
+ok
+1
+ok
+ok
+99
+ok
+error: unknown key "c"
+error: unknown key "c"
+ok
+x y
+ok
+`,
+}, {
+	testName: "log",
+	input:    "log\nlog hex\nlog clear\nlog\nlog foo\n",
+	expectOutput: `
+2020-01-01 12:00:00.000 buttons 0
+2020-01-01 12:00:00.250 tune foo
+ok
+4442454c0200000001eebe48010001000016fa606f010000000000000000000000000000000000000000000003000000fa16fa606f01000003666f6f00000000000000000000000000000000
+ok
+ok
+ok
+error: usage: log [hex|clear]
+`,
+}, {
+	testName: "unknown-command",
+	input:    "foo bar\nlist x\n",
+	expectOutput: `
+error: unknown command "foo"; try help
+error: usage: list
+`,
+}}
+
+func TestServe(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range serveTests {
+		c.Run(test.testName, func(c *qt.C) {
+			store := tunestore.NewMemStore()
+			store.Add("foo", []byte{0, 0, 1})
+			store.Add("bar", []byte{0, 0, 2})
+			doorbell := &fakeDoorbell{
+				tunes:   store,
+				buttons: 0b1001,
+			}
+			console := &Console{
+				Doorbell: doorbell,
+				Tunes:    store,
+				Config: &fakeConfig{
+					vals: map[string]string{
+						"a": "1",
+						"b": "2",
+					},
+				},
+				ChanCount:    24,
+				FireDuration: 200 * time.Millisecond,
+				Events:       newEvents(),
+			}
+			var out bytes.Buffer
+			err := console.Serve(strings.NewReader(test.input), &out)
+			c.Assert(err, qt.IsNil)
+			c.Assert(out.String(), qt.Equals, strings.TrimPrefix(test.expectOutput, "\n"))
+			c.Assert(doorbell.calls, qt.DeepEquals, test.expectCalls)
+			expectTunes := test.expectTunes
+			if expectTunes == nil {
+				expectTunes = []string{"bar", "foo"}
+			}
+			names, err := store.List()
+			c.Assert(err, qt.IsNil)
+			c.Assert(names, qt.DeepEquals, expectTunes)
+		})
+	}
+}
+
+func TestServeInteractive(t *testing.T) {
+	c := qt.New(t)
+	store := tunestore.NewMemStore()
+	store.Add("foo", []byte{0, 0, 1})
+	console := &Console{
+		Doorbell:  &fakeDoorbell{tunes: store},
+		Tunes:     store,
+		ChanCount: 24,
+	}
+	inr, inw := io.Pipe()
+	outr, outw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- console.Serve(inr, outw)
+	}()
+	out := bufio.NewReader(outr)
+	// Each response should be available as soon as the
+	// command has been sent.
+	for i := 0; i < 2; i++ {
+		_, err := io.WriteString(inw, "list\r")
+		c.Assert(err, qt.IsNil)
+		for _, want := range []string{"foo\n", "ok\n"} {
+			line, err := out.ReadString('\n')
+			c.Assert(err, qt.IsNil)
+			c.Assert(line, qt.Equals, want)
+		}
+	}
+	inw.CloseWithError(errors.New("serial port closed"))
+	c.Assert(<-done, qt.ErrorMatches, "serial port closed")
+}
+
+func TestLogWithoutEvents(t *testing.T) {
+	c := qt.New(t)
+	console := &Console{}
+	var out bytes.Buffer
+	err := console.Serve(strings.NewReader("log\n"), &out)
+	c.Assert(err, qt.IsNil)
+	c.Assert(out.String(), qt.Equals, "error: no event log available\n")
+}
+
+func newEvents() *eventlog.Log {
+	epoch := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := eventlog.New(10)
+	events.Add(eventlog.Entry{
+		Kind:    eventlog.Buttons,
+		Time:    epoch,
+		Buttons: 0b1,
+	})
+	events.Add(eventlog.Entry{
+		Kind: eventlog.Tune,
+		Time: epoch.Add(250 * time.Millisecond),
+		Text: "foo",
+	})
+	return events
+}
+
+type fakeDoorbell struct {
+	tunes   tunestore.Store
+	buttons mcp23017.Pins
+	calls   []string
+}
+
+func (d *fakeDoorbell) Play(name string) error {
+	d.calls = append(d.calls, "play "+name)
+	_, err := d.tunes.Load(name)
+	return err
+}
+
+func (d *fakeDoorbell) Stop() error {
+	d.calls = append(d.calls, "stop")
+	return nil
+}
+
+func (d *fakeDoorbell) Fire(ch int, dt time.Duration) error {
+	d.calls = append(d.calls, "fire "+strconv.Itoa(ch)+" "+dt.String())
+	return nil
+}
+
+func (d *fakeDoorbell) Calibrate(chans []int, widths []time.Duration) error {
+	call := "calibrate"
+	for _, ch := range chans {
+		call += " " + strconv.Itoa(ch)
+	}
+	call += ":"
+	for _, w := range widths {
+		call += " " + w.String()
+	}
+	d.calls = append(d.calls, call)
+	return nil
+}
+
+func (d *fakeDoorbell) Buttons() (mcp23017.Pins, error) {
+	return d.buttons, nil
+}
+
+func (d *fakeDoorbell) TunesChanged() {
+	d.calls = append(d.calls, "tunes changed")
+}
+
+type fakeConfig struct {
+	vals map[string]string
+}
+
+func (cfg *fakeConfig) Keys() []string {
+	var keys []string
+	for key := range cfg.vals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (cfg *fakeConfig) Get(key string) (string, error) {
+	val, ok := cfg.vals[key]
+	if !ok {
+		return "", errors.New("unknown key \"" + key + "\"")
+	}
+	return val, nil
+}
+
+func (cfg *fakeConfig) Set(key, val string) error {
+	if _, ok := cfg.vals[key]; !ok {
+		return errors.New("unknown key \"" + key + "\"")
+	}
+	cfg.vals[key] = val
+	return nil
+}
@@ -1,25 +1,131 @@
 package debounce
 
 import (
+	"errors"
+	"strconv"
 	"time"
 )
 
-// TODO make this configurable.
-const debounceTime = 50 * time.Millisecond
+// DefaultInterval holds the debounce interval used
+// when none is configured.
+const DefaultInterval = 50 * time.Millisecond
+
+// Strategy represents a debouncing algorithm.
+type Strategy uint8
+
+const (
+	// Immediate reports a change as soon as it happens after
+	// a period of stability, then ignores further changes until
+	// the input has been stable for the debounce interval.
+	// This gives the lowest latency and is the default.
+	Immediate Strategy = iota
+
+	// Integrator integrates the input over time: the time the
+	// input spends in the new state counts towards the debounce
+	// interval and the time it spends in the old state counts
+	// against it. The state changes when the total reaches the
+	// interval. This copes well with inputs that are noisy
+	// all the time rather than just bouncing when they change.
+	Integrator
+
+	// Lockout reports a change as soon as it happens and then
+	// ignores the input completely for the debounce interval.
+	Lockout
+
+	// MinHold only reports a change when the input has
+	// held its new value continuously for the debounce interval.
+	// This rejects short glitches at the cost of latency.
+	MinHold
+)
+
+var strategyNames = []string{
+	Immediate:  "immediate",
+	Integrator: "integrator",
+	Lockout:    "lockout",
+	MinHold:    "minhold",
+}
+
+// String returns the name of the strategy in lower case,
+// for example "integrator".
+func (s Strategy) String() string {
+	if int(s) < len(strategyNames) {
+		return strategyNames[s]
+	}
+	return "Strategy(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ParseStrategy returns the strategy with the
+// given name, as returned by Strategy.String.
+func ParseStrategy(name string) (Strategy, error) {
+	for s, sname := range strategyNames {
+		if name == sname {
+			return Strategy(s), nil
+		}
+	}
+	return 0, errors.New("unknown debounce strategy " + strconv.Quote(name))
+}
+
+// Config holds the configuration for a Debouncer.
+// The zero value is OK to use.
+type Config struct {
+	// Strategy holds the debouncing algorithm to use.
+	Strategy Strategy
+
+	// Press holds the debounce interval used when
+	// the input changes to true. If it's zero,
+	// DefaultInterval is used.
+	Press time.Duration
+
+	// Release holds the debounce interval used when
+	// the input changes to false. If it's zero,
+	// DefaultInterval is used.
+	Release time.Duration
+}
+
+// MaxInterval returns the longer of the press and
+// release debounce intervals.
+func (cfg *Config) MaxInterval() time.Duration {
+	press, release := cfg.interval(true), cfg.interval(false)
+	if press > release {
+		return press
+	}
+	return release
+}
+
+// interval returns the debounce interval for a change to the given state.
+func (cfg *Config) interval(state bool) time.Duration {
+	d := cfg.Release
+	if state {
+		d = cfg.Press
+	}
+	if d <= 0 {
+		d = DefaultInterval
+	}
+	return d
+}
 
 // Debouncer implements button debouncing logic.
 // Call Update repeatedly to update the state,
 // and use State to access the stable state.
 // The zero value of a Debouncer is OK to use.
 type Debouncer struct {
+	// Config holds the debouncer configuration.
+	// It should not be changed after Update has been called.
+	Config Config
+
 	stableState bool
 	// stable holds whether the current state is considered stable.
-	isStable      bool
+	isStable bool
 	// state holds the most recently updated state.
-	state       bool
+	state bool
 	// lastChanged holds the time that state changed
 	// most recently.
 	lastChanged time.Time
+	// lastUpdate holds the time of the most recent update.
+	lastUpdate time.Time
+	// level holds the integrated input level used
+	// by the Integrator strategy.
+	level time.Duration
 }
 
 // State returns the most recently known stable state.
@@ -33,6 +139,20 @@ func (d *Debouncer) Update(state bool) {
 }
 
 func (d *Debouncer) updateAtTime(state bool, now time.Time) {
+	switch d.Config.Strategy {
+	case Integrator:
+		d.updateIntegrator(state, now)
+	case Lockout:
+		d.updateLockout(state, now)
+	case MinHold:
+		d.updateMinHold(state, now)
+	default:
+		d.updateImmediate(state, now)
+	}
+	d.lastUpdate = now
+}
+
+func (d *Debouncer) updateImmediate(state bool, now time.Time) {
 	switch {
 	case state != d.state:
 		d.lastChanged = now
@@ -45,8 +165,53 @@ func (d *Debouncer) updateAtTime(state bool, now time.Time) {
 			d.isStable = false
 			d.stableState = state
 		}
-	case now.After(d.lastChanged.Add(debounceTime)):
+	case now.After(d.lastChanged.Add(d.Config.interval(d.stableState))):
 		d.isStable = true
 		d.stableState = state
 	}
 }
+
+func (d *Debouncer) updateIntegrator(state bool, now time.Time) {
+	if d.lastUpdate.IsZero() {
+		d.lastUpdate = now
+	}
+	// The input is assumed to have held its previous
+	// value since the previous update.
+	dt := now.Sub(d.lastUpdate)
+	prev := d.state
+	d.state = state
+	if prev == d.stableState {
+		d.level -= dt
+		if d.level < 0 {
+			d.level = 0
+		}
+		return
+	}
+	d.level += dt
+	if d.level >= d.Config.interval(prev) {
+		d.stableState = prev
+		d.level = 0
+	}
+}
+
+func (d *Debouncer) updateLockout(state bool, now time.Time) {
+	if now.Before(d.lastChanged) {
+		// Still locked out.
+		return
+	}
+	if state != d.stableState {
+		d.stableState = state
+		// Note: lastChanged holds the end of the lockout period.
+		d.lastChanged = now.Add(d.Config.interval(state))
+	}
+}
+
+func (d *Debouncer) updateMinHold(state bool, now time.Time) {
+	if state != d.state {
+		d.state = state
+		d.lastChanged = now
+	}
+	if state != d.stableState && now.Sub(d.lastChanged) >= d.Config.interval(state) {
+		d.stableState = state
+	}
+}
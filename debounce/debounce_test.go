@@ -1,6 +1,7 @@
 package debounce
 
 import (
+	"strings"
 	"testing"
 	"time"
 
@@ -33,7 +34,7 @@ func TestDebouncer(t *testing.T) {
 	d.updateAtTime(true, now)
 	c.Assert(d.State(), qt.Equals, true)
 
-	now = now.Add(debounceTime + 1)
+	now = now.Add(DefaultInterval + 1)
 	d.updateAtTime(true, now)
 	c.Assert(d.State(), qt.Equals, true)
 
@@ -43,3 +44,114 @@ func TestDebouncer(t *testing.T) {
 	d.updateAtTime(false, now)
 	c.Assert(d.State(), qt.Equals, false)
 }
+
+var strategyTests = []struct {
+	testName string
+	config   Config
+	// input holds the input samples, one per millisecond,
+	// with 1 for true and 0 for false. Spaces are ignored.
+	input string
+	// expect holds the expected state after each sample.
+	expect string
+}{{
+	testName: "immediate-bounce",
+	config: Config{
+		Press:   5 * time.Millisecond,
+		Release: 3 * time.Millisecond,
+	},
+	input:  "0000 1010 1111 1111 0101 0000 0000",
+	expect: "0000 1111 1111 1111 0000 0000 0000",
+}, {
+	testName: "immediate-default-interval",
+	input:    "0000 1" + strings.Repeat("0", 51) + "0",
+	expect:   "0000 1" + strings.Repeat("1", 51) + "0",
+}, {
+	testName: "integrator-bounce",
+	config: Config{
+		Strategy: Integrator,
+		Press:    4 * time.Millisecond,
+		Release:  2 * time.Millisecond,
+	},
+	input:  "0000 1010 1111 1111 0101 0000 0000",
+	expect: "0000 0000 0000 1111 1111 1100 0000",
+}, {
+	testName: "integrator-noise",
+	config: Config{
+		Strategy: Integrator,
+		Press:    4 * time.Millisecond,
+		Release:  4 * time.Millisecond,
+	},
+	// Isolated noise spikes never accumulate enough to change the state.
+	input:  "0001 0001 0010 0100 0001 0000",
+	expect: "0000 0000 0000 0000 0000 0000",
+}, {
+	testName: "lockout",
+	config: Config{
+		Strategy: Lockout,
+		Press:    5 * time.Millisecond,
+		Release:  3 * time.Millisecond,
+	},
+	// Unlike Immediate, the lockout ends after the interval
+	// even if the input is still bouncing.
+	input:  "0000 1010 0101 0000 1111",
+	expect: "0000 1111 1100 0000 1111",
+}, {
+	testName: "min-hold",
+	config: Config{
+		Strategy: MinHold,
+		Press:    3 * time.Millisecond,
+		Release:  2 * time.Millisecond,
+	},
+	input:  "0000 1101 1110 1111 0100 0111",
+	expect: "0000 0000 0011 1111 1111 0000",
+}}
+
+func TestStrategies(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range strategyTests {
+		c.Run(test.testName, func(c *qt.C) {
+			d := Debouncer{
+				Config: test.config,
+			}
+			now := time.Now()
+			var got strings.Builder
+			for _, r := range test.input {
+				switch r {
+				case ' ':
+					got.WriteRune(r)
+					continue
+				case '0', '1':
+				default:
+					c.Fatalf("invalid input %q", r)
+				}
+				now = now.Add(time.Millisecond)
+				d.updateAtTime(r == '1', now)
+				if d.State() {
+					got.WriteByte('1')
+				} else {
+					got.WriteByte('0')
+				}
+			}
+			c.Assert(got.String(), qt.Equals, test.expect)
+		})
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+	c := qt.New(t)
+	for _, s := range []Strategy{Immediate, Integrator, Lockout, MinHold} {
+		got, err := ParseStrategy(s.String())
+		c.Assert(err, qt.IsNil)
+		c.Assert(got, qt.Equals, s)
+	}
+	_, err := ParseStrategy("Integrator")
+	c.Assert(err, qt.ErrorMatches, `unknown debounce strategy "Integrator"`)
+	c.Assert(Strategy(99).String(), qt.Equals, "Strategy(99)")
+}
+
+func TestMaxInterval(t *testing.T) {
+	c := qt.New(t)
+	c.Assert((&Config{}).MaxInterval(), qt.Equals, DefaultInterval)
+	c.Assert((&Config{Press: 80 * time.Millisecond}).MaxInterval(), qt.Equals, 80*time.Millisecond)
+	c.Assert((&Config{Press: 10 * time.Millisecond, Release: 20 * time.Millisecond}).MaxInterval(), qt.Equals, 20*time.Millisecond)
+}
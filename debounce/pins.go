@@ -0,0 +1,84 @@
+package debounce
+
+import (
+	"math/bits"
+	"time"
+
+	"github.com/rogpeppe/doorbell/mcp23017"
+)
+
+// PinsDebouncer debounces all the pins of an MCP23017
+// device at once, so that a single read of the device
+// can serve all its inputs. It uses the same algorithm
+// as the Immediate strategy for each pin.
+//
+// The zero value of a PinsDebouncer is OK to use.
+type PinsDebouncer struct {
+	// Press and Release hold the debounce intervals
+	// as for Config.Press and Config.Release.
+	Press, Release time.Duration
+
+	// stable holds the stable state of the pins.
+	stable mcp23017.Pins
+	// settled holds the set of pins whose state is
+	// considered stable.
+	settled mcp23017.Pins
+	// state holds the most recently updated state.
+	state mcp23017.Pins
+	// initialized holds whether Update has been called.
+	initialized bool
+	// lastChanged holds the time that each pin
+	// most recently changed.
+	lastChanged [mcp23017.PinCount]time.Time
+}
+
+// State returns the most recently known stable state of all the pins.
+func (d *PinsDebouncer) State() mcp23017.Pins {
+	return d.stable
+}
+
+// Update updates the debouncer with the latest state of the pins
+// and returns the stable state and the set of pins whose
+// stable state has changed as a result.
+func (d *PinsDebouncer) Update(pins mcp23017.Pins) (stable, changed mcp23017.Pins) {
+	return d.updateAtTime(pins, time.Now())
+}
+
+func (d *PinsDebouncer) updateAtTime(pins mcp23017.Pins, now time.Time) (stable, changed mcp23017.Pins) {
+	if !d.initialized {
+		// Treat the initial state as stable,
+		// as for the zero Debouncer.
+		d.initialized = true
+		d.settled = ^mcp23017.Pins(0)
+	}
+	old := d.stable
+	diff := pins ^ d.state
+	d.state = pins
+
+	// Pins that change after a period of stability
+	// change state immediately.
+	immediate := diff & d.settled
+	d.stable = (d.stable &^ immediate) | (pins & immediate)
+	d.settled &^= diff
+	for m := diff; m != 0; m &= m - 1 {
+		d.lastChanged[bits.TrailingZeros16(uint16(m))] = now
+	}
+
+	// Pins that haven't changed become stable when the
+	// debounce interval has passed since they last changed.
+	for m := ^diff &^ d.settled; m != 0; m &= m - 1 {
+		i := bits.TrailingZeros16(uint16(m))
+		interval := d.Release
+		if d.stable.Get(i) {
+			interval = d.Press
+		}
+		if interval <= 0 {
+			interval = DefaultInterval
+		}
+		if now.After(d.lastChanged[i].Add(interval)) {
+			d.settled.High(i)
+			d.stable.Set(i, pins.Get(i))
+		}
+	}
+	return d.stable, old ^ d.stable
+}
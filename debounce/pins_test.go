@@ -0,0 +1,88 @@
+package debounce
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/mcp23017"
+)
+
+func TestPinsDebouncer(t *testing.T) {
+	c := qt.New(t)
+	d := PinsDebouncer{
+		Press:   5 * time.Millisecond,
+		Release: 3 * time.Millisecond,
+	}
+	now := time.Now()
+	update := func(pins mcp23017.Pins) (stable, changed mcp23017.Pins) {
+		now = now.Add(time.Millisecond)
+		return d.updateAtTime(pins, now)
+	}
+	stable, changed := update(0)
+	c.Assert(stable, qt.Equals, mcp23017.Pins(0))
+	c.Assert(changed, qt.Equals, mcp23017.Pins(0))
+
+	// Pins change state immediately after a period of stability.
+	stable, changed = update(0b1001)
+	c.Assert(stable, qt.Equals, mcp23017.Pins(0b1001))
+	c.Assert(changed, qt.Equals, mcp23017.Pins(0b1001))
+
+	// Pins 1 and 2 change immediately because they were stable;
+	// the bounces on pins 0 and 3 are ignored.
+	stable, changed = update(0b0110)
+	c.Assert(stable, qt.Equals, mcp23017.Pins(0b1111))
+	c.Assert(changed, qt.Equals, mcp23017.Pins(0b0110))
+	c.Assert(d.State(), qt.Equals, mcp23017.Pins(0b1111))
+
+	// After the press interval, pins 0 and 3 settle
+	// to the current input.
+	for i := 0; i < 5; i++ {
+		stable, changed = update(0b0110)
+		c.Assert(stable, qt.Equals, mcp23017.Pins(0b1111), qt.Commentf("iteration %d", i))
+		c.Assert(changed, qt.Equals, mcp23017.Pins(0), qt.Commentf("iteration %d", i))
+	}
+	stable, changed = update(0b0110)
+	c.Assert(stable, qt.Equals, mcp23017.Pins(0b0110))
+	c.Assert(changed, qt.Equals, mcp23017.Pins(0b1001))
+}
+
+func TestPinsDebouncerMatchesDebouncer(t *testing.T) {
+	c := qt.New(t)
+	// Check that the bitwise implementation behaves exactly like
+	// the single-pin Immediate strategy on random bouncy input.
+	rand := rand.New(rand.NewSource(1))
+	pd := PinsDebouncer{
+		Press:   5 * time.Millisecond,
+		Release: 3 * time.Millisecond,
+	}
+	var ds [mcp23017.PinCount]Debouncer
+	for i := range ds {
+		ds[i].Config = Config{
+			Press:   pd.Press,
+			Release: pd.Release,
+		}
+	}
+	now := time.Now()
+	var pins mcp23017.Pins
+	for step := 0; step < 5000; step++ {
+		now = now.Add(time.Millisecond)
+		// Occasionally flip pins, more often on some than others.
+		for i := 0; i < mcp23017.PinCount; i++ {
+			if rand.Intn(4+i*2) == 0 {
+				pins.Set(i, !pins.Get(i))
+			}
+		}
+		old := pd.State()
+		stable, changed := pd.updateAtTime(pins, now)
+		var expect mcp23017.Pins
+		for i := range ds {
+			ds[i].updateAtTime(pins.Get(i), now)
+			expect.Set(i, ds[i].State())
+		}
+		c.Assert(stable, qt.Equals, expect, qt.Commentf("step %d", step))
+		c.Assert(changed, qt.Equals, old^expect, qt.Commentf("step %d", step))
+	}
+}
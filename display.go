@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rogpeppe/doorbell/sequence"
+	"github.com/rogpeppe/doorbell/ssd1306"
+	"github.com/rogpeppe/doorbell/status"
+)
+
+// rollInterval holds how often the display is
+// redrawn while a tune is playing.
+const rollInterval = 100 * time.Millisecond
+
+// statusDisplay shows the doorbell status on the displays.
+// A nil *statusDisplay does nothing.
+type statusDisplay struct {
+	// info and roll hold the displays used for the
+	// textual status and the piano roll respectively.
+	// Either may be nil if the display isn't available.
+	info, roll *ssd1306.Device
+	frame      ssd1306.Frame
+	changed    chan struct{}
+
+	mu     sync.Mutex
+	status status.Status
+	// started holds when the current tune started playing.
+	started time.Time
+}
+
+// newStatusDisplay returns a statusDisplay that uses the given
+// displays and starts the goroutine that keeps them up to date.
+// It returns nil if there are no displays.
+func newStatusDisplay(info, roll *ssd1306.Device) *statusDisplay {
+	if info == nil && roll == nil {
+		return nil
+	}
+	d := &statusDisplay{
+		info:    info,
+		roll:    roll,
+		changed: make(chan struct{}, 1),
+	}
+	go d.run()
+	return d
+}
+
+// buttonPressed records that the given button has been pressed.
+func (d *statusDisplay) buttonPressed(button int) {
+	if d == nil {
+		return
+	}
+	d.update(func(s *status.Status) {
+		s.Button = buttonName(button)
+	})
+}
+
+// playing records that the given tune has started playing.
+// The tune is shown until all its actions have been played
+// or stopped is called.
+func (d *statusDisplay) playing(name string, actions []sequence.Action) {
+	if d == nil {
+		return
+	}
+	d.update(func(s *status.Status) {
+		s.Tune = name
+		s.Actions = actions
+		d.started = time.Now()
+	})
+}
+
+// stopped records that any tune has stopped playing.
+func (d *statusDisplay) stopped() {
+	if d == nil {
+		return
+	}
+	d.update(func(s *status.Status) {
+		s.Tune = ""
+		s.Actions = nil
+	})
+}
+
+func (d *statusDisplay) update(f func(s *status.Status)) {
+	d.mu.Lock()
+	f(&d.status)
+	d.mu.Unlock()
+	select {
+	case d.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (d *statusDisplay) run() {
+	for {
+		d.mu.Lock()
+		s := d.status
+		if s.Tune != "" {
+			s.Elapsed = time.Since(d.started)
+			if len(s.Actions) == 0 || s.Elapsed > s.Actions[len(s.Actions)-1].When {
+				// The tune has finished.
+				d.status.Tune, d.status.Actions = "", nil
+				s.Tune, s.Actions = "", nil
+			}
+		}
+		d.mu.Unlock()
+		d.draw(s)
+		if s.Tune == "" {
+			<-d.changed
+			continue
+		}
+		select {
+		case <-d.changed:
+		case <-time.After(rollInterval):
+		}
+	}
+}
+
+func (d *statusDisplay) draw(s status.Status) {
+	if d.info != nil {
+		status.DrawInfo(&d.frame, s)
+		if err := d.info.Display(&d.frame); err != nil {
+			println("cannot update info display: ", err.Error())
+		}
+	}
+	if d.roll != nil {
+		status.DrawRoll(&d.frame, s.Actions, s.Elapsed, numSolenoids)
+		if err := d.roll.Display(&d.frame); err != nil {
+			println("cannot update roll display: ", err.Error())
+		}
+	}
+}
@@ -1,4 +1,397 @@
+// The doorbellcvt command converts tunes into the form used
+// by the doorbell firmware, checks that they're playable
+// and shows a preview of them.
+//
+// Usage:
+//
+//	doorbellcvt [flags] file
+//
+// The input file can be a Standard MIDI File, a tune in the text
+// notation read by sequence.ParseTune or a tune in the binary
+// format read by sequence.ActionsForTune. By default the
+// input format is chosen by the file extension: files ending
+// in .mid or .midi are treated as MIDI, files ending in .txt
+// are treated as text, and anything else as binary.
+//
+// By default the output is a piano roll showing when each solenoid
+// is active, one line per time step. Time runs down the page and
+// each column represents a solenoid, starting at the lowest
+// note. A "*" marks a solenoid being activated, a "|" marks
+// it staying active and a "!" marks an activation that
+// the solenoid can't keep up with.
+//
+// With -f go, the output is Go source suitable for pasting into
+// notes.go; with -f tune, it's the raw binary data.
+//
+// Note that the binary tune format holds only the times that
+// solenoids are activated, so durations set with -d and -velocity
+// affect the piano roll and the checks made on the tune, but not
+// the -f go or -f tune output. On the doorbell itself, the duration
+// for each solenoid is set with the pulse-widths setting.
+//
+// Any problems with the tune (notes that can't be played or that
+// come too soon after the previous note on the same solenoid) are
+// reported on standard error, and the command exits with a
+// non-zero status.
 package main
 
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
 
-read MIDI file
+	"github.com/rogpeppe/doorbell/layout"
+	"github.com/rogpeppe/doorbell/sequence"
+)
+
+var (
+	outFile          = flag.String("o", "", "write output to `file` instead of standard output")
+	outFormat        = flag.String("f", "roll", "output `format`: roll, go or tune")
+	inFormat         = flag.String("in", "", "input `format`: midi, text or tune (default chosen by file extension)")
+	chanCount        = flag.Int("n", 24, "number of available solenoids")
+	layoutFile       = flag.String("layout", "", "read the number of available solenoids from the doorbell layout in `file` (see the layout package)")
+	solenoidDuration = flag.Duration("d", 200*time.Millisecond, "`duration` to activate each solenoid for")
+	minVelocity      = flag.Float64("velocity", 0, "if non-zero, scale each MIDI note's duration by its velocity, down to this `fraction` of -d for the quietest notes")
+	recoveryTime     = flag.Duration("recovery", 100*time.Millisecond, "minimum `duration` between a solenoid deactivating and activating again")
+	resolution       = flag.Duration("res", 50*time.Millisecond, "`duration` represented by each line of the piano roll")
+	baseNote         = flag.Int("base", sequence.MiddleC, "MIDI `note` number that maps to the first solenoid")
+	rangePolicy      = flag.String("range", "drop", "what to do with MIDI notes out of range: drop, fold or transpose")
+	varName          = flag.String("name", "", "Go variable `name` for -f go (default derived from the input file name)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: doorbellcvt [flags] file\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+	}
+	ok, err := run(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "doorbellcvt: %v\n", err)
+		os.Exit(1)
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// run converts the given file and writes the output.
+// It reports whether the tune was free of problems.
+func run(file string) (bool, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return false, err
+	}
+	if *layoutFile != "" {
+		text, err := ioutil.ReadFile(*layoutFile)
+		if err != nil {
+			return false, err
+		}
+		l, err := layout.Parse(string(text))
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", *layoutFile, err)
+		}
+		*chanCount = len(l.Solenoids)
+	}
+	format := *inFormat
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(file)) {
+		case ".mid", ".midi":
+			format = "midi"
+		case ".txt":
+			format = "text"
+		default:
+			format = "tune"
+		}
+	}
+	var problems []problem
+	var actions []sequence.Action
+	switch format {
+	case "midi":
+		policy, err := parseRangePolicy(*rangePolicy)
+		if err != nil {
+			return false, err
+		}
+		actions, err = sequence.ActionsForMIDI(data, sequence.MIDIParams{
+			ChanCount:        *chanCount,
+			BaseNote:         *baseNote,
+			OutOfRange:       policy,
+			SolenoidDuration: *solenoidDuration,
+			MinVelocityScale: *minVelocity,
+			OnDrop: func(note int, when time.Duration) {
+				problems = append(problems, problem{
+					when: when,
+					ch:   -1,
+					msg:  fmt.Sprintf("MIDI note %d is out of range", note),
+				})
+			},
+		})
+		if err != nil {
+			return false, err
+		}
+	case "text":
+		// As with the binary format, allow all channels so that
+		// out-of-range notes are reported along with other problems.
+		actions, err = sequence.ParseTune(256, string(data), *solenoidDuration)
+		if err != nil {
+			return false, fmt.Errorf("%s:%v", file, err)
+		}
+	case "tune":
+		// Read all the channels regardless of the channel
+		// count so that we can report any that are out of range.
+		actions = sequence.ActionsForTune(256, data, *solenoidDuration)
+	default:
+		return false, fmt.Errorf("unknown input format %q", format)
+	}
+	actions, rangeProblems := checkRange(actions, *chanCount)
+	problems = append(problems, rangeProblems...)
+	problems = append(problems, checkRecovery(actions, *recoveryTime)...)
+	sort.SliceStable(problems, func(i, j int) bool {
+		return problems[i].when < problems[j].when
+	})
+
+	w := os.Stdout
+	if *outFile != "" {
+		f, err := os.Create(*outFile)
+		if err != nil {
+			return false, err
+		}
+		defer f.Close()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	switch *outFormat {
+	case "roll":
+		writeRoll(bw, actions, problems, *chanCount, *resolution)
+	case "go":
+		name := *varName
+		if name == "" {
+			name = goName(file)
+		}
+		writeGo(bw, name, filepath.Base(file), sequence.TuneForActions(actions))
+	case "tune":
+		bw.Write(sequence.TuneForActions(actions))
+	default:
+		return false, fmt.Errorf("unknown output format %q", *outFormat)
+	}
+	if err := bw.Flush(); err != nil {
+		return false, err
+	}
+	for _, p := range problems {
+		fmt.Fprintf(os.Stderr, "%v: %s\n", p.when, p.msg)
+	}
+	return len(problems) == 0, nil
+}
+
+func parseRangePolicy(s string) (sequence.OutOfRangePolicy, error) {
+	switch s {
+	case "drop":
+		return sequence.DropOutOfRange, nil
+	case "fold":
+		return sequence.FoldOutOfRange, nil
+	case "transpose":
+		return sequence.TransposeOutOfRange, nil
+	}
+	return 0, fmt.Errorf("unknown range policy %q", s)
+}
+
+// problem represents a problem found with a tune.
+type problem struct {
+	when time.Duration
+	// ch holds the channel that the problem is associated
+	// with, or -1 if there is none.
+	ch  int
+	msg string
+}
+
+// checkRange removes any actions that are outside the
+// range of available channels and returns the resulting
+// actions along with a problem for each removed activation.
+// Rests (see sequence.RestChan) are removed without
+// being reported.
+func checkRange(actions []sequence.Action, chanCount int) ([]sequence.Action, []problem) {
+	var problems []problem
+	result := actions[:0]
+	for _, a := range actions {
+		if int(a.Chan) < chanCount {
+			result = append(result, a)
+			continue
+		}
+		if a.On && a.Chan != sequence.RestChan {
+			problems = append(problems, problem{
+				when: a.When,
+				ch:   -1,
+				msg:  fmt.Sprintf("channel %d is out of range", a.Chan),
+			})
+		}
+	}
+	return result, problems
+}
+
+// checkRecovery returns a problem for each activation that happens
+// while the channel is still active or less than the given recovery
+// time after it was deactivated.
+func checkRecovery(actions []sequence.Action, recovery time.Duration) []problem {
+	var problems []problem
+	// on holds the activation time of each active channel.
+	on := make(map[uint8]time.Duration)
+	// off holds the deactivation time of each inactive channel.
+	off := make(map[uint8]time.Duration)
+	for _, a := range actions {
+		if !a.On {
+			delete(on, a.Chan)
+			off[a.Chan] = a.When
+			continue
+		}
+		if t, ok := on[a.Chan]; ok {
+			problems = append(problems, problem{
+				when: a.When,
+				ch:   int(a.Chan),
+				msg:  fmt.Sprintf("%s activated while still active since %v", noteName(a.Chan), t),
+			})
+		} else if t, ok := off[a.Chan]; ok && a.When-t < recovery {
+			problems = append(problems, problem{
+				when: a.When,
+				ch:   int(a.Chan),
+				msg:  fmt.Sprintf("%s activated only %v after deactivating", noteName(a.Chan), a.When-t),
+			})
+		}
+		on[a.Chan] = a.When
+	}
+	return problems
+}
+
+// writeRoll writes a piano roll representation of the given actions
+// to w, each line representing the given time resolution.
+func writeRoll(w io.Writer, actions []sequence.Action, problems []problem, chanCount int, res time.Duration) {
+	var header [3]strings.Builder
+	for i := 0; i < chanCount; i++ {
+		if i > 0 && i%12 == 0 {
+			for j := range header {
+				header[j].WriteByte(' ')
+			}
+		}
+		name := noteName(uint8(i))
+		header[0].WriteByte(name[0])
+		if len(name) > 2 {
+			header[1].WriteByte(name[1])
+		} else {
+			header[1].WriteByte(' ')
+		}
+		header[2].WriteByte(name[len(name)-1])
+	}
+	for _, h := range header {
+		fmt.Fprintf(w, "%10s %s\n", "", strings.TrimRight(h.String(), " "))
+	}
+	bad := make(map[problemKey]bool)
+	for _, p := range problems {
+		if p.ch >= 0 {
+			bad[problemKey{p.when, uint8(p.ch)}] = true
+		}
+	}
+	cells := make([]byte, chanCount)
+	active := make([]bool, chanCount)
+	for t := time.Duration(0); len(actions) > 0; t += res {
+		for i := range cells {
+			if active[i] {
+				cells[i] = '|'
+			} else {
+				cells[i] = '.'
+			}
+		}
+		for ; len(actions) > 0 && actions[0].When < t+res; actions = actions[1:] {
+			a := actions[0]
+			active[a.Chan] = a.On
+			if !a.On {
+				continue
+			}
+			switch {
+			case bad[problemKey{a.When, a.Chan}]:
+				cells[a.Chan] = '!'
+			case cells[a.Chan] != '!':
+				cells[a.Chan] = '*'
+			}
+		}
+		var line strings.Builder
+		for i, c := range cells {
+			if i > 0 && i%12 == 0 {
+				line.WriteByte(' ')
+			}
+			line.WriteByte(c)
+		}
+		fmt.Fprintf(w, "%10v %s\n", t, line.String())
+	}
+}
+
+type problemKey struct {
+	when time.Duration
+	ch   uint8
+}
+
+// noteNames holds the names of the notes in an octave, as used in notes.go.
+var noteNames = []string{"C", "C#", "D", "Eb", "E", "F", "F#", "G", "G#", "A", "A#", "B"}
+
+// noteName returns the name of the note played by the given channel.
+func noteName(ch uint8) string {
+	return fmt.Sprintf("%s%d", noteNames[ch%12], ch/12+1)
+}
+
+// noteConst returns the Go expression used for the given
+// channel in notes.go.
+func noteConst(ch uint8) string {
+	if int(ch) >= 24 {
+		return fmt.Sprint(ch)
+	}
+	return "note" + strings.Replace(noteName(ch), "#", "s", 1)
+}
+
+// writeGo writes tune data as a Go variable declaration
+// in the style of notes.go.
+func writeGo(w io.Writer, name string, source string, data []byte) {
+	fmt.Fprintf(w, "// %s was generated by doorbellcvt from %s.\n", name, source)
+	fmt.Fprintf(w, "// Each entry holds a delay in milliseconds (two bytes) followed by a note.\n")
+	fmt.Fprintf(w, "var %s = []byte{\n", name)
+	for ; len(data) >= 3; data = data[3:] {
+		fmt.Fprintf(w, "\t0x%02x, 0x%02x, %s,\n", data[0], data[1], noteConst(data[2]))
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+// goName returns a Go variable name for a tune held in
+// the given file, following the convention used in notes.go.
+func goName(file string) string {
+	base := filepath.Base(file)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	var buf strings.Builder
+	upper := false
+	for _, r := range base {
+		switch {
+		case unicode.IsLetter(r) || (unicode.IsDigit(r) && buf.Len() > 0):
+			if upper {
+				r = unicode.ToUpper(r)
+			} else if buf.Len() == 0 {
+				r = unicode.ToLower(r)
+			}
+			buf.WriteRune(r)
+			upper = false
+		default:
+			upper = buf.Len() > 0
+		}
+	}
+	if buf.Len() == 0 {
+		return "newTune"
+	}
+	return buf.String() + "Tune"
+}
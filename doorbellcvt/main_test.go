@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/sequence"
+)
+
+const ms = time.Millisecond
+
+var checkRangeTests = []struct {
+	testName       string
+	data           []byte
+	expectActions  []sequence.Action
+	expectProblems []problem
+}{{
+	testName: "in-range",
+	data: []byte{
+		0, 0, 0,
+		0, 50, 23,
+	},
+	expectActions: []sequence.Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 23, On: true, When: 50 * ms},
+		{Chan: 0, On: false, When: 200 * ms},
+		{Chan: 23, On: false, When: 250 * ms},
+	},
+}, {
+	testName: "out-of-range",
+	data: []byte{
+		0, 0, 24,
+		0, 50, 1,
+	},
+	expectActions: []sequence.Action{
+		{Chan: 1, On: true, When: 50 * ms},
+		{Chan: 1, On: false, When: 250 * ms},
+	},
+	expectProblems: []problem{{
+		when: 0,
+		ch:   -1,
+		msg:  "channel 24 is out of range",
+	}},
+}, {
+	testName: "rest",
+	// This is what sequence.TuneForActions produces for
+	// a delay that's too long to fit in two bytes.
+	data: []byte{
+		0xff, 0xff, sequence.RestChan,
+		0x00, 0x01, 2,
+	},
+	expectActions: []sequence.Action{
+		{Chan: 2, On: true, When: 65536 * ms},
+		{Chan: 2, On: false, When: 65736 * ms},
+	},
+}}
+
+func TestCheckRange(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range checkRangeTests {
+		c.Run(test.testName, func(c *qt.C) {
+			actions := sequence.ActionsForTune(256, test.data, 200*ms)
+			actions, problems := checkRange(actions, 24)
+			c.Assert(actions, qt.DeepEquals, test.expectActions)
+			c.Assert(problemStrings(problems), qt.DeepEquals, problemStrings(test.expectProblems))
+		})
+	}
+}
+
+func TestCheckRangeRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	// The output of TuneForActions is accepted as input.
+	actions := []sequence.Action{
+		{Chan: 3, On: true, When: 0},
+		{Chan: 3, On: false, When: 200 * ms},
+		{Chan: 4, On: true, When: 2 * time.Minute},
+		{Chan: 4, On: false, When: 2*time.Minute + 200*ms},
+	}
+	data := sequence.TuneForActions(actions)
+	got, problems := checkRange(sequence.ActionsForTune(256, data, 200*ms), 24)
+	c.Assert(problems, qt.HasLen, 0)
+	c.Assert(got, qt.DeepEquals, actions)
+}
+
+var checkRecoveryTests = []struct {
+	testName       string
+	actions        []sequence.Action
+	expectProblems []problem
+}{{
+	testName: "ok",
+	actions: []sequence.Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 0, On: false, When: 200 * ms},
+		{Chan: 0, On: true, When: 300 * ms},
+		{Chan: 0, On: false, When: 500 * ms},
+	},
+}, {
+	testName: "still-active",
+	actions: []sequence.Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 0, On: true, When: 100 * ms},
+		{Chan: 0, On: false, When: 200 * ms},
+		{Chan: 0, On: false, When: 300 * ms},
+	},
+	expectProblems: []problem{{
+		when: 100 * ms,
+		ch:   0,
+		msg:  "C1 activated while still active since 0s",
+	}},
+}, {
+	testName: "too-soon",
+	actions: []sequence.Action{
+		{Chan: 1, On: true, When: 0},
+		{Chan: 1, On: false, When: 200 * ms},
+		{Chan: 2, On: true, When: 200 * ms},
+		{Chan: 1, On: true, When: 250 * ms},
+		{Chan: 2, On: false, When: 400 * ms},
+		{Chan: 1, On: false, When: 450 * ms},
+	},
+	expectProblems: []problem{{
+		when: 250 * ms,
+		ch:   1,
+		msg:  "C#1 activated only 50ms after deactivating",
+	}},
+}}
+
+func TestCheckRecovery(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range checkRecoveryTests {
+		c.Run(test.testName, func(c *qt.C) {
+			c.Assert(problemStrings(checkRecovery(test.actions, 100*ms)), qt.DeepEquals, problemStrings(test.expectProblems))
+		})
+	}
+}
+
+func TestWriteRoll(t *testing.T) {
+	c := qt.New(t)
+	actions := []sequence.Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 2, On: true, When: 50 * ms},
+		{Chan: 0, On: false, When: 100 * ms},
+		{Chan: 2, On: false, When: 150 * ms},
+	}
+	problems := []problem{{
+		when: 0,
+		ch:   0,
+		msg:  "bad",
+	}, {
+		when: 0,
+		ch:   -1,
+		msg:  "not associated with a channel",
+	}}
+	var buf strings.Builder
+	writeRoll(&buf, actions, problems, 3, 50*ms)
+	c.Assert(buf.String(), qt.Equals, `
+           CCD
+            #
+           111
+        0s !..
+      50ms |.*
+     100ms |.|
+     150ms ..|
+`[1:])
+}
+
+func TestWriteRollSeparatesOctaves(t *testing.T) {
+	c := qt.New(t)
+	actions := []sequence.Action{
+		{Chan: 12, On: true, When: 0},
+		{Chan: 12, On: false, When: 10 * ms},
+	}
+	var buf strings.Builder
+	writeRoll(&buf, actions, nil, 13, 50*ms)
+	c.Assert(buf.String(), qt.Equals, `
+           CCDEEFFGGAAB C
+            # b  # # #
+           111111111111 2
+        0s ............ *
+`[1:])
+}
+
+// problemStrings returns a string for each problem
+// so that they can be compared.
+func problemStrings(problems []problem) []string {
+	var s []string
+	for _, p := range problems {
+		s = append(s, fmt.Sprintf("%v ch %d: %s", p.when, p.ch, p.msg))
+	}
+	return s
+}
+
+var goNameTests = []struct {
+	file   string
+	expect string
+}{
+	{"happy-birthday.txt", "happyBirthdayTune"},
+	{"tunes/Ripple.mid", "rippleTune"},
+	{"foo_bar2.txt", "fooBar2Tune"},
+	{"2tune.mid", "tuneTune"},
+	{"---.mid", "newTune"},
+}
+
+func TestGoName(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range goNameTests {
+		c.Check(goName(test.file), qt.Equals, test.expect, qt.Commentf("%s", test.file))
+	}
+}
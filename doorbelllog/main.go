@@ -0,0 +1,65 @@
+// The doorbelllog command decodes a doorbell event log
+// and prints its entries, one per line, oldest first.
+//
+// Usage:
+//
+//	doorbelllog [file]
+//
+// The input is a log in the binary form read by eventlog.Decode,
+// or the same hex-encoded, as printed by the "log hex" console
+// command. If no file is given, the log is read from standard input.
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/rogpeppe/doorbell/eventlog"
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: doorbelllog [file]\n")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+	}
+	if err := run(flag.Arg(0), os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "doorbelllog: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func run(file string, w io.Writer) error {
+	var data []byte
+	var err error
+	if file == "" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return err
+	}
+	// The binary form always starts with a magic number that
+	// isn't valid hex, so if it decodes as hex, it's hex.
+	if h, err := hex.DecodeString(string(bytes.TrimSpace(data))); err == nil {
+		data = h
+	}
+	entries, err := eventlog.Decode(data)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fmt.Fprintln(w, e)
+	}
+	return nil
+}
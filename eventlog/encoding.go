@@ -0,0 +1,137 @@
+package eventlog
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"time"
+
+	"github.com/rogpeppe/doorbell/gesture"
+	"github.com/rogpeppe/doorbell/mcp23017"
+)
+
+// EntrySize holds the size of an encoded entry in bytes.
+//
+// An entry is encoded as follows, with all numbers little-endian:
+//
+//	0   kind
+//	1   gesture kind
+//	2   button state (2 bytes)
+//	4   time in milliseconds since the Unix epoch (8 bytes);
+//	    it's decoded as UTC
+//	12  text length
+//	13  text, padded with zeros
+const EntrySize = 32
+
+// headerSize holds the size of the header at the start
+// of an encoded log: the magic number, the number of
+// entries and the CRC-32 checksum of the entries.
+const headerSize = 12
+
+// EncodedSize returns the size in bytes of an encoded
+// log holding the given number of entries.
+func EncodedSize(entries int) int64 {
+	return headerSize + int64(entries)*EntrySize
+}
+
+// magic identifies an encoded log.
+const magic = "DBEL"
+
+// ErrCorrupt is returned when an encoded log is invalid.
+var ErrCorrupt = errors.New("corrupt event log")
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+// The encoded form is a header followed by the
+// entries, oldest first. It can be decoded with Decode.
+func (l *Log) MarshalBinary() ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	data := make([]byte, headerSize, headerSize+l.n*EntrySize)
+	for i := 0; i < l.n; i++ {
+		data = append(data, l.entry(i)...)
+	}
+	copy(data[0:4], magic)
+	binary.LittleEndian.PutUint32(data[4:8], uint32(l.n))
+	binary.LittleEndian.PutUint32(data[8:12], crc32.ChecksumIEEE(data[headerSize:]))
+	return data, nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler.
+// It adds the entries in data (as encoded by MarshalBinary)
+// to the log.
+func (l *Log) UnmarshalBinary(data []byte) error {
+	entries, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		l.Add(e)
+	}
+	return nil
+}
+
+// Decode decodes a log as encoded by Log.MarshalBinary
+// and returns its entries, oldest first.
+func Decode(data []byte) ([]Entry, error) {
+	n, err := checkHeader(data, int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	sum := binary.LittleEndian.Uint32(data[8:12])
+	data = data[headerSize : headerSize+n*EntrySize]
+	if crc32.ChecksumIEEE(data) != sum {
+		return nil, ErrCorrupt
+	}
+	entries := make([]Entry, n)
+	for i := range entries {
+		entries[i] = decodeEntry(data[i*EntrySize : (i+1)*EntrySize])
+	}
+	return entries, nil
+}
+
+// checkHeader checks the header at the start of data
+// and returns the number of entries. It returns ErrCorrupt
+// if the encoded entries wouldn't fit in maxSize bytes.
+func checkHeader(data []byte, maxSize int64) (int, error) {
+	if len(data) < headerSize || string(data[0:4]) != magic {
+		return 0, ErrCorrupt
+	}
+	// Check the size before converting to int, which
+	// could overflow on 32-bit machines.
+	n := binary.LittleEndian.Uint32(data[4:8])
+	if headerSize+int64(n)*EntrySize > maxSize {
+		return 0, ErrCorrupt
+	}
+	return int(n), nil
+}
+
+func encodeEntry(buf []byte, e Entry) {
+	buf[0] = byte(e.Kind)
+	buf[1] = byte(e.Gesture)
+	binary.LittleEndian.PutUint16(buf[2:4], uint16(e.Buttons))
+	binary.LittleEndian.PutUint64(buf[4:12], uint64(e.Time.UnixNano()/int64(time.Millisecond)))
+	text := e.Text
+	if len(text) > MaxTextLen {
+		text = text[:MaxTextLen]
+	}
+	buf[12] = byte(len(text))
+	n := copy(buf[13:], text)
+	for i := 13 + n; i < EntrySize; i++ {
+		buf[i] = 0
+	}
+}
+
+func decodeEntry(buf []byte) Entry {
+	ms := int64(binary.LittleEndian.Uint64(buf[4:12]))
+	n := int(buf[12])
+	if n > MaxTextLen {
+		n = MaxTextLen
+	}
+	return Entry{
+		Kind:    Kind(buf[0]),
+		Gesture: gesture.Kind(buf[1]),
+		Buttons: mcp23017.Pins(binary.LittleEndian.Uint16(buf[2:4])),
+		Time:    time.Unix(ms/1000, ms%1000*int64(time.Millisecond)).UTC(),
+		Text:    string(buf[13 : 13+n]),
+	}
+}
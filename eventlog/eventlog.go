@@ -0,0 +1,179 @@
+// Package eventlog implements a fixed-size log of doorbell events
+// such as button presses and tunes played.
+//
+// The log is held in memory as a ring buffer of fixed-size
+// entries, so when it's full, adding an entry discards the oldest.
+// It can be saved to and loaded from flash memory (see Save and
+// Load) and encoded in a compact binary form (see Log.MarshalBinary)
+// which can be decoded on another machine with Decode.
+package eventlog
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rogpeppe/doorbell/gesture"
+	"github.com/rogpeppe/doorbell/mcp23017"
+)
+
+// Kind represents a kind of log entry.
+type Kind uint8
+
+const (
+	// Buttons records a change in the button state.
+	Buttons Kind = iota + 1
+
+	// Gesture records a recognized button gesture.
+	Gesture
+
+	// Tune records a tune being played.
+	Tune
+
+	// Error records a hardware or other error.
+	Error
+)
+
+var kindNames = []string{
+	Buttons: "buttons",
+	Gesture: "gesture",
+	Tune:    "tune",
+	Error:   "error",
+}
+
+// String implements fmt.Stringer.
+func (k Kind) String() string {
+	if k > 0 && int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// MaxTextLen holds the maximum length of the text in an entry.
+// Longer text is truncated when it's added to the log.
+const MaxTextLen = EntrySize - 13
+
+// Entry represents an entry in the log.
+type Entry struct {
+	Kind Kind
+	// Time holds when the event happened. It's stored
+	// with millisecond precision.
+	Time time.Time
+	// Buttons holds the new button state for Buttons entries
+	// and the buttons that made the gesture for Gesture entries.
+	Buttons mcp23017.Pins
+	// Gesture holds the kind of gesture for Gesture entries.
+	Gesture gesture.Kind
+	// Text holds the tune name for Tune entries and the
+	// error message for Error entries.
+	Text string
+}
+
+// String returns a one-line description of the entry.
+func (e Entry) String() string {
+	var buf strings.Builder
+	buf.WriteString(e.Time.Format("2006-01-02 15:04:05.000 "))
+	buf.WriteString(e.Kind.String())
+	switch e.Kind {
+	case Buttons:
+		buf.WriteString(" " + formatPins(e.Buttons))
+	case Gesture:
+		buf.WriteString(" " + e.Gesture.String() + " " + formatPins(e.Buttons))
+	case Tune, Error:
+		buf.WriteString(" " + e.Text)
+	}
+	return buf.String()
+}
+
+// formatPins returns the numbers of the set pins
+// separated by commas, or "-" if there are none.
+func formatPins(pins mcp23017.Pins) string {
+	if pins == 0 {
+		return "-"
+	}
+	var buf strings.Builder
+	for i := 0; i < mcp23017.PinCount; i++ {
+		if !pins.Get(i) {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteString(strconv.Itoa(i))
+	}
+	return buf.String()
+}
+
+// Log holds the most recent entries. It's safe
+// to use concurrently.
+type Log struct {
+	mu sync.Mutex
+	// entries holds the encoded entries.
+	entries []byte
+	// start holds the index of the oldest entry.
+	start int
+	// n holds the number of entries in the log.
+	n int
+}
+
+// New returns a log that can hold up to size entries.
+func New(size int) *Log {
+	return &Log{
+		entries: make([]byte, size*EntrySize),
+	}
+}
+
+// Cap returns the maximum number of entries that the log can hold.
+func (l *Log) Cap() int {
+	return len(l.entries) / EntrySize
+}
+
+// Len returns the number of entries in the log.
+func (l *Log) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.n
+}
+
+// Add adds an entry to the log, discarding the oldest
+// entry if the log is full.
+func (l *Log) Add(e Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.Cap() == 0 {
+		return
+	}
+	i := (l.start + l.n) % l.Cap()
+	if l.n < l.Cap() {
+		l.n++
+	} else {
+		l.start = (l.start + 1) % l.Cap()
+	}
+	encodeEntry(l.entries[i*EntrySize:(i+1)*EntrySize], e)
+}
+
+// Entries returns all the entries in the log, oldest first.
+func (l *Log) Entries() []Entry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := make([]Entry, l.n)
+	for i := range entries {
+		entries[i] = decodeEntry(l.entry(i))
+	}
+	return entries
+}
+
+// Clear removes all the entries from the log.
+func (l *Log) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.start, l.n = 0, 0
+}
+
+// entry returns the encoded form of the i'th oldest entry.
+// It must be called with l.mu held.
+func (l *Log) entry(i int) []byte {
+	i = (l.start + i) % l.Cap()
+	return l.entries[i*EntrySize : (i+1)*EntrySize]
+}
@@ -0,0 +1,188 @@
+package eventlog
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/gesture"
+)
+
+var epoch = time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func at(d time.Duration) time.Time {
+	return epoch.Add(d)
+}
+
+func TestLogRing(t *testing.T) {
+	c := qt.New(t)
+	l := New(3)
+	c.Assert(l.Cap(), qt.Equals, 3)
+	c.Assert(l.Len(), qt.Equals, 0)
+	c.Assert(l.Entries(), qt.HasLen, 0)
+	for i := 0; i < 5; i++ {
+		l.Add(Entry{
+			Kind: Tune,
+			Time: at(time.Duration(i) * time.Second),
+			Text: strings.Repeat("x", i),
+		})
+	}
+	c.Assert(l.Len(), qt.Equals, 3)
+	c.Assert(texts(l.Entries()), qt.DeepEquals, []string{"xx", "xxx", "xxxx"})
+
+	l.Clear()
+	c.Assert(l.Len(), qt.Equals, 0)
+	l.Add(Entry{Kind: Tune, Time: epoch, Text: "a"})
+	c.Assert(texts(l.Entries()), qt.DeepEquals, []string{"a"})
+}
+
+func TestZeroSizeLog(t *testing.T) {
+	c := qt.New(t)
+	l := New(0)
+	l.Add(Entry{Kind: Tune, Time: epoch})
+	c.Assert(l.Len(), qt.Equals, 0)
+}
+
+var entryTests = []struct {
+	testName     string
+	entry        Entry
+	expect       Entry
+	expectString string
+}{{
+	testName: "buttons",
+	entry: Entry{
+		Kind:    Buttons,
+		Time:    at(1500 * time.Millisecond),
+		Buttons: 0b1001,
+	},
+	expectString: "2020-01-01 12:00:01.500 buttons 0,3",
+}, {
+	testName: "no-buttons",
+	entry: Entry{
+		Kind: Buttons,
+		Time: epoch,
+	},
+	expectString: "2020-01-01 12:00:00.000 buttons -",
+}, {
+	testName: "gesture",
+	entry: Entry{
+		Kind:    Gesture,
+		Time:    epoch,
+		Gesture: gesture.DoubleClick,
+		Buttons: 0b100,
+	},
+	expectString: "2020-01-01 12:00:00.000 gesture double-click 2",
+}, {
+	testName: "tune",
+	entry: Entry{
+		Kind: Tune,
+		Time: epoch,
+		Text: "happy-birthday",
+	},
+	expectString: "2020-01-01 12:00:00.000 tune happy-birthday",
+}, {
+	testName: "error-with-long-text",
+	entry: Entry{
+		Kind: Error,
+		Time: at(1234567 * time.Microsecond),
+		Text: "cannot read buttons: i2c timeout",
+	},
+	// The time is truncated to milliseconds and the text to MaxTextLen.
+	expect: Entry{
+		Kind: Error,
+		Time: at(1234 * time.Millisecond),
+		Text: "cannot read buttons",
+	},
+	expectString: "2020-01-01 12:00:01.234 error cannot read buttons",
+}}
+
+func TestEntryRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range entryTests {
+		c.Run(test.testName, func(c *qt.C) {
+			expect := test.expect
+			if expect.Kind == 0 {
+				expect = test.entry
+			}
+			l := New(1)
+			l.Add(test.entry)
+			entries := l.Entries()
+			c.Assert(entries, qt.HasLen, 1)
+			e := entries[0]
+			c.Assert(e.Time.Equal(expect.Time), qt.IsTrue, qt.Commentf("got %v", e.Time))
+			e.Time = expect.Time
+			c.Assert(e, qt.DeepEquals, expect)
+			c.Assert(e.String(), qt.Equals, test.expectString)
+		})
+	}
+}
+
+func TestKindString(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(Gesture.String(), qt.Equals, "gesture")
+	c.Assert(Kind(0).String(), qt.Equals, "Kind(0)")
+	c.Assert(Kind(99).String(), qt.Equals, "Kind(99)")
+}
+
+func TestMarshalBinary(t *testing.T) {
+	c := qt.New(t)
+	l := New(4)
+	for i := 0; i < 6; i++ {
+		l.Add(Entry{
+			Kind:    Buttons,
+			Time:    at(time.Duration(i) * time.Second),
+			Buttons: 1 << i,
+		})
+	}
+	data, err := l.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.HasLen, headerSize+4*EntrySize)
+	entries, err := Decode(data)
+	c.Assert(err, qt.IsNil)
+	c.Assert(entries, qt.HasLen, 4)
+	for i, e := range entries {
+		c.Assert(e.Buttons, qt.Equals, l.Entries()[i].Buttons)
+	}
+
+	// Unmarshaling into a smaller log keeps the newest entries.
+	l1 := New(2)
+	err = l1.UnmarshalBinary(data)
+	c.Assert(err, qt.IsNil)
+	c.Assert(l1.Entries(), qt.HasLen, 2)
+	c.Assert(l1.Entries()[1].Buttons, qt.Equals, entries[3].Buttons)
+}
+
+func TestDecodeCorrupt(t *testing.T) {
+	c := qt.New(t)
+	l := New(2)
+	l.Add(Entry{Kind: Tune, Time: epoch, Text: "foo"})
+	data, err := l.MarshalBinary()
+	c.Assert(err, qt.IsNil)
+
+	_, err = Decode(data[:len(data)-1])
+	c.Assert(err, qt.Equals, ErrCorrupt)
+
+	_, err = Decode([]byte("XXXX"))
+	c.Assert(err, qt.Equals, ErrCorrupt)
+
+	data[len(data)-1] ^= 1
+	_, err = Decode(data)
+	c.Assert(err, qt.Equals, ErrCorrupt)
+
+	// An entry count too large for the data (and for
+	// an int on 32-bit machines) is rejected.
+	binary.LittleEndian.PutUint32(data[4:8], 0xffffffff)
+	_, err = Decode(data)
+	c.Assert(err, qt.Equals, ErrCorrupt)
+}
+
+func texts(entries []Entry) []string {
+	var s []string
+	for _, e := range entries {
+		s = append(s, e.Text)
+	}
+	return s
+}
@@ -0,0 +1,84 @@
+package eventlog
+
+import (
+	"errors"
+)
+
+// BlockDevice represents a flash memory device. It is
+// implemented by TinyGo's machine.Flash.
+type BlockDevice interface {
+	ReadAt(buf []byte, off int64) (int, error)
+	WriteAt(buf []byte, off int64) (int, error)
+	// Size returns the size of the device in bytes.
+	Size() int64
+	// EraseBlockSize returns the size of an erase block in bytes.
+	EraseBlockSize() int64
+	// EraseBlocks erases the given number of blocks
+	// starting at the given block number.
+	EraseBlocks(start, len int64) error
+}
+
+// ErrNoSpace is returned by Save when the device
+// is too small to hold the log.
+var ErrNoSpace = errors.New("not enough space for event log")
+
+// Save writes the encoded log (see MarshalBinary) to the start of
+// dev. The header is written last, so if power is lost while
+// saving, Load will find an empty log rather than a corrupt one.
+//
+// Flash memory can only be erased a limited number of times,
+// so the log should be saved occasionally rather than every
+// time an entry is added.
+func (l *Log) Save(dev BlockDevice) error {
+	data, err := l.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	size := int64(len(data))
+	if size > dev.Size() {
+		return ErrNoSpace
+	}
+	blockSize := dev.EraseBlockSize()
+	if err := dev.EraseBlocks(0, (size+blockSize-1)/blockSize); err != nil {
+		return err
+	}
+	if _, err := dev.WriteAt(data[headerSize:], headerSize); err != nil {
+		return err
+	}
+	if _, err := dev.WriteAt(data[:headerSize], 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Load adds the entries saved on dev by Save to the log.
+// If dev doesn't hold a valid log, no entries are added.
+// It returns ErrCorrupt if the saved log claims to hold more
+// entries than can fit on dev.
+func (l *Log) Load(dev BlockDevice) error {
+	hdr := make([]byte, headerSize)
+	if _, err := dev.ReadAt(hdr, 0); err != nil {
+		return err
+	}
+	if string(hdr[0:4]) != magic {
+		return nil
+	}
+	n, err := checkHeader(hdr, dev.Size())
+	if err != nil {
+		return err
+	}
+	data := make([]byte, EncodedSize(n))
+	if _, err := dev.ReadAt(data, 0); err != nil {
+		return err
+	}
+	entries, err := Decode(data)
+	if err != nil {
+		// The entries have been partly overwritten,
+		// so treat the log as empty.
+		return nil
+	}
+	for _, e := range entries {
+		l.Add(e)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package eventlog
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSaveAndLoad(t *testing.T) {
+	c := qt.New(t)
+	dev := newFakeFlash(4, 64)
+	l := New(5)
+	for i := 0; i < 4; i++ {
+		l.Add(Entry{
+			Kind: Tune,
+			Time: at(time.Duration(i) * time.Second),
+			Text: string(rune('a' + i)),
+		})
+	}
+	err := l.Save(dev)
+	c.Assert(err, qt.IsNil)
+
+	l1 := New(5)
+	err = l1.Load(dev)
+	c.Assert(err, qt.IsNil)
+	c.Assert(l1.Entries(), qt.DeepEquals, l.Entries())
+
+	// Saving again overwrites the previous contents.
+	l.Clear()
+	l.Add(Entry{Kind: Tune, Time: epoch, Text: "x"})
+	err = l.Save(dev)
+	c.Assert(err, qt.IsNil)
+	l1 = New(5)
+	err = l1.Load(dev)
+	c.Assert(err, qt.IsNil)
+	c.Assert(texts(l1.Entries()), qt.DeepEquals, []string{"x"})
+}
+
+func TestLoadEmptyDevice(t *testing.T) {
+	c := qt.New(t)
+	dev := newFakeFlash(4, 64)
+	l := New(5)
+	err := l.Load(dev)
+	c.Assert(err, qt.IsNil)
+	c.Assert(l.Len(), qt.Equals, 0)
+}
+
+func TestLoadTooManyEntries(t *testing.T) {
+	c := qt.New(t)
+	dev := newFakeFlash(4, 64)
+	l := New(5)
+	l.Add(Entry{Kind: Tune, Time: epoch, Text: "x"})
+	err := l.Save(dev)
+	c.Assert(err, qt.IsNil)
+	// Corrupt the entry count so that it's too large
+	// for the device (and for an int on 32-bit machines).
+	dev.data[4] = 0
+	dev.data[5] = 0
+	dev.data[6] = 0
+	dev.data[7] = 0x80
+
+	l1 := New(5)
+	err = l1.Load(dev)
+	c.Assert(err, qt.Equals, ErrCorrupt)
+	c.Assert(l1.Len(), qt.Equals, 0)
+}
+
+func TestSaveInterrupted(t *testing.T) {
+	c := qt.New(t)
+	dev := newFakeFlash(4, 64)
+	l := New(5)
+	l.Add(Entry{Kind: Tune, Time: epoch, Text: "a"})
+	l.Add(Entry{Kind: Tune, Time: epoch, Text: "b"})
+	dev.failAfter = EntrySize
+	err := l.Save(dev)
+	c.Assert(err, qt.ErrorMatches, `write failed`)
+
+	// The header hasn't been written, so the log is empty.
+	l1 := New(5)
+	err = l1.Load(dev)
+	c.Assert(err, qt.IsNil)
+	c.Assert(l1.Len(), qt.Equals, 0)
+}
+
+func TestSaveNoSpace(t *testing.T) {
+	c := qt.New(t)
+	dev := newFakeFlash(1, 64)
+	l := New(5)
+	l.Add(Entry{Kind: Tune, Time: epoch})
+	l.Add(Entry{Kind: Tune, Time: epoch})
+	err := l.Save(dev)
+	c.Assert(err, qt.Equals, ErrNoSpace)
+}
+
+// fakeFlash implements BlockDevice in memory, modelling
+// the way that flash memory can only be written after
+// it's been erased.
+type fakeFlash struct {
+	data      []byte
+	blockSize int64
+	// failAfter holds the number of bytes that can be
+	// written before all writes fail. If it's negative,
+	// writes always succeed.
+	failAfter int
+}
+
+func newFakeFlash(blocks, blockSize int) *fakeFlash {
+	dev := &fakeFlash{
+		data:      make([]byte, blocks*blockSize),
+		blockSize: int64(blockSize),
+		failAfter: -1,
+	}
+	for i := range dev.data {
+		dev.data[i] = 0xff
+	}
+	return dev
+}
+
+func (dev *fakeFlash) ReadAt(buf []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(buf)) > int64(len(dev.data)) {
+		return 0, errors.New("read out of range")
+	}
+	return copy(buf, dev.data[off:]), nil
+}
+
+func (dev *fakeFlash) WriteAt(buf []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(buf)) > int64(len(dev.data)) {
+		return 0, errors.New("write out of range")
+	}
+	for i, b := range buf {
+		if dev.failAfter == 0 {
+			return i, errors.New("write failed")
+		}
+		if dev.failAfter > 0 {
+			dev.failAfter--
+		}
+		// Writing can only clear bits.
+		dev.data[off+int64(i)] &= b
+	}
+	return len(buf), nil
+}
+
+func (dev *fakeFlash) Size() int64 {
+	return int64(len(dev.data))
+}
+
+func (dev *fakeFlash) EraseBlockSize() int64 {
+	return dev.blockSize
+}
+
+func (dev *fakeFlash) EraseBlocks(start, n int64) error {
+	if start < 0 || (start+n)*dev.blockSize > int64(len(dev.data)) {
+		return errors.New("erase out of range")
+	}
+	for i := start * dev.blockSize; i < (start+n)*dev.blockSize; i++ {
+		dev.data[i] = 0xff
+	}
+	return nil
+}
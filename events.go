@@ -0,0 +1,124 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/rogpeppe/doorbell/eventlog"
+	"github.com/rogpeppe/doorbell/gesture"
+	"github.com/rogpeppe/doorbell/mcp23017"
+)
+
+// eventLogSize holds the number of entries kept in the event log.
+const eventLogSize = 256
+
+// eventSaveInterval holds how often the event log is saved
+// to flash if it's changed. Flash can only be erased a limited
+// number of times, so we don't save it after every event.
+const eventSaveInterval = 10 * time.Minute
+
+// eventRecorder records doorbell events in the event log.
+// Its methods may be called on a nil *eventRecorder,
+// in which case they do nothing.
+type eventRecorder struct {
+	log *eventlog.Log
+	cfg *settings
+	// flash holds the device that the log is saved to.
+	// If it's nil, the log isn't saved.
+	flash eventlog.BlockDevice
+
+	mu sync.Mutex
+	// unsaved holds whether events have been recorded
+	// since the log was last saved.
+	unsaved bool
+}
+
+// newEventRecorder returns a recorder that uses the settings
+// to find the current time and saves the log to the given
+// flash device, which may be nil. Any log previously saved
+// on the device is loaded.
+func newEventRecorder(cfg *settings, flash eventlog.BlockDevice) *eventRecorder {
+	r := &eventRecorder{
+		log:   eventlog.New(eventLogSize),
+		cfg:   cfg,
+		flash: flash,
+	}
+	if flash != nil {
+		if err := r.log.Load(flash); err != nil {
+			println("cannot load event log: ", err.Error())
+		}
+	}
+	return r
+}
+
+func (r *eventRecorder) add(e eventlog.Entry) {
+	if r == nil {
+		return
+	}
+	e.Time = r.cfg.now()
+	r.log.Add(e)
+	r.mu.Lock()
+	r.unsaved = true
+	r.mu.Unlock()
+}
+
+// buttons records a change in the button state.
+func (r *eventRecorder) buttons(state mcp23017.Pins) {
+	r.add(eventlog.Entry{
+		Kind:    eventlog.Buttons,
+		Buttons: state,
+	})
+}
+
+// gesture records a recognized gesture.
+func (r *eventRecorder) gesture(e gesture.Event) {
+	r.add(eventlog.Entry{
+		Kind:    eventlog.Gesture,
+		Gesture: e.Kind,
+		Buttons: e.Buttons,
+	})
+}
+
+// tune records a tune being played.
+func (r *eventRecorder) tune(name string) {
+	r.add(eventlog.Entry{
+		Kind: eventlog.Tune,
+		Text: name,
+	})
+}
+
+// error records an error.
+func (r *eventRecorder) error(msg string) {
+	println("error: ", msg)
+	r.add(eventlog.Entry{
+		Kind: eventlog.Error,
+		Text: msg,
+	})
+}
+
+// saver saves the event log periodically.
+func (r *eventRecorder) saver() {
+	if r == nil || r.flash == nil {
+		return
+	}
+	for {
+		time.Sleep(eventSaveInterval)
+		r.save()
+	}
+}
+
+// save saves the event log if it's changed since it was last saved.
+func (r *eventRecorder) save() {
+	r.mu.Lock()
+	unsaved := r.unsaved
+	r.unsaved = false
+	r.mu.Unlock()
+	if !unsaved {
+		return
+	}
+	if err := r.log.Save(r.flash); err != nil {
+		// Don't record the error in the log because
+		// that would make it unsaved again.
+		println("cannot save event log: ", err.Error())
+	}
+}
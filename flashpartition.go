@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/rogpeppe/doorbell/eventlog"
+)
+
+// flashPartition implements eventlog.BlockDevice by giving
+// access to a range of erase blocks on another device. It's
+// used to keep the event log at the end of the on-chip flash.
+type flashPartition struct {
+	dev eventlog.BlockDevice
+	// start holds the first block of the partition
+	// and n holds the number of blocks in it.
+	start, n int64
+}
+
+var errOutOfRange = errors.New("flash access out of range")
+
+func (p *flashPartition) ReadAt(buf []byte, off int64) (int, error) {
+	if !p.contains(off, len(buf)) {
+		return 0, errOutOfRange
+	}
+	return p.dev.ReadAt(buf, p.offset()+off)
+}
+
+func (p *flashPartition) WriteAt(buf []byte, off int64) (int, error) {
+	if !p.contains(off, len(buf)) {
+		return 0, errOutOfRange
+	}
+	return p.dev.WriteAt(buf, p.offset()+off)
+}
+
+func (p *flashPartition) Size() int64 {
+	return p.n * p.dev.EraseBlockSize()
+}
+
+func (p *flashPartition) EraseBlockSize() int64 {
+	return p.dev.EraseBlockSize()
+}
+
+func (p *flashPartition) EraseBlocks(start, n int64) error {
+	if start < 0 || n < 0 || start+n > p.n {
+		return errOutOfRange
+	}
+	return p.dev.EraseBlocks(p.start+start, n)
+}
+
+func (p *flashPartition) offset() int64 {
+	return p.start * p.dev.EraseBlockSize()
+}
+
+func (p *flashPartition) contains(off int64, n int) bool {
+	return off >= 0 && off+int64(n) <= p.Size()
+}
@@ -0,0 +1,328 @@
+// Package gesture recognizes button gestures such as clicks,
+// double clicks and long presses from debounced button states.
+package gesture
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/timer"
+)
+
+// DefaultLongPress holds the long-press threshold used
+// when none is configured.
+const DefaultLongPress = 750 * time.Millisecond
+
+// Kind represents a kind of gesture.
+type Kind uint8
+
+const (
+	// Click is a press and release shorter than the long-press
+	// threshold. When double clicks are enabled, it's only
+	// sent when no second click follows.
+	Click Kind = iota
+
+	// DoubleClick is sent when a button is clicked and then
+	// pressed again within Config.DoubleClick. The second
+	// press produces no other gestures.
+	DoubleClick
+
+	// LongPress is sent when a button has been held
+	// down for the long-press threshold.
+	LongPress
+
+	// Hold is sent repeatedly every Config.HoldRepeat
+	// while a button continues to be held after a LongPress.
+	Hold
+
+	// Chord is sent when several buttons are pressed at
+	// nearly the same time. The buttons in a chord produce
+	// no other gestures until they're released.
+	Chord
+)
+
+var kindNames = []string{
+	Click:       "click",
+	DoubleClick: "double-click",
+	LongPress:   "long-press",
+	Hold:        "hold",
+	Chord:       "chord",
+}
+
+// String implements fmt.Stringer.
+func (k Kind) String() string {
+	if int(k) < len(kindNames) {
+		return kindNames[k]
+	}
+	return "Kind(" + strconv.Itoa(int(k)) + ")"
+}
+
+// Event represents a recognized gesture.
+type Event struct {
+	Kind Kind
+	// Button holds the button that made the gesture.
+	// For Chord events, it holds the lowest numbered
+	// button in the chord.
+	Button int
+	// Buttons holds all the buttons in a Chord event.
+	// For other events, it holds just Button.
+	Buttons mcp23017.Pins
+	// Repeat holds the number of previous Hold events
+	// for the same press.
+	Repeat int
+	// Time holds when the gesture was recognized.
+	Time time.Time
+}
+
+// Config holds the thresholds used to recognize gestures.
+// The zero value is OK to use.
+type Config struct {
+	// LongPress holds how long a button must be held for
+	// a LongPress event. If it's zero, DefaultLongPress is used.
+	LongPress time.Duration
+
+	// DoubleClick holds the maximum time between releasing
+	// a button and pressing it again for a DoubleClick event.
+	// If it's zero, double clicks aren't recognized, and
+	// Click events are sent as soon as the button is released.
+	DoubleClick time.Duration
+
+	// HoldRepeat holds the interval between Hold events.
+	// If it's zero, no Hold events are sent.
+	HoldRepeat time.Duration
+
+	// ChordWindow holds the maximum time between the presses
+	// of buttons in a chord. If it's zero, chords aren't recognized.
+	ChordWindow time.Duration
+}
+
+func (cfg *Config) longPress() time.Duration {
+	if cfg.LongPress <= 0 {
+		return DefaultLongPress
+	}
+	return cfg.LongPress
+}
+
+// phase represents the state of a single button.
+type phase uint8
+
+const (
+	// idle means that the button is up and no gesture is in progress.
+	idle phase = iota
+	// down means that the button has been pressed and not
+	// yet held for long enough for a long press.
+	down
+	// held means that a LongPress event has been sent.
+	held
+	// clicked means that the button has been released after
+	// a short press and we're waiting to see if there's a double click.
+	clicked
+	// consumed means that the button is down but its press
+	// has been used by a DoubleClick or Chord event.
+	consumed
+)
+
+type button struct {
+	phase phase
+	// pressed holds when the button was pressed.
+	pressed time.Time
+	// released holds when the button was released.
+	released time.Time
+	// nextHold holds when the next Hold event is due.
+	nextHold time.Time
+	// repeat holds the number of Hold events sent.
+	repeat int
+}
+
+// Recognizer recognizes gestures. Call Update when the button
+// state changes or when the deadline returned by Deadline
+// is reached.
+//
+// The zero value of a Recognizer is OK to use.
+type Recognizer struct {
+	// Config holds the gesture thresholds.
+	Config Config
+
+	state   mcp23017.Pins
+	buttons [mcp23017.PinCount]button
+}
+
+// Update updates the recognizer with the current (debounced)
+// button state at the given time, and returns any gestures
+// that have been recognized.
+func (r *Recognizer) Update(now time.Time, state mcp23017.Pins) []Event {
+	var events []Event
+	// Handle any timeouts first so that they're
+	// seen before any effect of the state change.
+	for i := range r.buttons {
+		events = r.timeouts(events, i, now)
+	}
+	changed := state ^ r.state
+	r.state = state
+	if changed == 0 {
+		return events
+	}
+	for i := range r.buttons {
+		if !changed.Get(i) {
+			continue
+		}
+		b := &r.buttons[i]
+		if state.Get(i) {
+			if b.phase == clicked {
+				events = append(events, newEvent(DoubleClick, i, now))
+				b.phase = consumed
+				continue
+			}
+			b.phase = down
+			b.pressed = now
+			continue
+		}
+		switch b.phase {
+		case down:
+			if r.Config.DoubleClick > 0 {
+				b.phase = clicked
+				b.released = now
+			} else {
+				events = append(events, newEvent(Click, i, now))
+				b.phase = idle
+			}
+		default:
+			b.phase = idle
+		}
+	}
+	return r.chord(events, now)
+}
+
+// timeouts adds any events caused by the passing of time for the given button.
+func (r *Recognizer) timeouts(events []Event, i int, now time.Time) []Event {
+	b := &r.buttons[i]
+	switch b.phase {
+	case down:
+		t := b.pressed.Add(r.Config.longPress())
+		if now.Before(t) {
+			break
+		}
+		events = append(events, newEvent(LongPress, i, t))
+		b.phase = held
+		b.repeat = 0
+		b.nextHold = t.Add(r.Config.HoldRepeat)
+		fallthrough
+	case held:
+		if r.Config.HoldRepeat <= 0 {
+			break
+		}
+		for !now.Before(b.nextHold) {
+			e := newEvent(Hold, i, b.nextHold)
+			e.Repeat = b.repeat
+			events = append(events, e)
+			b.repeat++
+			b.nextHold = b.nextHold.Add(r.Config.HoldRepeat)
+		}
+	case clicked:
+		t := b.released.Add(r.Config.DoubleClick)
+		if !now.Before(t) {
+			events = append(events, newEvent(Click, i, t))
+			b.phase = idle
+		}
+	}
+	return events
+}
+
+// chord adds a Chord event if several buttons have been pressed
+// within the chord window.
+func (r *Recognizer) chord(events []Event, now time.Time) []Event {
+	if r.Config.ChordWindow <= 0 {
+		return events
+	}
+	var chord mcp23017.Pins
+	n := 0
+	first := -1
+	for i := range r.buttons {
+		b := &r.buttons[i]
+		if b.phase == down && now.Sub(b.pressed) <= r.Config.ChordWindow {
+			chord.High(i)
+			n++
+			if first == -1 {
+				first = i
+			}
+		}
+	}
+	if n < 2 {
+		return events
+	}
+	for i := range r.buttons {
+		if chord.Get(i) {
+			r.buttons[i].phase = consumed
+		}
+	}
+	return append(events, Event{
+		Kind:    Chord,
+		Button:  first,
+		Buttons: chord,
+		Time:    now,
+	})
+}
+
+// Deadline returns the time at which Update should be called
+// if the button state doesn't change before then, or the
+// zero time if there's no need.
+func (r *Recognizer) Deadline() time.Time {
+	var deadline time.Time
+	for i := range r.buttons {
+		b := &r.buttons[i]
+		var t time.Time
+		switch b.phase {
+		case down:
+			t = b.pressed.Add(r.Config.longPress())
+		case held:
+			if r.Config.HoldRepeat > 0 {
+				t = b.nextHold
+			}
+		case clicked:
+			t = b.released.Add(r.Config.DoubleClick)
+		}
+		if !t.IsZero() && (deadline.IsZero() || t.Before(deadline)) {
+			deadline = t
+		}
+	}
+	return deadline
+}
+
+// Run reads button states from states and sends recognized
+// gestures on events, using t to wait for deadlines, until
+// states is closed.
+func (r *Recognizer) Run(t *timer.Timer, states <-chan mcp23017.Pins, events chan<- Event) {
+	clock := t.Clock()
+	state := r.state
+	for {
+		if deadline := r.Deadline(); !deadline.IsZero() {
+			t.Reset(deadline.Sub(clock.Now()))
+		} else {
+			t.Stop()
+		}
+		select {
+		case s, ok := <-states:
+			if !ok {
+				t.Stop()
+				return
+			}
+			state = s
+		case <-t.C:
+		}
+		for _, e := range r.Update(clock.Now(), state) {
+			events <- e
+		}
+	}
+}
+
+func newEvent(kind Kind, button int, now time.Time) Event {
+	var buttons mcp23017.Pins
+	buttons.High(button)
+	return Event{
+		Kind:    kind,
+		Button:  button,
+		Buttons: buttons,
+		Time:    now,
+	}
+}
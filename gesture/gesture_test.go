@@ -0,0 +1,236 @@
+package gesture
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/timer"
+)
+
+const ms = time.Millisecond
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// step holds a button state change at a given time
+// since the start of a test.
+type step struct {
+	at    time.Duration
+	state mcp23017.Pins
+}
+
+var recognizerTests = []struct {
+	testName string
+	config   Config
+	steps    []step
+	// end holds the time that the test ends.
+	end    time.Duration
+	expect []string
+}{{
+	testName: "click",
+	steps: []step{
+		{0, 0b1},
+		{100 * ms, 0},
+	},
+	end:    time.Second,
+	expect: []string{"click 0 @100ms"},
+}, {
+	testName: "click-waits-for-double-click",
+	config: Config{
+		DoubleClick: 300 * ms,
+	},
+	steps: []step{
+		{0, 0b10},
+		{100 * ms, 0},
+	},
+	end:    time.Second,
+	expect: []string{"click 1 @400ms"},
+}, {
+	testName: "double-click",
+	config: Config{
+		DoubleClick: 300 * ms,
+	},
+	steps: []step{
+		{0, 0b10},
+		{100 * ms, 0},
+		{350 * ms, 0b10},
+		// Holding the second press doesn't make a long press.
+		{2000 * ms, 0},
+	},
+	end:    3 * time.Second,
+	expect: []string{"double-click 1 @350ms"},
+}, {
+	testName: "long-press",
+	steps: []step{
+		{0, 0b100},
+		{2000 * ms, 0},
+	},
+	end:    3 * time.Second,
+	expect: []string{"long-press 2 @750ms"},
+}, {
+	testName: "hold-repeat",
+	config: Config{
+		LongPress:  500 * ms,
+		HoldRepeat: 200 * ms,
+	},
+	steps: []step{
+		{100 * ms, 0b1},
+		{1050 * ms, 0},
+	},
+	end: 3 * time.Second,
+	expect: []string{
+		"long-press 0 @600ms",
+		"hold 0 #0 @800ms",
+		"hold 0 #1 @1s",
+	},
+}, {
+	testName: "chord",
+	config: Config{
+		ChordWindow: 50 * ms,
+	},
+	steps: []step{
+		{0, 0b001},
+		{20 * ms, 0b101},
+		{40 * ms, 0b111},
+		{2000 * ms, 0b010},
+		{2100 * ms, 0},
+	},
+	end: 3 * time.Second,
+	// The third button was pressed after the chord
+	// had been recognized, so it makes its own gesture.
+	expect: []string{
+		"chord 0b101 @20ms",
+		"long-press 1 @790ms",
+	},
+}, {
+	testName: "not-a-chord",
+	config: Config{
+		ChordWindow: 50 * ms,
+	},
+	steps: []step{
+		{0, 0b01},
+		{60 * ms, 0b11},
+		{100 * ms, 0b10},
+		{200 * ms, 0},
+	},
+	end: time.Second,
+	expect: []string{
+		"click 0 @100ms",
+		"click 1 @200ms",
+	},
+}, {
+	testName: "independent-buttons",
+	config: Config{
+		DoubleClick: 200 * ms,
+	},
+	steps: []step{
+		{0, 0b01},
+		{50 * ms, 0b00},
+		{100 * ms, 0b10},
+		{150 * ms, 0b00},
+		{200 * ms, 0b10},
+		{300 * ms, 0b00},
+	},
+	end: time.Second,
+	expect: []string{
+		"double-click 1 @200ms",
+		"click 0 @250ms",
+	},
+}}
+
+func TestRecognizer(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range recognizerTests {
+		c.Run(test.testName, func(c *qt.C) {
+			r := &Recognizer{
+				Config: test.config,
+			}
+			var got []string
+			var state mcp23017.Pins
+			// advance calls Update at all the deadlines up until t.
+			advance := func(t time.Time) {
+				for {
+					deadline := r.Deadline()
+					if deadline.IsZero() || deadline.After(t) {
+						return
+					}
+					got = append(got, formatEvents(r.Update(deadline, state))...)
+				}
+			}
+			for _, s := range test.steps {
+				advance(epoch.Add(s.at))
+				state = s.state
+				got = append(got, formatEvents(r.Update(epoch.Add(s.at), state))...)
+			}
+			advance(epoch.Add(test.end))
+			c.Assert(got, qt.DeepEquals, test.expect)
+			c.Assert(r.Deadline().IsZero(), qt.IsTrue)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	tm := timer.NewTimerWithClock(clock)
+	defer tm.Close()
+	r := &Recognizer{
+		Config: Config{
+			LongPress:   500 * ms,
+			DoubleClick: 200 * ms,
+		},
+	}
+	states := make(chan mcp23017.Pins)
+	events := make(chan Event)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(tm, states, events)
+	}()
+
+	states <- 0b1
+	clock.WaitSleepers(1)
+	clock.Advance(500 * ms)
+	c.Assert(formatEvent(<-events), qt.Equals, "long-press 0 @500ms")
+	states <- 0
+
+	states <- 0b10
+	clock.Advance(100 * ms)
+	states <- 0
+	// There's one sleeper for the original long-press
+	// deadline and another for the double-click deadline.
+	clock.WaitSleepers(2)
+	clock.Advance(200 * ms)
+	c.Assert(formatEvent(<-events), qt.Equals, "click 1 @800ms")
+
+	close(states)
+	<-done
+}
+
+func TestKindString(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(DoubleClick.String(), qt.Equals, "double-click")
+	c.Assert(Kind(99).String(), qt.Equals, "Kind(99)")
+}
+
+func formatEvents(events []Event) []string {
+	var s []string
+	for _, e := range events {
+		s = append(s, formatEvent(e))
+	}
+	return s
+}
+
+func formatEvent(e Event) string {
+	at := e.Time.Sub(epoch)
+	switch e.Kind {
+	case Chord:
+		return fmt.Sprintf("%v %#b @%v", e.Kind, e.Buttons, at)
+	case Hold:
+		return fmt.Sprintf("%v %d #%d @%v", e.Kind, e.Button, e.Repeat, at)
+	}
+	return fmt.Sprintf("%v %d @%v", e.Kind, e.Button, at)
+}
@@ -2,4 +2,8 @@ module github.com/rogpeppe/doorbell
 
 go 1.15
 
-require github.com/frankban/quicktest v1.10.0
+require (
+	github.com/frankban/quicktest v1.10.2
+	tinygo.org/x/drivers v0.20.0
+	tinygo.org/x/tinyfs v0.1.0
+)
@@ -2,8 +2,41 @@
 
 package main
 
-import "github.com/rogpeppe/doorbell/mcp23017"
+import (
+	"io"
+	"os"
+
+	"github.com/rogpeppe/doorbell/eventlog"
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/ssd1306"
+	"github.com/rogpeppe/doorbell/tunestore"
+	"github.com/rogpeppe/doorbell/watchdog"
+)
 
 func getDevices(addrs ...uint8) (mcp23017.Devices, error) {
 	panic("this only runs with tinygo")
 }
+
+func getHardwareWatchdog() watchdog.Hardware {
+	return nil
+}
+
+func getButtonInterrupt() <-chan struct{} {
+	return nil
+}
+
+func getFS() tunestore.FS {
+	return nil
+}
+
+func getEventFlash() eventlog.BlockDevice {
+	return nil
+}
+
+func getSerial() (io.Reader, io.Writer) {
+	return os.Stdin, os.Stdout
+}
+
+func getDisplays() (info, roll *ssd1306.Device) {
+	return nil, nil
+}
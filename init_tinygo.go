@@ -3,9 +3,17 @@
 package main
 
 import (
+	"io"
 	"machine"
+	"time"
 
+	"github.com/rogpeppe/doorbell/eventlog"
 	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/ssd1306"
+	"github.com/rogpeppe/doorbell/tunestore"
+	"github.com/rogpeppe/doorbell/watchdog"
+	"tinygo.org/x/drivers/flash"
+	"tinygo.org/x/tinyfs/littlefs"
 )
 
 func getDevices(addrs ...uint8) (mcp23017.Devices, error) {
@@ -16,3 +24,180 @@ func getDevices(addrs ...uint8) (mcp23017.Devices, error) {
 	}
 	return mcp23017.NewI2CDevices(machine.I2C0, addrs...)
 }
+
+// hardwareWatchdogTimeout holds how long the hardware watchdog
+// waits for an update before resetting the board. It needs to be
+// comfortably longer than watchdogInterval because the scheduler
+// is cooperative, and writing the event log to flash can take a while.
+const hardwareWatchdogTimeout = 4 * time.Second
+
+// getHardwareWatchdog starts the SAMD51 watchdog timer
+// and returns it. Once it's started, it can't be stopped.
+func getHardwareWatchdog() watchdog.Hardware {
+	if err := machine.Watchdog.Configure(machine.WatchdogConfig{
+		TimeoutMillis: uint32(hardwareWatchdogTimeout / time.Millisecond),
+	}); err != nil {
+		println("cannot configure watchdog: ", err.Error())
+		return nil
+	}
+	if err := machine.Watchdog.Start(); err != nil {
+		println("cannot start watchdog: ", err.Error())
+		return nil
+	}
+	return machine.Watchdog
+}
+
+// buttonInterruptPin holds the pin that's connected to the
+// INTA and INTB outputs of the button device, which are
+// mirrored so either or both can be used. The interrupt isn't
+// wired up yet, so the buttons are polled continually; set this
+// to the right pin (and record it in doc/board.txt) when it is.
+const buttonInterruptPin = machine.NoPin
+
+func getButtonInterrupt() <-chan struct{} {
+	if buttonInterruptPin == machine.NoPin {
+		return nil
+	}
+	c := make(chan struct{}, 1)
+	// The interrupt output is open-drain and active-low.
+	buttonInterruptPin.Configure(machine.PinConfig{Mode: machine.PinInputPullup})
+	err := buttonInterruptPin.SetInterrupt(machine.PinFalling, func(machine.Pin) {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		println("cannot set button interrupt; polling instead: ", err.Error())
+		return nil
+	}
+	return c
+}
+
+// getFS returns the littlefs filesystem on the 2MB SPI flash,
+// formatting the flash if it doesn't hold one yet. It returns
+// nil if the flash can't be used.
+func getFS() tunestore.FS {
+	dev := flash.NewQSPI(
+		machine.QSPI_CS,
+		machine.QSPI_SCK,
+		machine.QSPI_DATA0,
+		machine.QSPI_DATA1,
+		machine.QSPI_DATA2,
+		machine.QSPI_DATA3,
+	)
+	if err := dev.Configure(&flash.DeviceConfig{
+		Identifier: flash.DefaultDeviceIdentifier,
+	}); err != nil {
+		println("cannot configure SPI flash: ", err.Error())
+		return nil
+	}
+	lfs := littlefs.New(dev)
+	lfs.Configure(&littlefs.Config{
+		CacheSize:     512,
+		LookaheadSize: 512,
+		BlockCycles:   100,
+	})
+	if err := lfs.Mount(); err != nil {
+		println("formatting SPI flash: ", err.Error())
+		if err := lfs.Format(); err != nil {
+			println("cannot format SPI flash: ", err.Error())
+			return nil
+		}
+		if err := lfs.Mount(); err != nil {
+			println("cannot mount SPI flash: ", err.Error())
+			return nil
+		}
+	}
+	return littleFS{lfs}
+}
+
+// getEventFlash returns the flash used to save the event log.
+func getEventFlash() eventlog.BlockDevice {
+	if flashBlocks() <= eventLogBlocks() {
+		println("not enough flash for event log")
+		return nil
+	}
+	return &flashPartition{
+		dev:   machine.Flash,
+		start: flashBlocks() - eventLogBlocks(),
+		n:     eventLogBlocks(),
+	}
+}
+
+// flashBlocks returns the number of erase blocks in machine.Flash.
+func flashBlocks() int64 {
+	return machine.Flash.Size() / machine.Flash.EraseBlockSize()
+}
+
+// eventLogBlocks returns the number of erase blocks
+// needed to save the event log.
+func eventLogBlocks() int64 {
+	blockSize := machine.Flash.EraseBlockSize()
+	return (eventlog.EncodedSize(eventLogSize) + blockSize - 1) / blockSize
+}
+
+// getSerial returns the reader and writer used
+// for the serial console.
+func getSerial() (io.Reader, io.Writer) {
+	return serialReader{}, serialWriter{}
+}
+
+// serialReader implements io.Reader by reading from machine.Serial.
+type serialReader struct{}
+
+func (serialReader) Read(buf []byte) (int, error) {
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	// There's no way to block waiting for input, so poll
+	// slowly enough that other goroutines can run.
+	for machine.Serial.Buffered() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	n := 0
+	for n < len(buf) && machine.Serial.Buffered() > 0 {
+		b, err := machine.Serial.ReadByte()
+		if err != nil {
+			break
+		}
+		buf[n] = b
+		n++
+	}
+	return n, nil
+}
+
+// serialWriter implements io.Writer by writing to machine.Serial,
+// translating newlines to CR-LF as terminal programs expect.
+type serialWriter struct{}
+
+func (serialWriter) Write(buf []byte) (int, error) {
+	for _, b := range buf {
+		if b == '\n' {
+			if err := machine.Serial.WriteByte('\r'); err != nil {
+				return 0, err
+			}
+		}
+		if err := machine.Serial.WriteByte(b); err != nil {
+			return 0, err
+		}
+	}
+	return len(buf), nil
+}
+
+// getDisplays returns the status displays. Either may be
+// nil if the display can't be initialized. It must be called
+// after getDevices because that configures the I2C bus.
+func getDisplays() (info, roll *ssd1306.Device) {
+	info, err := ssd1306.NewI2C(machine.I2C0, 0x3c)
+	if err != nil {
+		println("cannot initialize info display: ", err.Error())
+		info = nil
+	}
+	roll, err = ssd1306.NewI2C(machine.I2C0, 0x3d)
+	if err != nil {
+		println("cannot initialize roll display: ", err.Error())
+		roll = nil
+	}
+	return info, roll
+}
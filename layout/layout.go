@@ -0,0 +1,401 @@
+// Package layout describes how the doorbell hardware is wired:
+// which MCP23017 expanders there are, which of their pins drive
+// the solenoids and which are connected to the door buttons.
+// The same firmware can then run on doorbells with different wiring.
+//
+// A layout is written as a sequence of statements, one per line
+// or separated by semicolons. Text from // to the end of a
+// line is ignored. The statements are:
+//
+//	solenoids PIN...           add solenoids, in note order
+//	buttons PIN... [OPTION...] add buttons, in button order
+//
+// Both statements can be given more than once; each adds to the
+// solenoids or buttons added by earlier statements. The first
+// solenoid plays the lowest note (channel 0), and successive
+// solenoids play successive semitones. The options apply to
+// all the button pins on the same line. They are:
+//
+//	pullup              enable the pull-up resistor
+//	invert              invert the value of the pin
+//	indoor              the button is inside the house
+//	                    rather than at a door
+//	debounce=STRATEGY   debounce the button with the given
+//	                    strategy: immediate (the default),
+//	                    integrator, lockout or minhold
+//	                    (see debounce.Strategy)
+//	press=DURATION      the debounce interval for presses
+//	release=DURATION    the debounce interval for releases
+//
+// A button that connects its pin to ground when pressed needs
+// both pullup and invert. Durations are in the form accepted
+// by time.ParseDuration, for example 80ms.
+//
+// A PIN is written as the I2C address of the expander, a colon and
+// the name of the pin on that expander: A0 to A7 for port A and B0
+// to B7 for port B. A range of pins can be written by adding a
+// hyphen and the last pin, which may be lower than the first for
+// pins that are wired in reverse order. For example:
+//
+//	solenoids 0x21:A0-A7 0x20:B0-B7 // first two octaves
+//	solenoids 0x20:A7-A0            // wired in reverse
+//	buttons 0x22:A0-A3 pullup invert debounce=integrator press=80ms
+//	buttons 0x22:A4 pullup invert indoor
+//
+// All the buttons must be on the same expander. The expanders
+// are listed in Layout.Expanders in the order that they're first
+// mentioned.
+package layout
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rogpeppe/doorbell/debounce"
+	"github.com/rogpeppe/doorbell/mcp23017"
+)
+
+// MaxSolenoids holds the maximum number of solenoids
+// in a layout. It's limited by the size of sequence.Action.Chan.
+const MaxSolenoids = 256
+
+// Pin identifies a pin on an expander.
+type Pin struct {
+	// Expander holds the I2C address of the expander.
+	Expander uint8
+	// Pin holds the number of the pin on the expander:
+	// 0 to 7 are port A and 8 to 15 are port B.
+	Pin int
+}
+
+// String returns the pin in the form used in layouts,
+// for example "0x20:B3".
+func (p Pin) String() string {
+	port := "A"
+	if p.Pin >= 8 {
+		port = "B"
+	}
+	return hex(p.Expander) + ":" + port + strconv.Itoa(p.Pin%8)
+}
+
+// Button holds the wiring of a button.
+type Button struct {
+	Pin
+	// Mode holds the pin mode for the button. It's always
+	// an input, possibly with mcp23017.Pullup or
+	// mcp23017.Invert set.
+	Mode mcp23017.PinMode
+	// Indoor holds whether the button is inside the house.
+	Indoor bool
+	// Debounce holds how the button is debounced.
+	Debounce debounce.Config
+}
+
+// Layout holds the wiring of a doorbell.
+type Layout struct {
+	// Expanders holds the I2C address of each expander.
+	Expanders []uint8
+	// Solenoids holds the pin that drives each solenoid,
+	// indexed by channel.
+	Solenoids []Pin
+	// Buttons holds the pin for each button, indexed
+	// by button number.
+	Buttons []Button
+}
+
+// Modes returns the modes of all the pins on all the expanders,
+// in the same order as l.Expanders, suitable for passing to
+// mcp23017.Devices.SetModes. Solenoid pins are outputs, button
+// pins have their configured modes, and unused pins are inputs
+// with pull-up resistors so that they don't float.
+func (l *Layout) Modes() []mcp23017.PinMode {
+	modes := make([]mcp23017.PinMode, len(l.Expanders)*mcp23017.PinCount)
+	for i := range modes {
+		modes[i] = mcp23017.Input | mcp23017.Pullup
+	}
+	for _, p := range l.Solenoids {
+		modes[l.index(p)] = mcp23017.Output
+	}
+	for _, b := range l.Buttons {
+		modes[l.index(b.Pin)] = b.Mode
+	}
+	return modes
+}
+
+// SolenoidPins returns the pin for each solenoid. The devices
+// must correspond to the addresses in l.Expanders.
+func (l *Layout) SolenoidPins(devs mcp23017.Devices) []mcp23017.Pin {
+	pins := make([]mcp23017.Pin, len(l.Solenoids))
+	for i, p := range l.Solenoids {
+		pins[i] = devs.Pin(l.index(p))
+	}
+	return pins
+}
+
+// SolenoidIndexes returns the index of the pin for each solenoid
+// within the pins of all the expanders, as used by
+// mcp23017.Devices.SetPins and mcp23017.PinSlice.
+func (l *Layout) SolenoidIndexes() []int {
+	indexes := make([]int, len(l.Solenoids))
+	for i, p := range l.Solenoids {
+		indexes[i] = l.index(p)
+	}
+	return indexes
+}
+
+// ButtonPins returns the index into l.Expanders of the expander
+// holding the buttons and the pin on that expander for each button.
+// If there are no buttons, it returns -1.
+func (l *Layout) ButtonPins() (expander int, pins []int) {
+	if len(l.Buttons) == 0 {
+		return -1, nil
+	}
+	pins = make([]int, len(l.Buttons))
+	for i, b := range l.Buttons {
+		pins[i] = b.Pin.Pin
+	}
+	return l.expander(l.Buttons[0].Expander), pins
+}
+
+// IndoorButtons returns the set of indoor buttons,
+// with bit i set if button i is indoors.
+func (l *Layout) IndoorButtons() mcp23017.Pins {
+	var pins mcp23017.Pins
+	for i, b := range l.Buttons {
+		pins.Set(i, b.Indoor)
+	}
+	return pins
+}
+
+// ButtonDebounce returns the debounce configuration
+// for each button, indexed by button number.
+func (l *Layout) ButtonDebounce() []debounce.Config {
+	cfgs := make([]debounce.Config, len(l.Buttons))
+	for i, b := range l.Buttons {
+		cfgs[i] = b.Debounce
+	}
+	return cfgs
+}
+
+// index returns the index of p within the pins of all the
+// expanders, as used by mcp23017.Devices.
+func (l *Layout) index(p Pin) int {
+	return l.expander(p.Expander)*mcp23017.PinCount + p.Pin
+}
+
+// expander returns the index of the expander with the
+// given address, or -1 if there is none.
+func (l *Layout) expander(addr uint8) int {
+	for i, a := range l.Expanders {
+		if a == addr {
+			return i
+		}
+	}
+	return -1
+}
+
+// MustParse is like Parse except that it panics on error.
+// It's intended for layouts that are compiled into the firmware.
+func MustParse(text string) *Layout {
+	l, err := Parse(text)
+	if err != nil {
+		panic("invalid layout: " + err.Error())
+	}
+	return l
+}
+
+// Parse parses a layout in the form described in the package
+// documentation.
+func Parse(text string) (*Layout, error) {
+	l := &Layout{}
+	// used holds the statement that each pin
+	// was used in, so that pins can't be used twice.
+	used := make(map[Pin]int)
+	n := 0
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		for _, stmt := range strings.Split(line, ";") {
+			words := strings.Fields(stmt)
+			if len(words) == 0 {
+				continue
+			}
+			n++
+			if err := l.parseStatement(words, used, n); err != nil {
+				return nil, errors.New("statement " + strconv.Itoa(n) + ": " + err.Error())
+			}
+		}
+	}
+	if len(l.Solenoids) > MaxSolenoids {
+		return nil, errors.New("too many solenoids (maximum " + strconv.Itoa(MaxSolenoids) + ")")
+	}
+	return l, nil
+}
+
+func (l *Layout) parseStatement(words []string, used map[Pin]int, n int) error {
+	if words[0] != "solenoids" && words[0] != "buttons" {
+		return errors.New("unknown statement " + strconv.Quote(words[0]))
+	}
+	var mode mcp23017.PinMode
+	var indoor bool
+	var debounceCfg debounce.Config
+	// option holds the first option, if any.
+	option := ""
+	var pins []Pin
+	for _, word := range words[1:] {
+		isOption, err := parseOption(word, &mode, &indoor, &debounceCfg)
+		if err != nil {
+			return err
+		}
+		if isOption {
+			if option == "" {
+				option = word
+			}
+			continue
+		}
+		r, err := parsePinRange(word)
+		if err != nil {
+			return err
+		}
+		for _, p := range r {
+			if stmt, ok := used[p]; ok {
+				return errors.New("pin " + p.String() + " already used in statement " + strconv.Itoa(stmt))
+			}
+			used[p] = n
+		}
+		pins = append(pins, r...)
+	}
+	if len(pins) == 0 {
+		return errors.New("no pins specified")
+	}
+	if words[0] == "solenoids" {
+		if option != "" {
+			return errors.New("option " + strconv.Quote(option) + " is only allowed for buttons")
+		}
+		l.Solenoids = append(l.Solenoids, pins...)
+	} else {
+		for _, p := range pins {
+			if len(l.Buttons) > 0 && p.Expander != l.Buttons[0].Expander {
+				return errors.New("buttons must all be on the same expander")
+			}
+			l.Buttons = append(l.Buttons, Button{
+				Pin:      p,
+				Mode:     mcp23017.Input | mode,
+				Indoor:   indoor,
+				Debounce: debounceCfg,
+			})
+		}
+	}
+	for _, p := range pins {
+		if l.expander(p.Expander) == -1 {
+			l.Expanders = append(l.Expanders, p.Expander)
+		}
+	}
+	return nil
+}
+
+// parseOption parses a button option, updating the given
+// values accordingly. It reports whether the word is an option
+// rather than a pin.
+func parseOption(word string, mode *mcp23017.PinMode, indoor *bool, cfg *debounce.Config) (bool, error) {
+	switch word {
+	case "pullup":
+		*mode |= mcp23017.Pullup
+		return true, nil
+	case "invert":
+		*mode |= mcp23017.Invert
+		return true, nil
+	case "indoor":
+		*indoor = true
+		return true, nil
+	}
+	i := strings.Index(word, "=")
+	if i == -1 {
+		return false, nil
+	}
+	key, val := word[:i], word[i+1:]
+	switch key {
+	case "debounce":
+		s, err := debounce.ParseStrategy(val)
+		if err != nil {
+			return false, err
+		}
+		cfg.Strategy = s
+	case "press", "release":
+		d, err := time.ParseDuration(val)
+		if err != nil || d <= 0 {
+			return false, errors.New("invalid duration in " + strconv.Quote(word))
+		}
+		if key == "press" {
+			cfg.Press = d
+		} else {
+			cfg.Release = d
+		}
+	default:
+		return false, errors.New("unknown option " + strconv.Quote(word))
+	}
+	return true, nil
+}
+
+// parsePinRange parses a pin or a range of pins.
+func parsePinRange(s string) ([]Pin, error) {
+	i := strings.Index(s, ":")
+	if i == -1 {
+		return nil, errors.New("invalid pin " + strconv.Quote(s) + "; want ADDR:PIN")
+	}
+	addr, err := strconv.ParseUint(s[:i], 0, 8)
+	// Only the low three bits of the address can
+	// be set by the address pins.
+	if err != nil || addr&^7 != 0x20 {
+		return nil, errors.New("invalid expander address " + strconv.Quote(s[:i]))
+	}
+	first, last := s[i+1:], s[i+1:]
+	if j := strings.Index(first, "-"); j >= 0 {
+		first, last = first[:j], first[j+1:]
+	}
+	p0, err := parsePinName(first)
+	if err != nil {
+		return nil, err
+	}
+	p1, err := parsePinName(last)
+	if err != nil {
+		return nil, err
+	}
+	step := 1
+	if p1 < p0 {
+		step = -1
+	}
+	var pins []Pin
+	for p := p0; ; p += step {
+		pins = append(pins, Pin{
+			Expander: uint8(addr),
+			Pin:      p,
+		})
+		if p == p1 {
+			break
+		}
+	}
+	return pins, nil
+}
+
+// parsePinName parses a pin name such as A3 or B7.
+func parsePinName(s string) (int, error) {
+	if len(s) != 2 || s[1] < '0' || s[1] > '7' {
+		return 0, errors.New("invalid pin name " + strconv.Quote(s))
+	}
+	switch s[0] {
+	case 'A':
+		return int(s[1] - '0'), nil
+	case 'B':
+		return 8 + int(s[1]-'0'), nil
+	}
+	return 0, errors.New("invalid pin name " + strconv.Quote(s))
+}
+
+func hex(x uint8) string {
+	digits := "0123456789abcdef"
+	return "0x" + digits[x>>4:x>>4+1] + digits[x&0xf:x&0xf+1]
+}
@@ -0,0 +1,206 @@
+package layout
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/debounce"
+	"github.com/rogpeppe/doorbell/mcp23017"
+)
+
+var parseTests = []struct {
+	testName    string
+	text        string
+	expect      *Layout
+	expectError string
+}{{
+	testName: "empty",
+	text:     "// nothing here\n",
+	expect:   &Layout{},
+}, {
+	testName: "ranges",
+	text: `
+solenoids 0x21:A6-B1  // across ports
+solenoids 0x20:A2-A0; solenoids 0x20:B7
+buttons 0x22:A0 0x22:B0-B1 pullup invert
+buttons 0x22:A1 indoor debounce=integrator press=80ms release=100ms
+`,
+	expect: &Layout{
+		Expanders: []uint8{0x21, 0x20, 0x22},
+		Solenoids: []Pin{
+			{0x21, 6},
+			{0x21, 7},
+			{0x21, 8},
+			{0x21, 9},
+			{0x20, 2},
+			{0x20, 1},
+			{0x20, 0},
+			{0x20, 15},
+		},
+		Buttons: []Button{
+			{Pin: Pin{0x22, 0}, Mode: mcp23017.Input | mcp23017.Pullup | mcp23017.Invert},
+			{Pin: Pin{0x22, 8}, Mode: mcp23017.Input | mcp23017.Pullup | mcp23017.Invert},
+			{Pin: Pin{0x22, 9}, Mode: mcp23017.Input | mcp23017.Pullup | mcp23017.Invert},
+			{
+				Pin:    Pin{0x22, 1},
+				Mode:   mcp23017.Input,
+				Indoor: true,
+				Debounce: debounce.Config{
+					Strategy: debounce.Integrator,
+					Press:    80 * time.Millisecond,
+					Release:  100 * time.Millisecond,
+				},
+			},
+		},
+	},
+}, {
+	testName:    "unknown-statement",
+	text:        "solenoids 0x20:A0\nleds 0x20:A1",
+	expectError: `statement 2: unknown statement "leds"`,
+}, {
+	testName:    "no-pins",
+	text:        "buttons pullup",
+	expectError: `statement 1: no pins specified`,
+}, {
+	testName:    "missing-address",
+	text:        "solenoids A0",
+	expectError: `statement 1: invalid pin "A0"; want ADDR:PIN`,
+}, {
+	testName:    "invalid-address",
+	text:        "solenoids 0x40:A0",
+	expectError: `statement 1: invalid expander address "0x40"`,
+}, {
+	testName:    "invalid-pin-name",
+	text:        "solenoids 0x20:A8",
+	expectError: `statement 1: invalid pin name "A8"`,
+}, {
+	testName:    "invalid-range",
+	text:        "solenoids 0x20:A0-C1",
+	expectError: `statement 1: invalid pin name "C1"`,
+}, {
+	testName:    "pin-used-twice",
+	text:        "solenoids 0x20:A0-A7\nbuttons 0x20:A3",
+	expectError: `statement 2: pin 0x20:A3 already used in statement 1`,
+}, {
+	testName:    "solenoid-modes",
+	text:        "solenoids 0x20:A0 pullup",
+	expectError: `statement 1: option "pullup" is only allowed for buttons`,
+}, {
+	testName:    "solenoid-debounce",
+	text:        "solenoids 0x20:A0 press=10ms",
+	expectError: `statement 1: option "press=10ms" is only allowed for buttons`,
+}, {
+	testName:    "unknown-option",
+	text:        "buttons 0x20:A0 foo=bar",
+	expectError: `statement 1: unknown option "foo=bar"`,
+}, {
+	testName:    "unknown-strategy",
+	text:        "buttons 0x20:A0 debounce=magic",
+	expectError: `statement 1: unknown debounce strategy "magic"`,
+}, {
+	testName:    "invalid-duration",
+	text:        "buttons 0x20:A0 release=-5ms",
+	expectError: `statement 1: invalid duration in "release=-5ms"`,
+}, {
+	testName:    "buttons-on-different-expanders",
+	text:        "buttons 0x22:A0\nbuttons 0x23:A0",
+	expectError: `statement 2: buttons must all be on the same expander`,
+}}
+
+func TestParse(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseTests {
+		c.Run(test.testName, func(c *qt.C) {
+			l, err := Parse(test.text)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(l, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+func TestMustParsePanics(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(func() {
+		MustParse("foo")
+	}, qt.PanicMatches, `invalid layout: statement 1: unknown statement "foo"`)
+}
+
+func TestModes(t *testing.T) {
+	c := qt.New(t)
+	l := MustParse(`
+solenoids 0x21:A0-A1
+buttons 0x20:B7 invert
+`)
+	modes := l.Modes()
+	c.Assert(modes, qt.HasLen, 2*mcp23017.PinCount)
+	for i, mode := range modes {
+		switch i {
+		case 0, 1:
+			c.Check(mode, qt.Equals, mcp23017.Output, qt.Commentf("pin %d", i))
+		case 16 + 15:
+			c.Check(mode, qt.Equals, mcp23017.Input|mcp23017.Invert, qt.Commentf("pin %d", i))
+		default:
+			c.Check(mode, qt.Equals, mcp23017.Input|mcp23017.Pullup, qt.Commentf("pin %d", i))
+		}
+	}
+}
+
+func TestSolenoidIndexes(t *testing.T) {
+	c := qt.New(t)
+	l := MustParse(`
+solenoids 0x21:A1-A0 0x20:B7
+buttons 0x22:A0
+solenoids 0x22:B0
+`)
+	c.Assert(l.SolenoidIndexes(), qt.DeepEquals, []int{1, 0, 16 + 15, 32 + 8})
+}
+
+func TestButtonPins(t *testing.T) {
+	c := qt.New(t)
+	l := MustParse(`
+solenoids 0x20:A0
+buttons 0x22:A4-A2 0x22:B0
+`)
+	expander, pins := l.ButtonPins()
+	c.Assert(expander, qt.Equals, 1)
+	c.Assert(pins, qt.DeepEquals, []int{4, 3, 2, 8})
+
+	expander, pins = MustParse("").ButtonPins()
+	c.Assert(expander, qt.Equals, -1)
+	c.Assert(pins, qt.HasLen, 0)
+}
+
+func TestIndoorButtons(t *testing.T) {
+	c := qt.New(t)
+	l := MustParse(`
+buttons 0x22:A0-A1
+buttons 0x22:A2 indoor
+buttons 0x22:A3
+`)
+	c.Assert(l.IndoorButtons(), qt.Equals, mcp23017.Pins(1<<2))
+	c.Assert(MustParse("buttons 0x22:A0-A4").IndoorButtons(), qt.Equals, mcp23017.Pins(0))
+}
+
+func TestButtonDebounce(t *testing.T) {
+	c := qt.New(t)
+	l := MustParse(`
+buttons 0x22:A0 press=20ms
+buttons 0x22:A1 debounce=lockout
+`)
+	c.Assert(l.ButtonDebounce(), qt.DeepEquals, []debounce.Config{
+		{Press: 20 * time.Millisecond},
+		{Strategy: debounce.Lockout},
+	})
+}
+
+func TestPinString(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(Pin{0x20, 3}.String(), qt.Equals, "0x20:A3")
+	c.Assert(Pin{0x27, 15}.String(), qt.Equals, "0x27:B7")
+}
@@ -0,0 +1,85 @@
+// +build tinygo
+
+package main
+
+import (
+	"io"
+	"os"
+
+	"github.com/rogpeppe/doorbell/tunestore"
+	"tinygo.org/x/tinyfs/littlefs"
+)
+
+// littleFS implements tunestore.FS on a littlefs filesystem.
+type littleFS struct {
+	fs *littlefs.LFS
+}
+
+func (fs littleFS) ReadDir(dir string) ([]string, error) {
+	f, err := fs.fs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	infos, err := f.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+func (fs littleFS) ReadFile(path string) ([]byte, error) {
+	info, err := fs.fs.Stat(path)
+	if err != nil {
+		return nil, tunestore.ErrNotFound
+	}
+	f, err := fs.fs.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data := make([]byte, info.Size())
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (fs littleFS) WriteFile(path string, data []byte) error {
+	f, err := fs.fs.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	// littlefs's Write doesn't cope with empty buffers.
+	if len(data) > 0 {
+		_, err = f.Write(data)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (fs littleFS) Rename(oldPath, newPath string) error {
+	return fs.fs.Rename(oldPath, newPath)
+}
+
+func (fs littleFS) Remove(path string) error {
+	if _, err := fs.fs.Stat(path); err != nil {
+		return tunestore.ErrNotFound
+	}
+	return fs.fs.Remove(path)
+}
+
+func (fs littleFS) Mkdir(path string) error {
+	if info, err := fs.fs.Stat(path); err == nil && info.IsDir() {
+		return nil
+	}
+	return fs.fs.Mkdir(path, 0777)
+}
@@ -7,253 +7,794 @@ https://pkg.go.dev/github.com/tinygo-org/drivers/flash?tab=doc#Device
 https://github.com/bgould/go-littlefs
 */
 
-// TODO what should we do if two doorbells are activated at the same time?
-
 package main
 
 import (
 	"encoding/binary"
+	"errors"
 	"math/rand"
+	"strconv"
 	"time"
 
+	"github.com/rogpeppe/doorbell/bell"
+	"github.com/rogpeppe/doorbell/console"
 	cryptorand "github.com/rogpeppe/doorbell/crypto/rand"
 	"github.com/rogpeppe/doorbell/debounce"
+	"github.com/rogpeppe/doorbell/eventlog"
+	"github.com/rogpeppe/doorbell/gesture"
+	"github.com/rogpeppe/doorbell/layout"
 	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/protect"
+	"github.com/rogpeppe/doorbell/schedule"
 	"github.com/rogpeppe/doorbell/sequence"
 	"github.com/rogpeppe/doorbell/timer"
+	"github.com/rogpeppe/doorbell/tunestore"
+	"github.com/rogpeppe/doorbell/watchdog"
 )
 
-var buttonPinNumbers = []uint8{
-	0,
-	1,
-	2,
-	3,
-	4,
-}
-
-var solenoidPinNumbers = []uint8{
-	// Back left: 0x21 port A
-	16,
-	17,
-	18,
-	19,
-	20,
-	21,
-	22,
-	23,
-
-	// Back right: 0x20 port B
-	8,
-	9,
-	10,
-	11,
-	12,
-	13,
-	14,
-	15,
-
-	// Front right: 0x20 Port A, reversed.
-	7,
-	6,
-	5,
-	4,
-	3,
-	2,
-	1,
-	0,
-}
-
-var numSolenoids = len(solenoidPinNumbers)
-const numButtons = 5
-
-// solenoidDuration is the amount of time to pulse the
-// solenoid relay for to make the sound.
+// defaultLayout describes how the doorbell is wired (see the
+// layout package). It's used when there's no valid layout in
+// layoutFile.
+const defaultLayout = `
+solenoids 0x21:A0-A7 // back left
+solenoids 0x20:B0-B7 // back right
+solenoids 0x20:A7-A0 // front right, wired in reverse
+// The door buttons are outside at the end of long wires
+// and noisy, so they integrate the input over a longer interval.
+buttons 0x22:A0-A4 pullup invert debounce=integrator press=80ms release=100ms
+`
+
+// layoutFile holds the file that the board layout is read
+// from, so that the firmware can run on a doorbell with
+// different wiring.
+const layoutFile = "/layout"
+
+// numSolenoids holds the number of solenoids in the board
+// layout. It's set by main when the layout has been loaded.
+var numSolenoids int
+
+// solenoidDuration is the default amount of time to pulse the
+// solenoid relay for to make the sound. It can be changed
+// for each solenoid with the pulse-widths setting.
 const solenoidDuration = 200 * time.Millisecond
 
+// calibrationInterval holds the time between the strikes
+// made by the console calibrate command. It leaves
+// time to hear each strike before the next one.
+const calibrationInterval = 1500 * time.Millisecond
+
+// solenoidLimits holds the limits that protect the solenoids
+// and the power supply from being driven too hard.
+var solenoidLimits = protect.Config{
+	MinOffTime: 100 * time.Millisecond,
+	MaxActive:  8,
+	MaxDuty:    0.5,
+	DutyWindow: 10 * time.Second,
+	MaxDelay:   250 * time.Millisecond,
+}
+
+// i2cRetries holds the retry policy for the I/O expanders,
+// so that a glitch on the bus doesn't leave a solenoid
+// energised or lose a button press.
+var i2cRetries = mcp23017.RetryPolicy{
+	Attempts: 3,
+	Delay:    time.Millisecond,
+}
+
+// solenoidMaxOn holds the longest time that a solenoid can
+// be left on before the watchdog turns it off. It's longer than
+// any pulse width that can be configured so that it only
+// matters when something has gone wrong.
+const solenoidMaxOn = 2 * maxPulseWidth
+
+// watchdogInterval holds the longest time between
+// watchdog checks.
+const watchdogInterval = 250 * time.Millisecond
+
+// expanderCheckInterval holds how often the I/O expanders are
+// checked for having reset (see mcp23017.Device.Check).
+const expanderCheckInterval = 5 * time.Second
+
 func main() {
 	time.Sleep(3 * time.Second)
 	println("starting....")
-	devs, err := getDevices(0x20, 0x21, 0x22)
+	fs := getFS()
+	boardLayout, err := loadLayout(fs)
+	if boardLayout == nil {
+		fatal("cannot load layout: ", err.Error())
+	}
 	if err != nil {
-		fatal("cannot make new i2c devices: ", err.Error())
+		println(err.Error(), "; using the default layout")
 	}
-	outputs := devs[0:2]
-	outputs.SetModes([]mcp23017.PinMode{mcp23017.Output})
-	solenoidPins := make([]Pin, len(solenoidPinNumbers))
-	for i, n := range solenoidPinNumbers {
-		solenoidPins[i] = outputs.Pin(int(n))
+	numSolenoids = len(boardLayout.Solenoids)
+	devs, err := getDevices(boardLayout.Expanders...)
+	if err != nil {
+		fatal("cannot make new i2c devices: ", err.Error())
 	}
-	println("pin count ", len(solenoidPins))
-	inputs := devs[2]
-	if err := inputs.SetModes([]mcp23017.PinMode{mcp23017.Input | mcp23017.Pullup | mcp23017.Invert}); err != nil {
+	devs.SetRetryPolicy(i2cRetries)
+	if err := devs.SetModes(boardLayout.Modes()); err != nil {
 		fatal("cannot set modes: ", err.Error())
 	}
+	solenoids := newSolenoidPins(devs, boardLayout.SolenoidIndexes())
+	println("pin count ", solenoids.Len())
+	buttonExpander, buttonPins := boardLayout.ButtonPins()
+	if buttonExpander < 0 {
+		fatal("no buttons in layout")
+	}
+	buttons := &buttonDevice{
+		dev:       devs[buttonExpander],
+		pins:      buttonPins,
+		indoor:    boardLayout.IndoorButtons(),
+		debounce:  boardLayout.ButtonDebounce(),
+		interrupt: getButtonInterrupt(),
+	}
+	if err := buttons.configureInterrupts(); err != nil {
+		fatal("cannot configure interrupts: ", err.Error())
+	}
 	println("set modes etc")
-	tunes, err := readTunes()
+	store := newTuneStore(fs)
+	tunes, err := readTunes(store)
 	if err != nil {
 		fatal("cannot read tunes: ", err.Error())
 	}
 	Doorbell(DoorbellParams{
-		Solenoids: solenoidPins,
-		DoorButtons: &buttonDevice{
-			dev:  inputs,
-			mask: 1<<len(buttonPinNumbers) - 1,
-		},
-		Tunes: tunes,
-		Rand:  newRandSource(),
+		Watchdog:    getHardwareWatchdog(),
+		Solenoids:   solenoids,
+		DoorButtons: buttons,
+		Tunes:       tunes,
+		TuneStore:   store,
+		FS:          fs,
+		Display:     newStatusDisplay(getDisplays()),
+		Rand:        newRandSource(),
+		EventFlash:  getEventFlash(),
+		Expanders:   devs,
 	})
 }
 
-type Pin = mcp23017.Pin
+// solenoidPins implements watchdog.Pins on the pins
+// of the expanders that drive the solenoids.
+// It's not safe for concurrent use.
+type solenoidPins struct {
+	devs mcp23017.Devices
+	// index holds the index within devs of the
+	// pin for each channel.
+	index []int
+	// values and mask are used by SetPins to avoid allocation.
+	values, mask mcp23017.PinSlice
+}
+
+func newSolenoidPins(devs mcp23017.Devices, index []int) *solenoidPins {
+	return &solenoidPins{
+		devs:   devs,
+		index:  index,
+		values: mcp23017.NewPinSlice(len(devs) * mcp23017.PinCount),
+		mask:   mcp23017.NewPinSlice(len(devs) * mcp23017.PinCount),
+	}
+}
+
+func (p *solenoidPins) Len() int {
+	return len(p.index)
+}
+
+func (p *solenoidPins) Set(ch int, on bool) error {
+	return p.devs.Pin(p.index[ch]).Set(on)
+}
+
+// SetPins sets all the channels in a single call to
+// Devices.SetPins so that pins on the same
+// expander change at the same time.
+func (p *solenoidPins) SetPins(values, mask mcp23017.PinSlice) error {
+	for i := range p.mask {
+		p.values[i], p.mask[i] = 0, 0
+	}
+	for ch, pin := range p.index {
+		if mask.Get(ch) {
+			p.mask.High(pin)
+			p.values.Set(pin, values.Get(ch))
+		}
+	}
+	return p.devs.SetPins(p.values, p.mask)
+}
 
 type buttonDevice struct {
-	dev  *mcp23017.Device
-	mask mcp23017.Pins
+	dev *mcp23017.Device
+	// pins holds the pin on dev for each button.
+	pins []int
+	// indoor holds the set of buttons that are inside the
+	// house. They don't ring the bell; instead a double
+	// press toggles silent mode (see indoorGestures).
+	indoor mcp23017.Pins
+	// debounce holds the debounce configuration
+	// for each button.
+	debounce []debounce.Config
+	// interrupt receives a value when the device signals
+	// an interrupt. If it's nil, the buttons are continually polled.
+	interrupt <-chan struct{}
+}
+
+// configureInterrupts configures the button device so that
+// it signals an interrupt when any of the buttons changes state.
+// The interrupt pins are mirrored so it doesn't matter
+// which one is wired up, and open-drain so that they can
+// be wired together with other devices.
+func (b *buttonDevice) configureInterrupts() error {
+	if err := b.dev.ConfigureInterrupts(mcp23017.InterruptConfig{
+		Mirror:    true,
+		OpenDrain: true,
+	}); err != nil {
+		return err
+	}
+	for _, pin := range b.pins {
+		if err := b.dev.ConfigureInterruptPin(pin, mcp23017.Change); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func (b *buttonDevice) buttons() mcp23017.Pins {
-	// Ignore error because we don't care enough.
-	buts, _ := b.dev.GetPins()
-	return buts & b.mask
+// buttons returns the current state of the buttons,
+// with bit i holding the state of button i.
+func (b *buttonDevice) buttons() (mcp23017.Pins, error) {
+	all, err := b.dev.GetPins()
+	var buts mcp23017.Pins
+	for i, pin := range b.pins {
+		buts.Set(i, all.Get(pin))
+	}
+	return buts, err
 }
 
 type DoorbellParams struct {
-	Solenoids   []mcp23017.Pin
+	// Watchdog holds the hardware watchdog, which
+	// is updated by the solenoid watchdog so that the
+	// system is reset if that stops running. It may be nil.
+	Watchdog    watchdog.Hardware
+	Solenoids   *solenoidPins
 	DoorButtons *buttonDevice
-	Tunes       [][]sequence.Action
-	Rand        *rand.Rand
+	Tunes       []tune
+	// TuneStore holds the store that Tunes were read from.
+	// It's used by the serial console.
+	TuneStore tunestore.Store
+	// FS holds the filesystem that the settings
+	// are saved to. It may be nil.
+	FS tunestore.FS
+	// Display is used to show the doorbell status.
+	// It may be nil.
+	Display *statusDisplay
+	Rand    *rand.Rand
+	// EventFlash holds the flash that the event log
+	// is saved to. It may be nil.
+	EventFlash eventlog.BlockDevice
+	// Expanders holds the I/O expanders, which are
+	// checked periodically in case they've reset.
+	Expanders mcp23017.Devices
 }
 
 func Doorbell(p DoorbellParams) {
 	println("starting doorbell")
 	pushed := make(chan mcp23017.Pins, 1)
-	go buttonPoller(p.DoorButtons, pushed)
-	go player(p.Solenoids, p.Tunes, pushed, p.Rand)
+	newTunes := make(chan []tune, 1)
+	cfg := newSettings()
+	if err := cfg.load(p.FS); err != nil {
+		println("cannot load settings: ", err.Error())
+	}
+	events := newEventRecorder(cfg, p.EventFlash)
+	go events.saver()
+	go buttonPoller(p.DoorButtons, pushed, events)
+	go expanderChecker(p.Expanders, events)
+	wd := watchdog.New(p.Solenoids, solenoidMaxOn, nil)
+	go wd.Run(watchdogInterval, p.Watchdog, nil, func(chans []int, err error) {
+		for _, ch := range chans {
+			events.error("watchdog: chan " + strconv.Itoa(ch))
+		}
+		if err != nil {
+			println("watchdog cannot turn off solenoid: ", err.Error())
+		}
+	})
+	solenoids := protect.New(wd, solenoidLimits, nil)
+	go player(timer.RealClock, solenoids, p.Tunes, newTunes, pushed, p.DoorButtons.indoor, p.Rand, cfg, p.Display, events)
+	go serveConsole(&console.Console{
+		Doorbell: &consoleDoorbell{
+			cfg:       cfg,
+			solenoids: solenoids,
+			buttons:   p.DoorButtons,
+			store:     p.TuneStore,
+			newTunes:  newTunes,
+			display:   p.Display,
+		},
+		Tunes:        p.TuneStore,
+		Config:       cfg,
+		ChanCount:    p.Solenoids.Len(),
+		FireDuration: solenoidDuration,
+		Events:       events.log,
+	})
 	select {}
 }
 
-func player(solenoids []mcp23017.Pin, tunes [][]sequence.Action, pushed <-chan mcp23017.Pins, rand *rand.Rand) {
+// player plays the doorbell sounds in response to the
+// door buttons. The logic lives in bell.Machine; player
+// just turns channel receives into events for it.
+// The clock is used for all timing so that the player can be
+// tested with a fake clock. Buttons in indoor don't ring the bell.
+func player(clock timer.Clock, solenoids *protect.Protector, tunes []tune, newTunes <-chan []tune, pushed <-chan mcp23017.Pins, indoor mcp23017.Pins, rand *rand.Rand, cfg *settings, disp *statusDisplay, events *eventRecorder) {
 	println("in player")
-	timer := timer.NewTimer()
-	selection := newTuneSelection(tunes, rand)
+	out := &bellOutputs{
+		solenoids:  solenoids,
+		playTimer:  timer.NewTimerWithClock(clock),
+		pressTimer: timer.NewTimerWithClock(clock),
+		selections: newButtonSelections(tunes, rand),
+		disp:       disp,
+		events:     events,
+		tempo:      1,
+		widths:     cfg.widths(),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	m := &bell.Machine{
+		Outputs:    out,
+		Clock:      out,
+		Priorities: buttonPriorities(),
+	}
+	gestures := &gesture.Recognizer{
+		Config: indoorGestures,
+	}
+	gestureTimer := timer.NewTimerWithClock(clock)
+	var state mcp23017.Pins
 	for {
-		println("wait for button")
-		// Wait for button to be pushed.
-		<-pushed
-		println("button pushed")
-		// On first push and release, just do a two-note thing.
-		Play(timer, solenoids, dingActions, nil, nil)
-
-		// Wait for all buttons to be released, but if they press the
-		// button for a long time, play a tune instead of the "dong" sound.
-		timer.Reset(750 * time.Millisecond)
-	buttonWait:
-		for {
-			select {
-			case state := <-pushed:
-				if state == 0 {
-					Play(timer, solenoids, dongActions, nil, nil)
-					break buttonWait
+		m.LongPress = cfg.longPressTime()
+		select {
+		case tunes := <-newTunes:
+			out.selections = newButtonSelections(tunes, rand)
+		case newState := <-pushed:
+			events.buttons(newState)
+			out.policy = cfg.quietPolicy()
+			out.tempo = cfg.tempoScale()
+			out.widths = cfg.widths()
+			for i := range buttonConfigs {
+				if indoor.Get(i) {
+					continue
 				}
-				// One of the buttons is still pressed.
-				// TODO is this actually the right thing to do when other buttons are pushed?
-			case <-timer.C:
-				// The button's been pushed for a long time: start a tune playing.
-				stop := make(chan struct{})
-				done := make(chan struct{})
-				selection.reset()
-			tuneLoop:
-				for {
-					go Play(timer, solenoids, selection.choose(), stop, done)
-					// Wait for all buttons to be released.
-					for <-pushed != 0 {
-					}
-					select {
-					case <-pushed:
-						// The button has been pushed again while the tune is playing,
-						// so stop the tune playing and head around the loop to
-						// start another tune.
-						select {
-						case stop <- struct{}{}:
-							<-done
-						case <-done:
-						}
-					case <-done:
-						// The tune has finished playing.
-						break tuneLoop
+				switch {
+				case newState.Get(i) && !state.Get(i):
+					println("button pushed ", i)
+					if cfg.silentMode() || out.policy.Mode == schedule.Silent {
+						// Just show the press without making a sound.
+						disp.buttonPressed(i)
+						continue
 					}
+					m.Handle(bell.Event{Kind: bell.Pressed, Button: i})
+				case !newState.Get(i) && state.Get(i):
+					m.Handle(bell.Event{Kind: bell.Released, Button: i})
 				}
 			}
+			state = newState
+		case <-out.pressTimer.C:
+			m.Handle(bell.Event{Kind: bell.TimerFired})
+		case <-out.done:
+			out.playing = false
+			m.Handle(bell.Event{Kind: bell.TuneDone})
+		case <-gestureTimer.C:
+		}
+		if indoor == 0 {
+			continue
+		}
+		now := clock.Now()
+		for _, e := range gestures.Update(now, state&indoor) {
+			events.gesture(e)
+			if e.Kind == gesture.DoubleClick {
+				println("silent mode ", cfg.toggleSilent())
+			}
+		}
+		if deadline := gestures.Deadline(); !deadline.IsZero() {
+			gestureTimer.Reset(deadline.Sub(now))
+		} else {
+			gestureTimer.Stop()
+		}
+	}
+}
+
+// bellOutputs implements bell.Outputs and bell.Clock
+// for the player.
+type bellOutputs struct {
+	solenoids *protect.Protector
+	// playTimer is used for playing sounds. The bell
+	// state machine never plays a chime while a tune is
+	// playing, so it can be shared between them.
+	playTimer *timer.Timer
+	// pressTimer is used to time long presses.
+	pressTimer *timer.Timer
+	selections []*tuneSelection
+	disp       *statusDisplay
+	events     *eventRecorder
+
+	// stop and done are used to control the playing tune.
+	stop chan struct{}
+	done chan struct{}
+	// playing holds whether a tune is playing.
+	playing bool
+	// policy holds the do-not-disturb policy that was
+	// in force when a button was last pressed.
+	policy schedule.Policy
+	// tempo holds the tempo setting when a button
+	// was last pressed.
+	tempo float64
+	// widths holds the pulse widths setting when
+	// a button was last pressed.
+	widths sequence.Widths
+}
+
+// Ding implements bell.Outputs.Ding.
+func (o *bellOutputs) Ding(button int) {
+	o.disp.buttonPressed(button)
+	Play(o.playTimer, o.solenoids, o.chime(buttonConfigs[button].ding), nil, nil)
+}
+
+// Dong implements bell.Outputs.Dong.
+func (o *bellOutputs) Dong(button int) {
+	Play(o.playTimer, o.solenoids, o.chime(buttonConfigs[button].dong), nil, nil)
+}
+
+// PlayTune implements bell.Outputs.PlayTune.
+func (o *bellOutputs) PlayTune(button int) {
+	o.playing = true
+	if !o.policy.AllowsTunes() {
+		// Play the dong instead of a tune. It's played like
+		// a tune so that the state machine still sees it finish.
+		go Play(o.playTimer, o.solenoids, o.chime(buttonConfigs[button].dong), o.stop, o.done)
+		return
+	}
+	t := o.selections[button].choose()
+	o.events.tune(t.name)
+	actions := sequence.Resize(o.widths)(t.actions)
+	if o.tempo != 1 {
+		actions = sequence.Stretch(1 / o.tempo)(actions)
+	}
+	actions = o.allowed(actions)
+	o.disp.playing(t.name, actions)
+	go Play(o.playTimer, o.solenoids, actions, o.stop, o.done)
+}
+
+// chime returns the actions for a ding or dong, with
+// the current pulse widths, that are allowed by the
+// current do-not-disturb policy.
+func (o *bellOutputs) chime(actions []sequence.Action) []sequence.Action {
+	return o.allowed(sequence.Resize(o.widths)(actions))
+}
+
+// allowed returns the actions that only use the channels
+// allowed by the current do-not-disturb policy.
+func (o *bellOutputs) allowed(actions []sequence.Action) []sequence.Action {
+	if o.policy.Mode != schedule.Reduced {
+		return actions
+	}
+	var allowed []sequence.Action
+	for _, a := range actions {
+		if o.policy.Allows(int(a.Chan)) {
+			allowed = append(allowed, a)
 		}
 	}
+	return allowed
 }
 
-// Play plays the given sequence of actions, using the given
-// pins as channels. It stops if it receives a value on the stop
-// channel.
+// StopTune implements bell.Outputs.StopTune.
+func (o *bellOutputs) StopTune() {
+	if !o.playing {
+		return
+	}
+	select {
+	case o.stop <- struct{}{}:
+		<-o.done
+	case <-o.done:
+	}
+	o.playing = false
+	o.disp.stopped()
+}
+
+// StartTimer implements bell.Clock.StartTimer.
+func (o *bellOutputs) StartTimer(d time.Duration) {
+	o.pressTimer.Reset(d)
+}
+
+// StopTimer implements bell.Clock.StopTimer.
+func (o *bellOutputs) StopTimer() {
+	o.pressTimer.Stop()
+}
+
+// Play plays the given sequence of actions on the given
+// solenoids. It stops if it receives a value on the stop
+// channel. The sequence is first adjusted to keep within
+// the solenoid protection limits.
 //
 // If done is non-nil, a value will be sent on it before Play
 // returns.
-func Play(timer *timer.Timer, pins []mcp23017.Pin, seq []sequence.Action, stop <-chan struct{}, done chan<- struct{}) {
-	start := time.Now()
-	var active mcp23017.Pins
+func Play(timer *timer.Timer, solenoids *protect.Protector, seq []sequence.Action, stop <-chan struct{}, done chan<- struct{}) {
+	// Use the timer's clock so that Play can be tested with a fake clock.
+	clock := timer.Clock()
+	start := clock.Now()
+	plan := solenoids.Plan(seq, start)
+	seq = plan.Actions
+	for _, c := range plan.Changes {
+		println("protect: ", c.String())
+	}
+	// values and mask hold the channels that are
+	// being changed together.
+	n := solenoids.Len()
+	values, mask := mcp23017.NewPinSlice(n), mcp23017.NewPinSlice(n)
+	// stuck holds any channels that couldn't be turned off.
+	stuck := mcp23017.NewPinSlice(n)
 sequenceLoop:
-	for i, a := range seq {
-		if dt := time.Until(start.Add(a.When)); dt > 0 {
+	for i := 0; i < len(seq); {
+		when := seq[i].When
+		if dt := start.Add(when).Sub(clock.Now()); dt > 0 {
 			select {
 			case <-timer.After(dt):
 			case <-stop:
 				// We've been stopped; don't stop immediately but play out
 				// all the disable events so that we end up with a clean
 				// slate and we always activate solenoids for the correct time.
+				clearPins(values)
+				clearPins(mask)
 				for _, a := range seq[i:] {
-					if active == 0 {
-						break
-					}
 					if !a.On {
-						pins[a.Chan].Low()
-						active.Low(int(a.Chan))
+						mask.High(int(a.Chan))
 					}
 				}
+				if err := solenoids.SetPins(values, mask); err != nil {
+					addStuck(stuck, values, mask, n)
+				}
+				plan.Cancel()
 				break sequenceLoop
 			}
 		}
-		println("channel ", a.Chan, a.On)
-		pins[a.Chan].Set(a.On)
-		active.Set(int(a.Chan), a.On)
+		// Change all the channels with the same time at once
+		// so that the notes of a chord sound together.
+		clearPins(values)
+		clearPins(mask)
+		for ; i < len(seq) && seq[i].When == when; i++ {
+			a := seq[i]
+			println("channel ", a.Chan, a.On)
+			if mask.Get(int(a.Chan)) && values.Get(int(a.Chan)) != a.On {
+				// The channel changes twice at the same time (for
+				// example a zero-width pulse), so make the first
+				// change before the second one undoes it.
+				setPins(solenoids, values, mask, stuck, n)
+				clearPins(values)
+				clearPins(mask)
+			}
+			mask.High(int(a.Chan))
+			values.Set(int(a.Chan), a.On)
+		}
+		setPins(solenoids, values, mask, stuck, n)
+	}
+	// Try again to turn off any channels that failed so that
+	// a glitch doesn't leave a solenoid energised.
+	if anyHigh(stuck) {
+		clearPins(values)
+		if err := solenoids.SetPins(values, stuck); err != nil {
+			println("cannot turn off channels: ", err.Error())
+		}
 	}
 	if done != nil {
 		done <- struct{}{}
 	}
 }
 
-// buttonPoller continually polls the buttons and sends any changes
-// on pushed.
-func buttonPoller(doorButtons *buttonDevice, pushed chan<- mcp23017.Pins) {
+// setPins sets the solenoids for which mask is high to their
+// respective values in values, adding any channels
+// that couldn't be turned off to stuck.
+func setPins(solenoids *protect.Protector, values, mask, stuck mcp23017.PinSlice, n int) {
+	if err := solenoids.SetPins(values, mask); err != nil {
+		println("cannot set channels: ", err.Error())
+		addStuck(stuck, values, mask, n)
+	}
+}
+
+// clearPins sets all the given pins low.
+func clearPins(pins mcp23017.PinSlice) {
+	for i := range pins {
+		pins[i] = 0
+	}
+}
+
+// anyHigh reports whether any of the given pins are high.
+func anyHigh(pins mcp23017.PinSlice) bool {
+	for _, p := range pins {
+		if p != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// addStuck adds to stuck all of the first n channels that
+// were being turned off by a failed SetPins call with
+// the given values and mask.
+func addStuck(stuck, values, mask mcp23017.PinSlice, n int) {
+	for ch := 0; ch < n; ch++ {
+		if mask.Get(ch) && !values.Get(ch) {
+			stuck.High(ch)
+		}
+	}
+}
+
+// expanderChecker periodically checks whether any of the
+// given devices has reset, restoring their configuration
+// if so. A reset is most likely to be caused by a brown-out,
+// so it's recorded in the event log.
+func expanderChecker(devs mcp23017.Devices, events *eventRecorder) {
+	if len(devs) == 0 {
+		return
+	}
+	// failing holds whether the most recent check of
+	// each device failed, so that we only record the
+	// first of a run of errors.
+	failing := make([]bool, len(devs))
+	for {
+		time.Sleep(expanderCheckInterval)
+		for i, dev := range devs {
+			reset, err := dev.Check()
+			if err != nil && !failing[i] {
+				events.error(err.Error())
+			}
+			failing[i] = err != nil
+			if reset {
+				events.error("expander 0x" + strconv.FormatUint(uint64(dev.Addr()), 16) + " reset")
+			}
+		}
+	}
+}
+
+// pollIdleTime holds how long the button state must remain
+// unchanged before buttonPoller stops polling and waits
+// for an interrupt instead. It's comfortably longer than
+// the debounce time so that the debouncers have settled.
+const pollIdleTime = 200 * time.Millisecond
+
+// buttonPoller polls the buttons and sends any changes
+// on pushed. When the button device has an interrupt
+// available, it only polls continually for a while after
+// an interrupt, and every pollIdleTime otherwise.
+func buttonPoller(doorButtons *buttonDevice, pushed chan<- mcp23017.Pins, events *eventRecorder) {
 	println("in button poller")
-	var debouncers [numButtons]debounce.Debouncer
+	// Buttons with the default debounce configuration are all
+	// debounced together; others get their own debouncer.
+	var pinsDebouncer debounce.PinsDebouncer
+	debouncers := make([]*debounce.Debouncer, len(doorButtons.debounce))
+	for i := range debouncers {
+		if cfg := doorButtons.debounce[i]; cfg != (debounce.Config{}) {
+			debouncers[i] = &debounce.Debouncer{
+				Config: cfg,
+			}
+		}
+	}
 	var state mcp23017.Pins
+	lastChanged := time.Now()
+	idleTimer := timer.NewTimer()
+	// failing holds whether the most recent read failed,
+	// so that we only record the first of a run of errors.
+	failing := false
 	for {
-		var newState mcp23017.Pins
-		for i := range debouncers {
-			debouncer := &debouncers[i]
-			debouncer.Update(doorButtons.buttons().Get(i))
-			newState.Set(i, debouncer.State())
+		// Read all the buttons at once.
+		buttons, err := doorButtons.buttons()
+		if err != nil && !failing {
+			events.error("buttons: " + err.Error())
+		}
+		failing = err != nil
+		newState, _ := pinsDebouncer.Update(buttons)
+		for i, debouncer := range debouncers {
+			if debouncer != nil {
+				debouncer.Update(buttons.Get(i))
+				newState.Set(i, debouncer.State())
+			}
 		}
 		if newState != state {
 			state = newState
+			lastChanged = time.Now()
 			pushed <- state
 		}
-		// TODO can we avoid continuously polling the
-		// buttons (e.g. by setting up an interrupt) ?
+		if doorButtons.interrupt != nil && time.Since(lastChanged) > pollIdleTime {
+			// Nothing's happened for a while, so wait for an
+			// interrupt rather than hogging the bus. Reading the
+			// pins above has cleared any pending interrupt.
+			// Poll occasionally anyway, so that a missed interrupt
+			// only slows things down rather than losing the buttons.
+			select {
+			case <-doorButtons.interrupt:
+				lastChanged = time.Now()
+			case <-idleTimer.After(pollIdleTime):
+			}
+			continue
+		}
 		time.Sleep(time.Millisecond)
 	}
 }
 
-func readTunes() ([][]sequence.Action, error) {
-	tunes := make([][]sequence.Action, len(tunesData))
-	for i, data := range tunesData {
-		tunes[i] = sequence.ActionsForTune(numSolenoids, data, solenoidDuration)
+// newTuneStore returns the store used to hold tunes,
+// which are kept in the /tunes directory on fs.
+// If fs is nil or can't be used, tunes are held in memory only.
+func newTuneStore(fs tunestore.FS) tunestore.Store {
+	if fs == nil {
+		return tunestore.NewMemStore()
+	}
+	store, err := tunestore.NewFSStore(fs, "/tunes")
+	if err != nil {
+		println("cannot open tune store; using memory: ", err.Error())
+		return tunestore.NewMemStore()
+	}
+	return store
+}
+
+// loadLayout loads the board layout from layoutFile on fs.
+// It returns the default layout if fs is nil or the file doesn't
+// exist. If the file can't be read or holds an invalid layout,
+// or its button debounce intervals aren't less than pollIdleTime,
+// it returns the default layout along with an error describing
+// the problem. It returns a nil layout only if the default
+// layout is itself invalid.
+func loadLayout(fs tunestore.FS) (*layout.Layout, error) {
+	def, err := parseLayout(defaultLayout)
+	if err != nil {
+		return nil, errors.New("invalid default layout: " + err.Error())
+	}
+	if fs == nil {
+		return def, nil
+	}
+	data, err := fs.ReadFile(layoutFile)
+	if err == tunestore.ErrNotFound {
+		return def, nil
+	}
+	if err != nil {
+		return def, errors.New("cannot read " + layoutFile + ": " + err.Error())
+	}
+	l, err := parseLayout(string(data))
+	if err != nil {
+		return def, errors.New("invalid layout in " + layoutFile + ": " + err.Error())
+	}
+	return l, nil
+}
+
+// parseLayout is like layout.Parse except that it also checks
+// that the buttons settle within pollIdleTime, because
+// the button poller must keep polling until the debouncers
+// have settled or it could miss a change.
+func parseLayout(text string) (*layout.Layout, error) {
+	l, err := layout.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	for i, b := range l.Buttons {
+		if d := b.Debounce.MaxInterval(); d >= pollIdleTime {
+			return nil, errors.New("button " + strconv.Itoa(i) + " debounce interval " + d.String() + " is not less than " + pollIdleTime.String())
+		}
+	}
+	return l, nil
+}
+
+// readTunes reads all the tunes from the given store.
+// If the store is empty, it's first populated with the
+// built-in tunes.
+func readTunes(store tunestore.Store) ([]tune, error) {
+	names, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		for _, t := range builtinTunes {
+			if err := store.Add(t.name, t.data); err != nil {
+				return nil, err
+			}
+			names = append(names, t.name)
+		}
+	}
+	tunes := make([]tune, 0, len(names))
+	for _, name := range names {
+		data, err := store.Load(name)
+		if err != nil {
+			return nil, err
+		}
+		tunes = append(tunes, tune{
+			name:    name,
+			actions: sequence.ActionsForTune(numSolenoids, data, solenoidDuration),
+		})
 	}
 	return tunes, nil
 }
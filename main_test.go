@@ -0,0 +1,369 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/protect"
+	"github.com/rogpeppe/doorbell/sequence"
+	"github.com/rogpeppe/doorbell/timer"
+	"github.com/rogpeppe/doorbell/tunestore"
+)
+
+const ms = time.Millisecond
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func init() {
+	// The tests run with the default layout.
+	l, err := loadLayout(nil)
+	if err != nil {
+		panic(err)
+	}
+	numSolenoids = len(l.Solenoids)
+}
+
+var playTests = []struct {
+	testName string
+	seq      []sequence.Action
+	// failOff holds the number of calls that turn
+	// channels off that fail.
+	failOff     int
+	expectCalls []pinsCall
+}{{
+	testName: "chords",
+	seq: []sequence.Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 1, On: true, When: 0},
+		{Chan: 2, On: true, When: 0},
+		{Chan: 0, On: false, When: 200 * ms},
+		{Chan: 1, On: false, When: 200 * ms},
+		{Chan: 3, On: true, When: 200 * ms},
+		{Chan: 2, On: false, When: 300 * ms},
+		{Chan: 3, On: false, When: 900 * ms},
+	},
+	expectCalls: []pinsCall{
+		{When: 0, On: []int{0, 1, 2}},
+		{When: 200 * ms, On: []int{3}, Off: []int{0, 1}},
+		{When: 300 * ms, Off: []int{2}},
+		{When: 900 * ms, Off: []int{3}},
+	},
+}, {
+	testName: "zero-width",
+	seq:      sequence.Resize(sequence.Widths{})(strike(0, 4)),
+	// The pulse is as short as possible, but it still happens.
+	expectCalls: []pinsCall{
+		{When: 0, On: []int{4}},
+		{When: 0, Off: []int{4}},
+	},
+}, {
+	testName: "off-then-on",
+	seq: []sequence.Action{
+		{Chan: 5, On: true, When: 0},
+		{Chan: 5, On: false, When: 200 * ms},
+		{Chan: 5, On: true, When: 200 * ms},
+		{Chan: 5, On: false, When: 400 * ms},
+	},
+	// The channel is struck again rather than
+	// being left on.
+	expectCalls: []pinsCall{
+		{When: 0, On: []int{5}},
+		{When: 200 * ms, Off: []int{5}},
+		{When: 200 * ms, On: []int{5}},
+		{When: 400 * ms, Off: []int{5}},
+	},
+}, {
+	testName: "stuck",
+	seq: []sequence.Action{
+		{Chan: 1, On: true, When: 0},
+		{Chan: 2, On: true, When: 100 * ms},
+		{Chan: 1, On: false, When: 200 * ms},
+		{Chan: 2, On: false, When: 300 * ms},
+	},
+	failOff: 1,
+	// Channel 1 fails to turn off at first,
+	// so it's tried again at the end.
+	expectCalls: []pinsCall{
+		{When: 0, On: []int{1}},
+		{When: 100 * ms, On: []int{2}},
+		{When: 300 * ms, Off: []int{2}},
+		{When: 300 * ms, Off: []int{1}},
+	},
+}}
+
+func TestPlay(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range playTests {
+		c.Run(test.testName, func(c *qt.C) {
+			clock := timer.NewFakeClock(epoch)
+			pins := newFakeSolenoids(clock)
+			pins.failOff = test.failOff
+			solenoids := protect.New(pins, protect.Config{}, clock)
+			playTimer := timer.NewTimerWithClock(clock)
+			defer playTimer.Close()
+
+			done := make(chan struct{}, 1)
+			go Play(playTimer, solenoids, test.seq, nil, done)
+			for _, a := range test.seq {
+				advanceTo(clock, a.When)
+			}
+			<-done
+			c.Assert(pins.calls, qt.DeepEquals, test.expectCalls)
+			c.Assert(pins.on, qt.DeepEquals, make([]bool, numSolenoids))
+		})
+	}
+}
+
+func TestPlayStop(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	pins := newFakeSolenoids(clock)
+	solenoids := protect.New(pins, protect.Config{
+		MaxActive: 1,
+	}, clock)
+	playTimer := timer.NewTimerWithClock(clock)
+	defer playTimer.Close()
+
+	stop := make(chan struct{})
+	done := make(chan struct{}, 1)
+	// Channel 1 has to wait for channel 0 to finish.
+	seq := []sequence.Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 1, On: true, When: 100 * ms},
+		{Chan: 0, On: false, When: 200 * ms},
+		{Chan: 1, On: false, When: 300 * ms},
+	}
+	go Play(playTimer, solenoids, seq, stop, done)
+	advanceTo(clock, 50*ms)
+	clock.WaitSleepers(1)
+	stop <- struct{}{}
+	<-done
+	// Everything is turned off at once when it's stopped.
+	c.Assert(pins.calls, qt.DeepEquals, []pinsCall{
+		{When: 0, On: []int{0}},
+		{When: 50 * ms, Off: []int{0, 1}},
+	})
+	c.Assert(pins.on, qt.DeepEquals, make([]bool, numSolenoids))
+
+	// The rest of the stopped sequence doesn't
+	// delay anything that's played later.
+	plan := solenoids.Plan(strike(150*ms, 2), clock.Now())
+	c.Assert(plan.Changes, qt.HasLen, 0)
+}
+
+func TestPlayerDingDong(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	pins := newFakeSolenoids(clock)
+	solenoids := protect.New(pins, protect.Config{}, clock)
+	pushed := make(chan mcp23017.Pins)
+	go player(clock, solenoids, nil, nil, pushed, 0, rand.New(rand.NewSource(1)), newSettings(), nil, nil)
+
+	// The button is pressed, so the ding is played
+	// and then the long-press timer is started.
+	pushed <- 1 << 1
+	advanceTo(clock, 200*ms)
+	// It's released before the long-press time,
+	// so the dong is played.
+	pushed <- 0
+	// Wait for the long-press timer and the player.
+	clock.WaitSleepers(2)
+	advanceTo(clock, 400*ms)
+	// Wait for the player to finish playing.
+	pushed <- 0
+
+	ding, dong := chimeChan(buttonConfigs[1].ding), chimeChan(buttonConfigs[1].dong)
+	c.Assert(pins.calls, qt.DeepEquals, []pinsCall{
+		{When: 0, On: []int{ding}},
+		{When: 200 * ms, Off: []int{ding}},
+		{When: 200 * ms, On: []int{dong}},
+		{When: 400 * ms, Off: []int{dong}},
+	})
+}
+
+func TestPlayerIndoorDoubleClick(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	pins := newFakeSolenoids(clock)
+	solenoids := protect.New(pins, protect.Config{}, clock)
+	pushed := make(chan mcp23017.Pins)
+	cfg := newSettings()
+	const indoor = mcp23017.Pins(1 << 3)
+	go player(clock, solenoids, nil, nil, pushed, indoor, rand.New(rand.NewSource(1)), cfg, nil, nil)
+
+	// A double press of the indoor button turns on silent mode
+	// without ringing the bell.
+	pushed <- indoor
+	pushed <- 0
+	pushed <- indoor
+	pushed <- 0
+	// Wait for the player to handle the last release.
+	pushed <- 0
+	c.Assert(cfg.silentMode(), qt.IsTrue)
+
+	// A door button doesn't ring now.
+	pushed <- 1 << 1
+	pushed <- 0
+	pushed <- 0
+	c.Assert(pins.calls, qt.HasLen, 0)
+}
+
+var loadLayoutTests = []struct {
+	testName        string
+	file            string
+	expectSolenoids int
+	expectError     string
+}{{
+	testName:        "no-file",
+	expectSolenoids: 24,
+}, {
+	testName:        "valid",
+	file:            "solenoids 0x20:A0-A3\nbuttons 0x21:A0 pullup invert indoor\n",
+	expectSolenoids: 4,
+}, {
+	testName:        "invalid",
+	file:            "solenoids 0x20:A0-A3\nbuttons 0x20:A3\n",
+	expectSolenoids: 24,
+	expectError:     `invalid layout in /layout: statement 2: pin 0x20:A3 already used in statement 1`,
+}, {
+	testName:        "debounce-too-long",
+	file:            "solenoids 0x20:A0-A3\nbuttons 0x21:A0-A1 release=250ms\n",
+	expectSolenoids: 24,
+	expectError:     `invalid layout in /layout: button 0 debounce interval 250ms is not less than 200ms`,
+}}
+
+func TestLoadLayout(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range loadLayoutTests {
+		c.Run(test.testName, func(c *qt.C) {
+			fs := tunestore.DirFS(c.TempDir())
+			if test.file != "" {
+				c.Assert(fs.WriteFile(layoutFile, []byte(test.file)), qt.IsNil)
+			}
+			l, err := loadLayout(fs)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+			} else {
+				c.Assert(err, qt.IsNil)
+			}
+			// An invalid layout falls back to the default.
+			c.Assert(l, qt.Not(qt.IsNil))
+			c.Assert(l.Solenoids, qt.HasLen, test.expectSolenoids)
+		})
+	}
+}
+
+func TestDefaultLayout(t *testing.T) {
+	c := qt.New(t)
+	l, err := loadLayout(nil)
+	c.Assert(err, qt.IsNil)
+	c.Assert(l.Buttons, qt.HasLen, len(buttonConfigs))
+}
+
+// chimeChan returns the channel struck by the
+// given chime actions.
+func chimeChan(actions []sequence.Action) int {
+	return int(actions[0].Chan)
+}
+
+// advanceTo advances the clock to the given time from epoch,
+// waiting for a goroutine to be sleeping each time so that
+// the player sees every step.
+func advanceTo(clock *timer.FakeClock, when time.Duration) {
+	for {
+		d := epoch.Add(when).Sub(clock.Now())
+		if d <= 0 {
+			return
+		}
+		clock.WaitSleepers(1)
+		// The timer never sleeps for longer than
+		// half a second at a time.
+		if d > 500*ms {
+			d = 500 * ms
+		}
+		clock.Advance(d)
+	}
+}
+
+// strike returns the actions for a single strike
+// of the default duration.
+func strike(when time.Duration, ch uint8) []sequence.Action {
+	return []sequence.Action{
+		{Chan: ch, On: true, When: when},
+		{Chan: ch, On: false, When: when + solenoidDuration},
+	}
+}
+
+// pinsCall records a successful call to fakeSolenoids.SetPins.
+type pinsCall struct {
+	// When holds the time of the call from epoch.
+	When time.Duration
+	// On and Off hold the channels turned on and off.
+	On, Off []int
+}
+
+// fakeSolenoids implements protect.Pins by
+// recording the calls made to it.
+type fakeSolenoids struct {
+	clock timer.Clock
+
+	mu    sync.Mutex
+	on    []bool
+	calls []pinsCall
+	// failOff holds the number of future calls turning
+	// channels off that will fail.
+	failOff int
+}
+
+func newFakeSolenoids(clock timer.Clock) *fakeSolenoids {
+	return &fakeSolenoids{
+		clock: clock,
+		on:    make([]bool, numSolenoids),
+	}
+}
+
+func (p *fakeSolenoids) Len() int {
+	return len(p.on)
+}
+
+func (p *fakeSolenoids) Set(ch int, on bool) error {
+	values, mask := mcp23017.NewPinSlice(len(p.on)), mcp23017.NewPinSlice(len(p.on))
+	values.Set(ch, on)
+	mask.High(ch)
+	return p.SetPins(values, mask)
+}
+
+func (p *fakeSolenoids) SetPins(values, mask mcp23017.PinSlice) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	call := pinsCall{
+		When: p.clock.Now().Sub(epoch),
+	}
+	for ch := range p.on {
+		switch {
+		case !mask.Get(ch):
+		case values.Get(ch):
+			call.On = append(call.On, ch)
+		default:
+			call.Off = append(call.Off, ch)
+		}
+	}
+	if len(call.Off) > 0 && p.failOff > 0 {
+		p.failOff--
+		return errors.New("bus error")
+	}
+	for _, ch := range call.On {
+		p.on[ch] = true
+	}
+	for _, ch := range call.Off {
+		p.on[ch] = false
+	}
+	p.calls = append(p.calls, call)
+	return nil
+}
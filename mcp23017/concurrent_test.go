@@ -0,0 +1,132 @@
+package mcp23017
+
+import (
+	"sync"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// The tests in this file are most useful when run
+// with the race detector (go test -race).
+
+// concurrentIterations holds the number of times
+// each goroutine changes its pin.
+const concurrentIterations = 100
+
+func TestConcurrentPinSet(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	c.Assert(dev.SetModes([]PinMode{Output}), qt.IsNil)
+
+	// Each goroutine toggles its own pin, finishing
+	// with the even pins high and the odd pins low.
+	var wg sync.WaitGroup
+	for i := 0; i < PinCount; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			pin := dev.Pin(i)
+			for j := 0; j < concurrentIterations; j++ {
+				if err := pin.Set(j%2 == 0); err != nil {
+					t.Error(err)
+				}
+			}
+			if err := pin.Set(i%2 == 0); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	c.Assert(fdev.regPins(rGPIO), qt.Equals, Pins(0x5555))
+	pins, err := dev.GetPins()
+	c.Assert(err, qt.IsNil)
+	c.Assert(pins, qt.Equals, Pins(0x5555))
+}
+
+func TestConcurrentPinSetMode(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+
+	var wg sync.WaitGroup
+	for i := 0; i < PinCount; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mode := Output
+			if i%2 == 0 {
+				mode = Input | Pullup
+			}
+			if err := dev.Pin(i).SetMode(mode); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	modes := make([]PinMode, PinCount)
+	c.Assert(dev.GetModes(modes), qt.IsNil)
+	for i, mode := range modes {
+		if i%2 == 0 {
+			c.Check(mode, qt.Equals, Input|Pullup, qt.Commentf("pin %d", i))
+		} else {
+			c.Check(mode, qt.Equals, Output, qt.Commentf("pin %d", i))
+		}
+	}
+}
+
+func TestConcurrentDevices(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev0 := bus.addDevice(0x20)
+	fdev1 := bus.addDevice(0x21)
+	devs, err := NewI2CDevices(bus, 0x20, 0x21)
+	c.Assert(err, qt.IsNil)
+	c.Assert(devs.SetModes([]PinMode{Output}), qt.IsNil)
+
+	// Some goroutines set individual pins while others set
+	// all the pins in the low half of each port at once.
+	var wg sync.WaitGroup
+	for i := 0; i < 2*PinCount; i++ {
+		if i%8 < 4 {
+			continue
+		}
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < concurrentIterations; j++ {
+				if err := devs.Pin(i).Set(j%2 == 0); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < concurrentIterations; j++ {
+				if err := devs.SetPins(PinSlice{0x0f0f, 0x0f0f}, PinSlice{0x0f0f, 0x0f0f}); err != nil {
+					t.Error(err)
+				}
+				pins := make(PinSlice, 2)
+				if err := devs.GetPins(pins); err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	// The goroutines setting individual pins
+	// finish with them low.
+	c.Assert(fdev0.regPins(rGPIO), qt.Equals, Pins(0x0f0f))
+	c.Assert(fdev1.regPins(rGPIO), qt.Equals, Pins(0x0f0f))
+}
@@ -0,0 +1,137 @@
+package mcp23017
+
+// Config represents the contents of the IOCON register, which
+// holds the configuration of the chip as a whole. The constants
+// are named after the bits in the datasheet.
+type Config uint8
+
+const (
+	// IntPol makes the INT pins active-high.
+	// By default they are active-low.
+	IntPol Config = 1 << 1
+
+	// ODR makes the INT pins open-drain outputs,
+	// overriding IntPol.
+	ODR Config = 1 << 2
+
+	// HAEn enables the hardware address pins on the
+	// MCP23S17. It has no effect on the MCP23017.
+	HAEn Config = 1 << 3
+
+	// DisSlw disables slew rate control on the SDA pin.
+	DisSlw Config = 1 << 4
+
+	// SeqOp disables sequential operation, so the address
+	// pointer doesn't increment after each byte transferred.
+	SeqOp Config = 1 << 5
+
+	// Mirror connects the INTA and INTB pins internally.
+	Mirror Config = 1 << 6
+
+	// Bank puts the registers for each port in separate banks.
+	// By default, the port A and port B registers alternate.
+	Bank Config = 1 << 7
+
+	// configMask holds the implemented bits of IOCON.
+	configMask = Config(0b1111_1110)
+)
+
+// GetConfig reads the IOCON register.
+func (d *Device) GetConfig() (Config, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var buf [1]byte
+	if err := d.transfer(rIOCON, buf[:], false); err != nil {
+		return 0, err
+	}
+	return Config(buf[0]), nil
+}
+
+// SetConfig writes the IOCON register. Subsequent operations use
+// the register addresses implied by the Bank bit, and multi-byte
+// transfers are avoided when they can't be used (when Bank or
+// SeqOp is set).
+//
+// SetConfig works even if the chip has been configured with a
+// different Bank setting behind the device's back, for example
+// by another bus master. While it's doing so, interrupts on
+// port B might be briefly disabled.
+//
+// Note that ConfigureInterrupts also sets some of the bits
+// in IOCON.
+func (d *Device) SetConfig(cfg Config) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writeConfig(cfg)
+}
+
+// writeConfig implements SetConfig. It must be called with d.mu held.
+func (d *Device) writeConfig(cfg Config) (err error) {
+	cfg &= configMask
+	defer func(restoring bool) {
+		// Even if there's an error, the chip should have cfg,
+		// so that a mismatch will be found by restoreIfReset.
+		d.iocon = uint8(cfg)
+		d.restoring = restoring
+	}(d.restoring)
+	// There's no point in checking for a reset
+	// while we're in an unknown state.
+	d.restoring = true
+	var buf [1]byte
+	// If Bank is set, IOCON can only be found at its BANK=1
+	// address, which is GPINTENB when Bank is clear. Writing zero
+	// there clears Bank if it's set; otherwise it just disables
+	// interrupts on port B until we restore GPINTENB below.
+	d.iocon = uint8(Bank)
+	if err := d.transfer(rIOCON, buf[:], true); err != nil {
+		return err
+	}
+	// Now Bank must be clear, so IOCON is at its usual address.
+	d.iocon = 0
+	buf[0] = uint8(cfg)
+	if err := d.transfer(rIOCON, buf[:], true); err != nil {
+		return err
+	}
+	d.iocon = uint8(cfg)
+	buf[0] = uint8(d.intEnable >> 8)
+	return d.transfer(rGPINTEN|portB, buf[:], true)
+}
+
+// readConfig reads IOCON when the device is created. The chip
+// starts with Bank clear, but it's possible that it's been set
+// since then. In that case, the value read is OLATA, so if its top
+// bit is set, Bank must be set too and IOCON is read again from
+// its BANK=1 address. It must be called with d.mu held.
+func (d *Device) readConfig() error {
+	var buf [1]byte
+	if err := d.transfer(rIOCON, buf[:], false); err != nil {
+		return err
+	}
+	if Config(buf[0])&Bank != 0 {
+		d.iocon = uint8(Bank)
+		if err := d.transfer(rIOCON, buf[:], false); err != nil {
+			return err
+		}
+	}
+	d.iocon = buf[0]
+	return nil
+}
+
+// regAddr returns the address of the register r, which is
+// numbered as when Bank is clear, given the current configuration.
+func (d *Device) regAddr(r register) uint8 {
+	if Config(d.iocon)&Bank == 0 {
+		return uint8(r)
+	}
+	// When Bank is set, the port A registers are at 0x00-0x0A
+	// and the port B registers at 0x10-0x1A.
+	return uint8(r&portB)<<4 | uint8(r>>1)
+}
+
+// sequentialAB reports whether both the port A and port B
+// registers for a function can be transferred in a single
+// operation, which relies on the address pointer incrementing
+// and the port A and port B registers alternating.
+func (d *Device) sequentialAB() bool {
+	return Config(d.iocon)&(Bank|SeqOp) == 0
+}
@@ -0,0 +1,154 @@
+package mcp23017
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var addressingTests = []struct {
+	testName string
+	config   Config
+}{{
+	testName: "default",
+}, {
+	testName: "bank",
+	config:   Bank,
+}, {
+	testName: "seqop",
+	config:   SeqOp,
+}, {
+	testName: "bank-seqop",
+	config:   Bank | SeqOp | DisSlw,
+}}
+
+func TestAddressing(t *testing.T) {
+	c := qt.New(t)
+	// Find out what the registers should hold
+	// after configuring the device.
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	configure(c, dev)
+	want := fdev.Registers
+
+	for _, test := range addressingTests {
+		c.Run(test.testName, func(c *qt.C) {
+			bus := newBus(c)
+			fdev := bus.addDevice(0x20)
+			dev, err := NewI2C(bus, 0x20)
+			c.Assert(err, qt.IsNil)
+			c.Assert(dev.SetConfig(test.config), qt.IsNil)
+			cfg, err := dev.GetConfig()
+			c.Assert(err, qt.IsNil)
+			c.Assert(cfg, qt.Equals, test.config)
+			configure(c, dev)
+
+			wantRegs := want
+			wantRegs[rIOCON] |= uint8(test.config)
+			wantRegs[rIOCON|portB] |= uint8(test.config)
+			c.Assert(fdev.Registers, qt.Equals, wantRegs)
+
+			// Reading works too.
+			modes := make([]PinMode, PinCount)
+			c.Assert(dev.GetModes(modes), qt.IsNil)
+			c.Assert(modes[:9], qt.DeepEquals, []PinMode{
+				Output, Output, Output, Output, Output, Output, Output, Output,
+				Input | Pullup | Invert,
+			})
+			// Pin 8 changes because it's inverted and
+			// pin 9 goes low.
+			fdev.setInputs(0b10_0000_0000)
+			flags, captured, err := dev.Interrupts()
+			c.Assert(err, qt.IsNil)
+			c.Assert(flags, qt.Equals, Pins(0b11_0000_0000))
+			c.Assert(captured&0xff00, qt.Equals, Pins(0b1111_1101_0000_0000))
+			wantRegs = fdev.Registers
+
+			// The configuration, including the addressing,
+			// is restored after a reset.
+			fdev.reset()
+			reset, err := dev.Check()
+			c.Assert(err, qt.IsNil)
+			c.Assert(reset, qt.IsTrue)
+			c.Assert(fdev.Registers[:rINTF], qt.DeepEquals, wantRegs[:rINTF])
+			c.Assert(fdev.Registers[rOLAT], qt.Equals, wantRegs[rOLAT])
+			reset, err = dev.Check()
+			c.Assert(err, qt.IsNil)
+			c.Assert(reset, qt.IsFalse)
+		})
+	}
+}
+
+func TestSeqOpAvoidsMultiByteTransfers(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	fdev.Registers[rIOCON] = uint8(SeqOp)
+	fdev.Registers[rGPIO] = 0x12
+	fdev.Registers[rGPIO|portB] = 0x34
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+
+	fdev.Transactions, fdev.Bytes = 0, 0
+	pins, err := dev.GetPins()
+	c.Assert(err, qt.IsNil)
+	c.Assert(pins, qt.Equals, Pins(0x3412))
+	c.Assert(fdev.Transactions, qt.Equals, 2)
+	c.Assert(fdev.Bytes, qt.Equals, 2)
+
+	c.Assert(dev.SetPins(0x5678, 0xffff), qt.IsNil)
+	c.Assert(fdev.regPins(rGPIO), qt.Equals, Pins(0x5678))
+}
+
+func TestNewI2CWithBankSet(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	fdev.Registers[rIOCON] = uint8(Bank | Mirror)
+	// With Bank set, the BANK=0 IOCON address is OLATA.
+	// When its top bit is set, the setting can be detected.
+	fdev.Registers[rOLAT] = 0x80
+	fdev.Registers[rGPIO] = 0x12
+	fdev.Registers[rGPIO|portB] = 0x34
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	cfg, err := dev.GetConfig()
+	c.Assert(err, qt.IsNil)
+	c.Assert(cfg, qt.Equals, Bank|Mirror)
+	pins, err := dev.GetPins()
+	c.Assert(err, qt.IsNil)
+	c.Assert(pins, qt.Equals, Pins(0x3412))
+}
+
+func TestSetConfigWithUnknownBank(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	c.Assert(dev.ConfigureInterruptPin(15, Change), qt.IsNil)
+
+	// Another bus master sets Bank behind our back.
+	fdev.Registers[rIOCON] = uint8(Bank)
+	fdev.Registers[rIOCON|portB] = uint8(Bank)
+	c.Assert(dev.SetConfig(Mirror), qt.IsNil)
+	c.Assert(fdev.Registers[rIOCON], qt.Equals, uint8(Mirror))
+	c.Assert(fdev.Registers[rGPINTEN|portB], qt.Equals, uint8(0x80))
+
+	// Now it sets it again, but this time we don't know,
+	// and Check finds out and puts it back.
+	fdev.Registers[rIOCON] = uint8(Bank)
+	fdev.Registers[rIOCON|portB] = uint8(Bank)
+	reset, err := dev.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(reset, qt.IsTrue)
+	c.Assert(fdev.Registers[rIOCON], qt.Equals, uint8(Mirror))
+	c.Assert(fdev.Registers[rGPINTEN|portB], qt.Equals, uint8(0x80))
+
+	// It's also OK if Bank isn't set.
+	c.Assert(dev.SetConfig(IntPol), qt.IsNil)
+	c.Assert(fdev.Registers[rIOCON], qt.Equals, uint8(IntPol))
+	c.Assert(fdev.Registers[rGPINTEN|portB], qt.Equals, uint8(0x80))
+}
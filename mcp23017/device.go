@@ -8,6 +8,8 @@ package mcp23017
 
 import (
 	"errors"
+	"sync"
+	"time"
 )
 
 const (
@@ -44,6 +46,32 @@ const (
 // PinCount is the number of GPIO pins available on the chip.
 const PinCount = 16
 
+// PortPinCount is the number of GPIO pins in each port.
+const PortPinCount = 8
+
+// Port identifies one of the two 8-bit ports on the chip.
+// Pins 0 to 7 are in port A and pins 8 to 15 in port B.
+type Port uint8
+
+const (
+	PortA = Port(0)
+	PortB = Port(1)
+)
+
+// String returns "A" or "B".
+func (port Port) String() string {
+	if port == PortB {
+		return "B"
+	}
+	return "A"
+}
+
+// shift returns the position of the port's
+// pins within a Pins value.
+func (port Port) shift() uint {
+	return uint(port&1) * PortPinCount
+}
+
 // PinMode represents a possible I/O mode for a pin.
 // The zero value represents the default value
 // after the chip is reset (input).
@@ -86,7 +114,14 @@ type I2C interface {
 // on the given bus.
 // It returns ErrInvalidHWAddress if the address isn't possible for the device.
 //
-// By default all pins are configured as inputs.
+// By default all pins are configured as inputs. The pin modes
+// and interrupt settings are read from the chip, so changing the
+// mode of a pin leaves the other pins as they were.
+//
+// The chip's configuration (see Config) is read so that it's
+// addressed correctly. The Bank setting can't always be detected
+// reliably, so if the chip might have been configured with Bank set
+// since it was powered on, call SetConfig before using it.
 func NewI2C(bus I2C, address uint8) (*Device, error) {
 	if address&hwAddressMask != hwAddress {
 		return nil, ErrInvalidHWAddress
@@ -95,9 +130,15 @@ func NewI2C(bus I2C, address uint8) (*Device, error) {
 		bus:  bus,
 		addr: address,
 	}
+	if err := d.readConfig(); err != nil {
+		return nil, err
+	}
+	if err := d.readCache(); err != nil {
+		return nil, err
+	}
 	pins, err := d.GetPins()
 	if err != nil {
-		return nil, errors.New("cannot initialize mcp23017 device at " + hex(address) + ": " + err.Error())
+		return nil, err
 	}
 	d.pins = pins
 	return d, nil
@@ -109,9 +150,22 @@ func hex(x uint8) string {
 }
 
 // Device represents an MCP23017 device.
+//
+// Failed bus transactions are returned as *Error values. They can
+// be retried automatically (see SetRetryPolicy), in which case the
+// device is checked for having reset before each retry, as it might
+// after a brown-out, and if it has, the configuration that's been
+// set on it is restored (see Check).
+//
+// A Device is safe for concurrent use by multiple goroutines:
+// each operation on it, including those on its pins, happens
+// atomically. Note that devices don't coordinate with one another,
+// so if several devices on the same bus are used concurrently,
+// the bus must also be safe for concurrent use.
 type Device struct {
-	// TODO would it be good to have a mutex here so that independent goroutines
-	// could change pins without needing to do the locking themselves?
+	// mu guards pins and serializes access to
+	// the device's registers.
+	mu sync.Mutex
 
 	// bus holds the reference the I2C bus that the device lives on.
 	// It's an interface so that we can write tests for it.
@@ -121,10 +175,185 @@ type Device struct {
 	// This enables us to change individual pin values without
 	// doing a read followed by a write.
 	pins Pins
+
+	// The following fields cache the configuration that has
+	// been written to the device so that it can be restored
+	// if the device resets.
+	dir, pullup, invert       Pins
+	intEnable, defval, intcon Pins
+	iocon                     uint8
+
+	// retry holds the retry policy.
+	retry RetryPolicy
+	// restoring holds whether the configuration
+	// is currently being restored.
+	restoring bool
+}
+
+// Addr returns the I2C address of the device.
+func (d *Device) Addr() uint8 {
+	return d.addr
+}
+
+// SetRetryPolicy sets the policy used to retry failed
+// bus transactions.
+func (d *Device) SetRetryPolicy(p RetryPolicy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.retry = p
+}
+
+// Check checks whether the device has reset since its
+// configuration was last set, by comparing the configuration
+// registers with the values that have been written to them.
+// If it has, it restores the configuration, including the
+// output pin values, and returns true.
+//
+// A device that resets while it's idle won't otherwise be noticed
+// until one of its operations fails, so it can be worth
+// calling Check periodically.
+func (d *Device) Check() (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.restoreIfReset()
+}
+
+// restoreIfReset implements Check. It must be called with d.mu held.
+func (d *Device) restoreIfReset() (bool, error) {
+	d.restoring = true
+	defer func() {
+		d.restoring = false
+	}()
+	var buf [rGPPU + 2]byte
+	if err := d.readConfigRegisters(buf[:]); err != nil {
+		return false, err
+	}
+	if bufPins(buf[:], rIODIR) == d.dir &&
+		bufPins(buf[:], rIOPOL) == d.invert &&
+		bufPins(buf[:], rGPINTEN) == d.intEnable &&
+		bufPins(buf[:], rDEFVAL) == d.defval &&
+		bufPins(buf[:], rINTCON) == d.intcon &&
+		buf[rIOCON] == d.iocon &&
+		bufPins(buf[:], rGPPU) == d.pullup {
+		return false, nil
+	}
+	// Restore IOCON first because it affects the other registers,
+	// and the pin values before the direction so that outputs
+	// start with the right values.
+	if err := d.writeConfig(Config(d.iocon)); err != nil {
+		return true, err
+	}
+	for _, reg := range []struct {
+		r   register
+		val Pins
+	}{
+		{rGPIO, d.pins},
+		{rIOPOL, d.invert},
+		{rGPPU, d.pullup},
+		{rDEFVAL, d.defval},
+		{rINTCON, d.intcon},
+		{rGPINTEN, d.intEnable},
+		{rIODIR, d.dir},
+	} {
+		if err := d.writeRegisterAB(reg.r, reg.val); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// readCache reads the configuration registers into their caches,
+// so that changing the mode of a pin leaves the others as they
+// were on the chip, and a chip that was configured before the
+// device was created isn't mistaken for one that has reset.
+// It must be called with d.mu held.
+func (d *Device) readCache() error {
+	var buf [rGPPU + 2]byte
+	if err := d.readConfigRegisters(buf[:]); err != nil {
+		return err
+	}
+	d.dir = bufPins(buf[:], rIODIR)
+	d.invert = bufPins(buf[:], rIOPOL)
+	d.intEnable = bufPins(buf[:], rGPINTEN)
+	d.defval = bufPins(buf[:], rDEFVAL)
+	d.intcon = bufPins(buf[:], rINTCON)
+	d.pullup = bufPins(buf[:], rGPPU)
+	return nil
+}
+
+// readConfigRegisters reads the registers from IODIRA
+// to GPPUB into buf, indexed by register.
+// It must be called with d.mu held.
+func (d *Device) readConfigRegisters(buf []byte) error {
+	if d.sequentialAB() {
+		// Read them all in one go.
+		return d.transfer(rIODIR, buf, false)
+	}
+	for r := rIODIR; r <= rGPPU; r += 2 {
+		if r == rIOCON {
+			if err := d.transfer(rIOCON, buf[r:r+1], false); err != nil {
+				return err
+			}
+			buf[r|portB] = buf[r]
+			continue
+		}
+		pins, err := d.readRegisterAB(r)
+		if err != nil {
+			return err
+		}
+		buf[r], buf[r|portB] = uint8(pins), uint8(pins>>8)
+	}
+	return nil
+}
+
+// bufPins returns the values of the port A and port B registers
+// corresponding to r from buf, which holds the values of all
+// the registers from register 0.
+func bufPins(buf []byte, r register) Pins {
+	return Pins(buf[r]) | Pins(buf[r|portB])<<8
+}
+
+// transfer reads or writes the registers starting at r,
+// retrying according to the retry policy. Before each retry,
+// it checks whether the device needs its configuration restoring.
+// Transfers of more than one byte must only be used when they're
+// valid for the current configuration (see sequentialAB).
+// It must be called with d.mu held.
+func (d *Device) transfer(r register, buf []byte, write bool) error {
+	for attempt := 1; ; attempt++ {
+		// The address can change when the
+		// configuration is restored.
+		addr := d.regAddr(r)
+		var err error
+		if write {
+			err = d.bus.WriteRegister(d.addr, addr, buf)
+		} else {
+			err = d.bus.ReadRegister(d.addr, addr, buf)
+		}
+		if err == nil {
+			return nil
+		}
+		if attempt >= d.retry.Attempts {
+			return &Error{
+				Addr:     d.addr,
+				Register: uint8(r),
+				Write:    write,
+				Err:      err,
+			}
+		}
+		time.Sleep(d.retry.Delay)
+		if !d.restoring {
+			// Ignore any error because the retry
+			// will probably fail in the same way.
+			d.restoreIfReset()
+		}
+	}
 }
 
 // GetPins reads all 16 pins from ports A and B.
 func (d *Device) GetPins() (Pins, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	return d.readRegisterAB(rGPIO)
 }
 
@@ -138,15 +367,20 @@ func (d *Device) GetPins() (Pins, error) {
 //			d.Pin(i).Set(pins.Get(i))
 //		}
 //	}
+//
+// except that it uses a single bus transaction, which only
+// writes the registers for the ports with pins that change.
 func (d *Device) SetPins(pins, mask Pins) error {
 	if mask == 0 {
 		return nil
 	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	newPins := (d.pins &^ mask) | (pins & mask)
 	if newPins == d.pins {
 		return nil
 	}
-	err := d.writeRegisterAB(rGPIO, newPins)
+	err := d.writeChangedRegisters(rGPIO, newPins, newPins^d.pins)
 	if err != nil {
 		return err
 	}
@@ -154,6 +388,22 @@ func (d *Device) SetPins(pins, mask Pins) error {
 	return nil
 }
 
+// GetPort reads the values of the pins in the given port,
+// reading only that port's register.
+func (d *Device) GetPort(port Port) (uint8, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.readPortRegister(rGPIO, port)
+}
+
+// SetPort sets the pins in the given port for which mask
+// is high to their respective values in value,
+// writing only that port's register.
+func (d *Device) SetPort(port Port, value, mask uint8) error {
+	shift := port.shift()
+	return d.SetPins(Pins(value)<<shift, Pins(mask)<<shift)
+}
+
 // Pin returns a Pin representing the given pin number (from 0 to 15).
 // Pin numbers from 0 to 7 represent port A pins 0 to 7.
 // Pin numbers from 8 to 15 represent port B pins 0 to 7.
@@ -178,35 +428,21 @@ func (d *Device) Pin(pin int) Pin {
 // If len(modes) is greater than PinCount, the excess entries
 // will be ignored.
 func (d *Device) SetModes(modes []PinMode) error {
-	defaultMode := PinMode(0)
-	if len(modes) > 0 {
-		defaultMode = modes[len(modes)-1]
-	}
-	var dir, pullup, invert Pins
-	for i := 0; i < PinCount; i++ {
-		mode := defaultMode
-		if i < len(modes) {
-			mode = modes[i]
-		}
-		if mode&Direction == Input {
-			dir.High(i)
-		}
-		if mode&Pullup != 0 {
-			pullup.High(i)
-		}
-		if mode&Invert != 0 {
-			invert.High(i)
-		}
-	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dir, pullup, invert := modeBits(modes, PinCount)
 	if err := d.writeRegisterAB(rIODIR, dir); err != nil {
 		return err
 	}
+	d.dir = dir
 	if err := d.writeRegisterAB(rGPPU, pullup); err != nil {
 		return err
 	}
+	d.pullup = pullup
 	if err := d.writeRegisterAB(rIOPOL, invert); err != nil {
 		return err
 	}
+	d.invert = invert
 	return nil
 }
 
@@ -214,6 +450,8 @@ func (d *Device) SetModes(modes []PinMode) error {
 // It's OK if len(modes) is not PinCount - excess entries
 // will be left unset.
 func (d *Device) GetModes(modes []PinMode) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
 	dir, err := d.readRegisterAB(rIODIR)
 	if err != nil {
 		return err
@@ -230,35 +468,179 @@ func (d *Device) GetModes(modes []PinMode) error {
 		modes = modes[:PinCount]
 	}
 	for i := range modes {
-		mode := Output
-		if dir.Get(i) {
-			mode = Input
+		modes[i] = pinMode(dir, pullup, invert, i)
+	}
+	return nil
+}
+
+// SetPortModes is like SetModes except that it sets the modes of
+// the pins in the given port only, so modes[0] holds the mode
+// of the first pin in the port. It writes only
+// that port's registers.
+func (d *Device) SetPortModes(port Port, modes []PinMode) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	dir, pullup, invert := modeBits(modes, PortPinCount)
+	shift := port.shift()
+	if err := d.setPortBits(rIODIR, &d.dir, port, Pins(dir)<<shift, true); err != nil {
+		return err
+	}
+	if err := d.setPortBits(rGPPU, &d.pullup, port, Pins(pullup)<<shift, true); err != nil {
+		return err
+	}
+	return d.setPortBits(rIOPOL, &d.invert, port, Pins(invert)<<shift, true)
+}
+
+// GetPortModes is like GetModes except that it reads the modes of
+// the pins in the given port only, so modes[0] is set to the mode
+// of the first pin in the port. It reads only that port's registers.
+func (d *Device) GetPortModes(port Port, modes []PinMode) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(modes) > PortPinCount {
+		modes = modes[:PortPinCount]
+	}
+	return d.getPortModes(port, modes)
+}
+
+// getPortModes implements GetPortModes.
+// It must be called with d.mu held.
+func (d *Device) getPortModes(port Port, modes []PinMode) error {
+	dir, err := d.readPortRegister(rIODIR, port)
+	if err != nil {
+		return err
+	}
+	pullup, err := d.readPortRegister(rGPPU, port)
+	if err != nil {
+		return err
+	}
+	invert, err := d.readPortRegister(rIOPOL, port)
+	if err != nil {
+		return err
+	}
+	for i := range modes {
+		modes[i] = pinMode(Pins(dir), Pins(pullup), Pins(invert), i)
+	}
+	return nil
+}
+
+// setPortBits sets the bits of the given port in the cached register
+// value *cached to their values in val and writes the register for
+// that port, updating *cached if it succeeds. If force is false,
+// the register is only written if its value changes.
+// It must be called with d.mu held.
+func (d *Device) setPortBits(r register, cached *Pins, port Port, val Pins, force bool) error {
+	mask := Pins(0xff) << port.shift()
+	newVal := (*cached &^ mask) | (val & mask)
+	if !force && newVal == *cached {
+		return nil
+	}
+	buf := [1]byte{uint8(newVal >> port.shift())}
+	if err := d.transfer(r|register(port&1), buf[:], true); err != nil {
+		return err
+	}
+	*cached = newVal
+	return nil
+}
+
+// modeBits returns the bits of the IODIR, GPPU and IOPOL
+// registers that correspond to the modes of the first n pins,
+// with the last entry in modes replicated to all the pins
+// beyond its end (or PinMode(0) if it's empty).
+func modeBits(modes []PinMode, n int) (dir, pullup, invert Pins) {
+	defaultMode := PinMode(0)
+	if len(modes) > 0 {
+		defaultMode = modes[len(modes)-1]
+	}
+	for i := 0; i < n; i++ {
+		mode := defaultMode
+		if i < len(modes) {
+			mode = modes[i]
+		}
+		if mode&Direction == Input {
+			dir.High(i)
 		}
-		if pullup.Get(i) {
-			mode |= Pullup
+		if mode&Pullup != 0 {
+			pullup.High(i)
 		}
-		if invert.Get(i) {
-			mode |= Invert
+		if mode&Invert != 0 {
+			invert.High(i)
 		}
-		modes[i] = mode
 	}
-	return nil
+	return dir, pullup, invert
+}
+
+// pinMode returns the mode of pin i given the
+// values of the IODIR, GPPU and IOPOL registers.
+func pinMode(dir, pullup, invert Pins, i int) PinMode {
+	mode := Output
+	if dir.Get(i) {
+		mode = Input
+	}
+	if pullup.Get(i) {
+		mode |= Pullup
+	}
+	if invert.Get(i) {
+		mode |= Invert
+	}
+	return mode
+}
+
+// readPortRegister reads the register r for the given port only.
+// It must be called with d.mu held.
+func (d *Device) readPortRegister(r register, port Port) (uint8, error) {
+	var buf [1]byte
+	if err := d.transfer(r|register(port&1), buf[:], false); err != nil {
+		return 0, err
+	}
+	return buf[0], nil
 }
 
 func (d *Device) writeRegisterAB(r register, val Pins) error {
+	buf := [2]byte{uint8(val), uint8(val >> 8)}
+	if !d.sequentialAB() {
+		if err := d.transfer(r&^portB, buf[:1], true); err != nil {
+			return err
+		}
+		return d.transfer(r|portB, buf[1:], true)
+	}
 	// We rely on the auto-incrementing sequential write
 	// and the fact that registers alternate between A and B
 	// to write both ports in a single operation.
-	buf := [2]byte{uint8(val), uint8(val >> 8)}
-	return d.bus.WriteRegister(d.addr, uint8(r&^portB), buf[:])
+	return d.transfer(r&^portB, buf[:], true)
+}
+
+// writeChangedRegisters is like writeRegisterAB except that
+// it only writes the port A or port B register when some of
+// the corresponding bits in changed are set, so that setting
+// pins on a single port needs only one byte on the bus.
+func (d *Device) writeChangedRegisters(r register, val, changed Pins) error {
+	switch {
+	case changed&0xff00 == 0:
+		buf := [1]byte{uint8(val)}
+		return d.transfer(r&^portB, buf[:], true)
+	case changed&0x00ff == 0:
+		buf := [1]byte{uint8(val >> 8)}
+		return d.transfer(r|portB, buf[:], true)
+	}
+	return d.writeRegisterAB(r, val)
 }
 
 func (d *Device) readRegisterAB(r register) (Pins, error) {
+	var buf [2]byte
+	if !d.sequentialAB() {
+		if err := d.transfer(r&^portB, buf[:1], false); err != nil {
+			return Pins(0), err
+		}
+		if err := d.transfer(r|portB, buf[1:], false); err != nil {
+			return Pins(0), err
+		}
+		return Pins(buf[0]) | (Pins(buf[1]) << 8), nil
+	}
 	// We rely on the auto-incrementing sequential write
 	// and the fact that registers alternate between A and B
 	// to read both ports in a single operation.
-	var buf [2]byte
-	if err := d.bus.ReadRegister(d.addr, uint8(r), buf[:]); err != nil {
+	if err := d.transfer(r, buf[:], false); err != nil {
 		return Pins(0), err
 	}
 	return Pins(buf[0]) | (Pins(buf[1]) << 8), nil
@@ -274,10 +656,8 @@ type Pin struct {
 }
 
 // Set sets the pin to the given value.
+// Only the register for the pin's port is written.
 func (p Pin) Set(value bool) error {
-	// TODO currently this always writes both registers when
-	// technically it only needs to write one. We could potentially
-	// optimize that.
 	if value {
 		return p.dev.SetPins(^Pins(0), p.mask)
 	} else {
@@ -296,36 +676,60 @@ func (p Pin) Low() error {
 }
 
 // Get returns the current value of the given pin.
+// Only the register for the pin's port is read.
 func (p Pin) Get() (bool, error) {
-	// TODO this reads 2 registers when we could read just one.
-	pins, err := p.dev.GetPins()
+	port := p.port()
+	p.dev.mu.Lock()
+	defer p.dev.mu.Unlock()
+	val, err := p.dev.readPortRegister(rGPIO, port)
 	if err != nil {
 		return false, err
 	}
-	return pins&p.mask != 0, nil
+	return Pins(val)<<port.shift()&p.mask != 0, nil
 }
 
 // SetMode configures the pin to the given mode.
+// Only the configuration registers for the pin's port
+// that need to change are written.
 func (p Pin) SetMode(mode PinMode) error {
-	// We could use a more efficient single-register
-	// read/write pattern but setting pin modes isn't an
-	// operation that's likely to need to be efficient, so
-	// use less code and use Get/SetModes directly.
-	modes := make([]PinMode, PinCount)
-	if err := p.dev.GetModes(modes); err != nil {
-		return err
+	dir, pullup, invert := modeBits([]PinMode{mode}, 1)
+	port := p.port()
+	shift := uint(p.pin)
+	d := p.dev
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, reg := range []struct {
+		r      register
+		cached *Pins
+		val    Pins
+	}{
+		{rIODIR, &d.dir, dir},
+		{rGPPU, &d.pullup, pullup},
+		{rIOPOL, &d.invert, invert},
+	} {
+		val := (*reg.cached &^ p.mask) | (reg.val << shift)
+		if err := d.setPortBits(reg.r, reg.cached, port, val, false); err != nil {
+			return err
+		}
 	}
-	modes[p.pin] = mode
-	return p.dev.SetModes(modes)
+	return nil
 }
 
 // GetMode returns the mode of the pin.
+// Only the configuration registers for the pin's port are read.
 func (p Pin) GetMode() (PinMode, error) {
-	modes := make([]PinMode, PinCount)
-	if err := p.dev.GetModes(modes); err != nil {
+	var modes [PortPinCount]PinMode
+	p.dev.mu.Lock()
+	defer p.dev.mu.Unlock()
+	if err := p.dev.getPortModes(p.port(), modes[:]); err != nil {
 		return 0, err
 	}
-	return modes[p.pin], nil
+	return modes[p.pin%PortPinCount], nil
+}
+
+// port returns the port that the pin is in.
+func (p Pin) port() Port {
+	return Port(p.pin / PortPinCount)
 }
 
 // Pins represents a bitmask of pin values.
@@ -1,6 +1,7 @@
 package mcp23017
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
@@ -39,7 +40,8 @@ func TestSetPins(t *testing.T) {
 	c.Assert(pins, qt.Equals, Pins(0b01110000_0001_1010))
 
 	// The logic uses the cached value of the pins rather than
-	// reading it from the registers each time.
+	// reading it from the registers each time, and only
+	// the register for the port that changes is written.
 	fdev.Registers[rGPIO] = 0
 	fdev.Registers[rGPIO|portB] = 0
 
@@ -47,7 +49,20 @@ func TestSetPins(t *testing.T) {
 	c.Assert(err, qt.IsNil)
 	pins, err = dev.GetPins()
 	c.Assert(err, qt.IsNil)
-	c.Assert(pins, qt.Equals, Pins(0b01010000_00011010))
+	c.Assert(pins, qt.Equals, Pins(0b01010000_00000000))
+
+	fdev.Registers[rGPIO|portB] = 0
+	err = dev.SetPins(0b00000000_00000001, 0b00000000_00000001)
+	c.Assert(err, qt.IsNil)
+	pins, err = dev.GetPins()
+	c.Assert(err, qt.IsNil)
+	c.Assert(pins, qt.Equals, Pins(0b00000000_00011011))
+
+	// When nothing changes, nothing is written.
+	transactions := fdev.Transactions
+	err = dev.SetPins(0b00000000_00000001, 0b00000000_00000001)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.Transactions, qt.Equals, transactions)
 }
 
 func TestSetGetModes(t *testing.T) {
@@ -148,6 +163,189 @@ func TestPinMode(t *testing.T) {
 	c.Assert(fdev.Registers[rGPPU|portB], qt.Equals, uint8(0))
 }
 
+func TestPinModeWithConfiguredChip(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	// The chip has already been configured,
+	// for example before a restart.
+	fdev.Registers[rIODIR] = 0
+	fdev.Registers[rIODIR|portB] = 0b11110000
+	fdev.Registers[rGPPU|portB] = 0b10100000
+	fdev.Registers[rIOPOL|portB] = 0b11000000
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+
+	c.Assert(dev.Pin(0).SetMode(Output), qt.IsNil)
+	c.Assert(fdev.Registers[rIODIR], qt.Equals, uint8(0))
+	c.Assert(dev.Pin(1).SetMode(Input), qt.IsNil)
+	c.Assert(fdev.Registers[rIODIR], qt.Equals, uint8(0b10))
+
+	c.Assert(dev.Pin(12).SetMode(Input|Pullup|Invert), qt.IsNil)
+	c.Assert(fdev.Registers[rIODIR|portB], qt.Equals, uint8(0b11110000))
+	c.Assert(fdev.Registers[rGPPU|portB], qt.Equals, uint8(0b10110000))
+	c.Assert(fdev.Registers[rIOPOL|portB], qt.Equals, uint8(0b11010000))
+
+	err = dev.SetPortModes(PortA, []PinMode{Input})
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.Registers[rIODIR], qt.Equals, uint8(0xff))
+	c.Assert(fdev.Registers[rIODIR|portB], qt.Equals, uint8(0b11110000))
+}
+
+func TestPort(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	fdev.Registers[rGPIO] = 0b00001111
+	fdev.Registers[rGPIO|portB] = 0b11110000
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+
+	fdev.Transactions, fdev.Bytes = 0, 0
+	val, err := dev.GetPort(PortA)
+	c.Assert(err, qt.IsNil)
+	c.Assert(val, qt.Equals, uint8(0b00001111))
+	val, err = dev.GetPort(PortB)
+	c.Assert(err, qt.IsNil)
+	c.Assert(val, qt.Equals, uint8(0b11110000))
+	c.Assert(fdev.Transactions, qt.Equals, 2)
+	c.Assert(fdev.Bytes, qt.Equals, 2)
+
+	fdev.Transactions, fdev.Bytes = 0, 0
+	err = dev.SetPort(PortB, 0b00000011, 0b00001111)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.Registers[rGPIO], qt.Equals, uint8(0b00001111))
+	c.Assert(fdev.Registers[rGPIO|portB], qt.Equals, uint8(0b11110011))
+	c.Assert(fdev.Transactions, qt.Equals, 1)
+	c.Assert(fdev.Bytes, qt.Equals, 1)
+
+	pins, err := dev.GetPins()
+	c.Assert(err, qt.IsNil)
+	c.Assert(pins, qt.Equals, Pins(0b11110011_00001111))
+
+	c.Assert(PortA.String(), qt.Equals, "A")
+	c.Assert(PortB.String(), qt.Equals, "B")
+}
+
+func TestPortModes(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+
+	fdev.Transactions, fdev.Bytes = 0, 0
+	err = dev.SetPortModes(PortB, []PinMode{Output, Input | Invert, Input | Pullup})
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.Transactions, qt.Equals, 3)
+	c.Assert(fdev.Bytes, qt.Equals, 3)
+	c.Assert(fdev.Registers[rIODIR], qt.Equals, uint8(0b11111111))
+	c.Assert(fdev.Registers[rIODIR|portB], qt.Equals, uint8(0b11111110))
+	c.Assert(fdev.Registers[rIOPOL|portB], qt.Equals, uint8(0b00000010))
+	c.Assert(fdev.Registers[rGPPU|portB], qt.Equals, uint8(0b11111100))
+
+	modes := make([]PinMode, PortPinCount+1)
+	fdev.Transactions, fdev.Bytes = 0, 0
+	err = dev.GetPortModes(PortB, modes)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.Transactions, qt.Equals, 3)
+	c.Assert(fdev.Bytes, qt.Equals, 3)
+	c.Assert(modes, qt.DeepEquals, []PinMode{
+		Output,
+		Input | Invert,
+		Input | Pullup,
+		Input | Pullup,
+		Input | Pullup,
+		Input | Pullup,
+		Input | Pullup,
+		Input | Pullup,
+		// The excess entry is left unchanged.
+		0,
+	})
+
+	// The port's configuration is restored after a reset.
+	fdev.reset()
+	reset, err := dev.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(reset, qt.IsTrue)
+	c.Assert(fdev.Registers[rIODIR|portB], qt.Equals, uint8(0b11111110))
+	c.Assert(fdev.Registers[rIOPOL|portB], qt.Equals, uint8(0b00000010))
+	c.Assert(fdev.Registers[rGPPU|portB], qt.Equals, uint8(0b11111100))
+}
+
+var pinTransferTests = []struct {
+	testName           string
+	op                 func(p Pin) error
+	expectTransactions int
+	expectBytes        int
+}{{
+	testName: "get",
+	op: func(p Pin) error {
+		_, err := p.Get()
+		return err
+	},
+	expectTransactions: 1,
+	expectBytes:        1,
+}, {
+	testName: "set",
+	op: func(p Pin) error {
+		return p.Set(true)
+	},
+	expectTransactions: 1,
+	expectBytes:        1,
+}, {
+	testName: "set-unchanged",
+	op: func(p Pin) error {
+		return p.Set(false)
+	},
+}, {
+	testName: "get-mode",
+	op: func(p Pin) error {
+		_, err := p.GetMode()
+		return err
+	},
+	expectTransactions: 3,
+	expectBytes:        3,
+}, {
+	testName: "set-mode-output",
+	op: func(p Pin) error {
+		return p.SetMode(Output)
+	},
+	expectTransactions: 1,
+	expectBytes:        1,
+}, {
+	testName: "set-mode-pullup-invert",
+	op: func(p Pin) error {
+		return p.SetMode(Input | Pullup | Invert)
+	},
+	expectTransactions: 2,
+	expectBytes:        2,
+}, {
+	testName: "set-mode-unchanged",
+	op: func(p Pin) error {
+		return p.SetMode(Input)
+	},
+}}
+
+func TestPinTransfers(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range pinTransferTests {
+		c.Run(test.testName, func(c *qt.C) {
+			for _, pin := range []int{3, 12} {
+				bus := newBus(c)
+				fdev := bus.addDevice(0x20)
+				dev, err := NewI2C(bus, 0x20)
+				c.Assert(err, qt.IsNil)
+				fdev.Transactions, fdev.Bytes = 0, 0
+				err = test.op(dev.Pin(pin))
+				c.Assert(err, qt.IsNil)
+				c.Check(fdev.Transactions, qt.Equals, test.expectTransactions, qt.Commentf("pin %d", pin))
+				c.Check(fdev.Bytes, qt.Equals, test.expectBytes, qt.Commentf("pin %d", pin))
+			}
+		})
+	}
+}
+
 func TestPins(t *testing.T) {
 	c := qt.New(t)
 	var p Pins
@@ -168,6 +366,12 @@ func TestInitWithError(t *testing.T) {
 	fdev := bus.addDevice(0x20)
 	fdev.Err = fmt.Errorf("some error")
 	dev, err := NewI2C(bus, 0x20)
-	c.Assert(err, qt.ErrorMatches, `cannot initialize mcp23017 device at 0x20: some error`)
+	c.Assert(err, qt.ErrorMatches, `mcp23017 device at 0x20: cannot read register IOCON: some error`)
 	c.Assert(dev, qt.IsNil)
+	var devErr *Error
+	c.Assert(errors.As(err, &devErr), qt.IsTrue)
+	c.Assert(devErr.Addr, qt.Equals, uint8(0x20))
+	c.Assert(devErr.Register, qt.Equals, uint8(rIOCON))
+	c.Assert(devErr.Write, qt.IsFalse)
+	c.Assert(errors.Is(err, fdev.Err), qt.IsTrue)
 }
@@ -0,0 +1,79 @@
+package mcp23017
+
+import (
+	"time"
+)
+
+// Error is the error returned when a bus transaction
+// with a device fails.
+type Error struct {
+	// Addr holds the I2C address of the device.
+	Addr uint8
+	// Register holds the address of the first
+	// register that was being read or written.
+	// It's always the address used when Bank is
+	// clear, even when Bank is set (see Config).
+	Register uint8
+	// Write holds whether the registers were being written.
+	Write bool
+	// Err holds the error returned by the bus.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	op := "read"
+	if e.Write {
+		op = "write"
+	}
+	return "mcp23017 device at " + hex(e.Addr) + ": cannot " + op + " register " + registerName(register(e.Register)) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the error returned by the bus.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// registerNames holds the names of the port A registers,
+// indexed by register address divided by two.
+var registerNames = [registerCount / 2]string{
+	"IODIR",
+	"IPOL",
+	"GPINTEN",
+	"DEFVAL",
+	"INTCON",
+	"IOCON",
+	"GPPU",
+	"INTF",
+	"INTCAP",
+	"GPIO",
+	"OLAT",
+}
+
+// registerName returns the name of the given register
+// as used in the datasheet.
+func registerName(r register) string {
+	if int(r) >= registerCount {
+		return hex(uint8(r))
+	}
+	name := registerNames[r/2]
+	switch {
+	case r&^portB == rIOCON:
+		return name
+	case r&portB != 0:
+		return name + "B"
+	}
+	return name + "A"
+}
+
+// RetryPolicy determines how failed bus transactions are retried.
+// The zero value means that transactions are not retried.
+type RetryPolicy struct {
+	// Attempts holds the maximum number of times to
+	// try each transaction. Values less than 2 mean that
+	// transactions aren't retried.
+	Attempts int
+
+	// Delay holds the time to wait before each retry.
+	Delay time.Duration
+}
@@ -0,0 +1,179 @@
+package mcp23017
+
+import (
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var errBus = errors.New("bus error")
+
+func TestErrorString(t *testing.T) {
+	c := qt.New(t)
+	err := &Error{
+		Addr:     0x21,
+		Register: uint8(rGPPU | portB),
+		Write:    true,
+		Err:      errBus,
+	}
+	c.Assert(err, qt.ErrorMatches, `mcp23017 device at 0x21: cannot write register GPPUB: bus error`)
+	c.Assert(errors.Unwrap(err), qt.Equals, errBus)
+}
+
+func TestRegisterName(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(registerName(rIODIR), qt.Equals, "IODIRA")
+	c.Assert(registerName(rOLAT|portB), qt.Equals, "OLATB")
+	c.Assert(registerName(rIOCON), qt.Equals, "IOCON")
+	c.Assert(registerName(rIOCON|portB), qt.Equals, "IOCON")
+	c.Assert(registerName(registerCount), qt.Equals, "0x16")
+}
+
+func TestNoRetriesByDefault(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	fdev.Err = errBus
+	fdev.ErrCount = 1
+	err = dev.SetPins(0xffff, 0xffff)
+	c.Assert(err, qt.ErrorMatches, `mcp23017 device at 0x20: cannot write register GPIOA: bus error`)
+	// The cached pin values haven't changed.
+	c.Assert(dev.SetPins(0, 0xffff), qt.IsNil)
+	c.Assert(fdev.regPins(rGPIO), qt.Equals, Pins(0))
+}
+
+func TestRetry(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	dev.SetRetryPolicy(RetryPolicy{
+		Attempts: 3,
+	})
+	fdev.Err = errBus
+	fdev.ErrCount = 2
+	c.Assert(dev.SetPins(0x00ff, 0xffff), qt.IsNil)
+	c.Assert(fdev.regPins(rGPIO), qt.Equals, Pins(0x00ff))
+
+	fdev.Err = errBus
+	fdev.Transactions = 0
+	err = dev.SetPins(0xff00, 0xffff)
+	c.Assert(err, qt.ErrorMatches, `mcp23017 device at 0x20: cannot write register GPIOA: bus error`)
+	// There are three attempts at the write, and
+	// before the second and third, three attempts to
+	// read the registers to check for a reset.
+	c.Assert(fdev.Transactions, qt.Equals, 9)
+	fdev.Err = nil
+	c.Assert(fdev.regPins(rGPIO), qt.Equals, Pins(0x00ff))
+}
+
+func TestRetryRestoresAfterReset(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	dev.SetRetryPolicy(RetryPolicy{
+		Attempts: 2,
+	})
+	configure(c, dev)
+	want := fdev.Registers
+
+	fdev.Err = errBus
+	fdev.ErrCount = 1
+	fdev.ResetOnErr = true
+	c.Assert(dev.SetPins(0x0001, 0x00ff), qt.IsNil)
+	want[rGPIO] = 0x01
+	c.Assert(fdev.Registers, qt.Equals, want)
+}
+
+func TestCheck(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+
+	// A device that hasn't been configured
+	// looks the same after a reset.
+	reset, err := dev.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(reset, qt.IsFalse)
+
+	configure(c, dev)
+	want := fdev.Registers
+	reset, err = dev.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(reset, qt.IsFalse)
+
+	fdev.reset()
+	reset, err = dev.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(reset, qt.IsTrue)
+	c.Assert(fdev.Registers, qt.Equals, want)
+
+	reset, err = dev.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(reset, qt.IsFalse)
+
+	fdev.Err = errBus
+	_, err = dev.Check()
+	c.Assert(err, qt.ErrorMatches, `mcp23017 device at 0x20: cannot read register IODIRA: bus error`)
+}
+
+func TestCheckWithConfiguredChip(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	// The chip was configured before the device was
+	// created, for example before a restart.
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	configure(c, dev)
+	want := fdev.Registers
+
+	dev, err = NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	dev.SetRetryPolicy(RetryPolicy{
+		Attempts: 2,
+	})
+	reset, err := dev.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(reset, qt.IsFalse)
+	c.Assert(fdev.Registers[:rINTF], qt.DeepEquals, want[:rINTF])
+
+	// A retried transaction doesn't overwrite
+	// the configuration either.
+	fdev.Err = errBus
+	fdev.ErrCount = 1
+	c.Assert(dev.SetPins(0x0001, 0x00ff), qt.IsNil)
+	c.Assert(fdev.Registers[:rINTF], qt.DeepEquals, want[:rINTF])
+	c.Assert(fdev.Registers[rGPIO], qt.Equals, uint8(0x01))
+
+	// The configuration that was read is
+	// restored after a reset.
+	fdev.reset()
+	reset, err = dev.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(reset, qt.IsTrue)
+	c.Assert(fdev.Registers[:rINTF], qt.DeepEquals, want[:rINTF])
+	c.Assert(fdev.Registers[rGPIO], qt.Equals, uint8(0x01))
+}
+
+// configure configures dev with some non-default settings.
+func configure(c *qt.C, dev *Device) {
+	c.Assert(dev.SetModes([]PinMode{
+		Output, Output, Output, Output, Output, Output, Output, Output,
+		Input | Pullup | Invert,
+	}), qt.IsNil)
+	c.Assert(dev.ConfigureInterrupts(InterruptConfig{
+		Mirror: true,
+	}), qt.IsNil)
+	c.Assert(dev.ConfigureInterruptPin(8, Change), qt.IsNil)
+	c.Assert(dev.ConfigureInterruptPin(9, Falling), qt.IsNil)
+	c.Assert(dev.SetPins(0x0081, 0x00ff), qt.IsNil)
+}
@@ -1,12 +1,19 @@
 package mcp23017
 
 import (
+	"sync"
+
 	qt "github.com/frankban/quicktest"
 )
 
 // fakeBus implements the I2C interface in memory for testing.
+// Like a real bus, it only allows one transaction at a time,
+// so it's safe for concurrent use.
 type fakeBus struct {
-	c    *qt.C
+	c  *qt.C
+	mu sync.Mutex
+	// devs is guarded by mu, as are the registers of the
+	// devices when the bus is in use.
 	devs []*fakeDev
 }
 
@@ -29,6 +36,19 @@ type fakeDev struct {
 	// If Err is non-nil, it will be returned as the error from the
 	// I2C methods.
 	Err error
+	// If ErrCount is non-zero, Err is only returned from the next
+	// ErrCount transactions, simulating an intermittent fault.
+	ErrCount int
+	// If ResetOnErr is true, the device resets to its power-on
+	// state when Err is returned, as it might if the fault
+	// was caused by a brown-out.
+	ResetOnErr bool
+	// Transactions holds the number of transactions
+	// attempted on the device, including failed ones.
+	Transactions int
+	// Bytes holds the number of register bytes
+	// read or written by successful transactions.
+	Bytes int
 }
 
 // addDevice adds a new device at the given address.
@@ -36,53 +56,217 @@ func (bus *fakeBus) addDevice(addr uint8) *fakeDev {
 	dev := &fakeDev{
 		c:    bus.c,
 		addr: addr,
-		Registers: [registerCount]uint8{
-			// IODIRA and IODIRB are all ones by default.
-			rIODIR:         0xff,
-			rIODIR | portB: 0xff,
-		},
 	}
+	dev.reset()
 	bus.devs = append(bus.devs, dev)
 	return dev
 }
 
+// reset simulates the device resetting: all the
+// registers revert to their power-on values.
+func (d *fakeDev) reset() {
+	d.Registers = [registerCount]uint8{
+		// IODIRA and IODIRB are all ones by default.
+		rIODIR:         0xff,
+		rIODIR | portB: 0xff,
+	}
+}
+
+// fault returns the error that a transaction should fail
+// with, if any, and simulates any reset caused by it.
+func (d *fakeDev) fault() error {
+	d.Transactions++
+	if d.Err == nil {
+		return nil
+	}
+	err := d.Err
+	if d.ErrCount > 0 {
+		d.ErrCount--
+		if d.ErrCount == 0 {
+			d.Err = nil
+		}
+	}
+	if d.ResetOnErr {
+		d.reset()
+	}
+	return err
+}
+
 // ReadRegister implements I2C.ReadRegister.
 func (bus *fakeBus) ReadRegister(addr uint8, r uint8, buf []byte) error {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
 	return bus.findDev(addr).readRegister(r, buf)
 }
 
 // WriteRegister implements I2C.WriteRegister.
 func (bus *fakeBus) WriteRegister(addr uint8, r uint8, buf []byte) error {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
 	return bus.findDev(addr).writeRegister(r, buf)
 }
 
-func (d *fakeDev) readRegister(r uint8, buf []byte) error {
-	if d.Err != nil {
-		return d.Err
+func (d *fakeDev) readRegister(addr uint8, buf []byte) error {
+	if err := d.fault(); err != nil {
+		return err
 	}
-	d.assertRegisterRange(r, buf)
-	copy(buf, d.Registers[r:])
+	d.Bytes += len(buf)
+	d.transfer(addr, len(buf), func(i int, r register) {
+		if r == unimplemented {
+			buf[i] = 0
+			return
+		}
+		buf[i] = d.Registers[r]
+		// Reading the captured interrupt values or the GPIO
+		// values of a port clears any interrupt on that port.
+		switch r {
+		case rINTCAP, rGPIO:
+			d.clearInterrupt(0)
+		case rINTCAP | portB, rGPIO | portB:
+			d.clearInterrupt(portB)
+		}
+	})
 	return nil
 }
 
-func (d *fakeDev) writeRegister(r uint8, buf []byte) error {
-	if d.Err != nil {
-		return d.Err
+func (d *fakeDev) writeRegister(addr uint8, buf []byte) error {
+	if err := d.fault(); err != nil {
+		return err
 	}
-	d.assertRegisterRange(r, buf)
-	copy(d.Registers[r:], buf)
+	d.Bytes += len(buf)
+	d.transfer(addr, len(buf), func(i int, r register) {
+		if r == unimplemented {
+			return
+		}
+		d.Registers[r] = buf[i]
+		if r == rIOCON {
+			d.Registers[rIOCON|portB] = buf[i]
+		}
+	})
 	return nil
 }
 
-// assertRegisterRange asserts that reading or writing the given
-// register and subsequent registers is in range of the available registers.
-func (d *fakeDev) assertRegisterRange(r uint8, buf []byte) {
-	if int(r) >= len(d.Registers) {
-		d.c.Fatalf("register read/write [%#x, %#x] start out of range", r, int(r)+len(buf))
+// transfer calls f for each of the n registers in a transfer
+// starting at the given address, advancing the address as the
+// chip does. The addresses depend on the IOCON.BANK and
+// IOCON.SEQOP settings, which can change during the transfer.
+func (d *fakeDev) transfer(addr uint8, n int, f func(i int, r register)) {
+	for i := 0; i < n; i++ {
+		r, ok := d.register(addr)
+		if !ok {
+			d.c.Fatalf("register read/write at %#x (byte %d of %d) out of range", addr, i, n)
+		}
+		f(i, r)
+		if Config(d.Registers[rIOCON])&SeqOp == 0 {
+			addr++
+		}
+	}
+}
+
+// unimplemented is returned by fakeDev.register for
+// addresses with no register.
+// Like the real chip, they read as zero.
+const unimplemented = register(0xff)
+
+// register returns the register at the given address, numbered
+// as when IOCON.BANK is clear. Both IOCON addresses
+// return rIOCON. It reports whether the address is valid.
+func (d *fakeDev) register(addr uint8) (register, bool) {
+	if addr >= 0x20 {
+		return 0, false
+	}
+	r := register(addr)
+	if Config(d.Registers[rIOCON])&Bank != 0 {
+		if addr&0xf >= registerCount/2 {
+			return unimplemented, true
+		}
+		r = register(addr&0xf)<<1 | register(addr>>4)
+	}
+	if r >= registerCount {
+		// The addresses that are valid when Bank is
+		// set can still be used when it isn't.
+		return unimplemented, true
+	}
+	if r == rIOCON|portB {
+		r = rIOCON
+	}
+	return r, true
+}
+
+// setInputs simulates the external logic levels on the pins
+// of the device changing to the given values. Only pins configured
+// as inputs are affected. Any interrupts configured for the pins
+// are triggered as the hardware would.
+func (d *fakeDev) setInputs(levels Pins) {
+	inputs := d.regPins(rIODIR)
+	old := d.regPins(rGPIO)
+	// Note: the values seen in the GPIO register reflect
+	// the IOPOL setting.
+	gpio := (old &^ inputs) | ((levels ^ d.regPins(rIOPOL)) & inputs)
+	d.setRegPins(rGPIO, gpio)
+	d.triggerInterrupts(0, old)
+	d.triggerInterrupts(portB, old)
+}
+
+// triggerInterrupts checks whether any interrupts should be triggered
+// on the given port (0 or portB) given that the GPIO
+// values were previously old.
+func (d *fakeDev) triggerInterrupts(port register, old Pins) {
+	if d.Registers[rINTF|port] != 0 {
+		// An interrupt is already pending; further interrupts
+		// are not flagged until it is cleared.
+		return
 	}
-	if int(r)+len(buf) > len(d.Registers) {
-		d.c.Fatalf("register read/write [%#x, %#x] end out of range", r, int(r)+len(buf))
+	gpio := d.Registers[rGPIO|port]
+	intcon := d.Registers[rINTCON|port]
+	compareDefault := intcon & (gpio ^ d.Registers[rDEFVAL|port])
+	compareOld := ^intcon & (gpio ^ portByte(old, port))
+	fired := d.Registers[rGPINTEN|port] & (compareDefault | compareOld)
+	if fired == 0 {
+		return
+	}
+	d.Registers[rINTF|port] = fired
+	d.Registers[rINTCAP|port] = gpio
+}
+
+// clearInterrupt clears any interrupt on the given port (0 or portB).
+// Pins configured to compare against DEFVAL will trigger
+// again immediately if their condition still holds.
+func (d *fakeDev) clearInterrupt(port register) {
+	d.Registers[rINTF|port] = 0
+	d.triggerInterrupts(port, d.regPins(rGPIO))
+}
+
+// interruptLines returns whether the INTA and INTB output
+// pins are active, taking into account the IOCON.MIRROR setting.
+func (d *fakeDev) interruptLines() (intA, intB bool) {
+	intA = d.Registers[rINTF] != 0
+	intB = d.Registers[rINTF|portB] != 0
+	if Config(d.Registers[rIOCON])&Mirror != 0 {
+		intA = intA || intB
+		intB = intA
+	}
+	return intA, intB
+}
+
+// regPins returns the values of the port A and port B
+// registers corresponding to r.
+func (d *fakeDev) regPins(r register) Pins {
+	return Pins(d.Registers[r]) | Pins(d.Registers[r|portB])<<8
+}
+
+// setRegPins sets the values of the port A and port B
+// registers corresponding to r.
+func (d *fakeDev) setRegPins(r register, pins Pins) {
+	d.Registers[r] = uint8(pins)
+	d.Registers[r|portB] = uint8(pins >> 8)
+}
+
+func portByte(pins Pins, port register) uint8 {
+	if port == portB {
+		return uint8(pins >> 8)
 	}
+	return uint8(pins)
 }
 
 // findDev returns the device with the given address.
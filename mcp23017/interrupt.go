@@ -0,0 +1,179 @@
+package mcp23017
+
+import (
+	"errors"
+)
+
+// InterruptConfig holds the configuration of the INTA and INTB
+// interrupt output pins.
+type InterruptConfig struct {
+	// Mirror causes the INTA and INTB pins to be internally
+	// connected, so that an interrupt on either port causes
+	// both pins to be activated. Otherwise INTA reflects
+	// only port A and INTB reflects only port B.
+	Mirror bool
+
+	// OpenDrain configures the interrupt pins as open-drain
+	// outputs. When this is set, ActiveHigh is ignored and an
+	// external pull-up is required.
+	OpenDrain bool
+
+	// ActiveHigh configures the interrupt pins to be driven high when
+	// an interrupt occurs. By default they are active-low.
+	ActiveHigh bool
+}
+
+// ConfigureInterrupts configures the behavior of the
+// interrupt output pins. Note that there is only a single
+// configuration register for both ports, so this
+// affects both INTA and INTB.
+func (d *Device) ConfigureInterrupts(config InterruptConfig) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	cfg := Config(d.iocon) &^ (Mirror | ODR | IntPol)
+	if config.Mirror {
+		cfg |= Mirror
+	}
+	if config.OpenDrain {
+		cfg |= ODR
+	}
+	if config.ActiveHigh {
+		cfg |= IntPol
+	}
+	// The Bank setting isn't changing, so there's
+	// no need to use writeConfig.
+	buf := [1]byte{uint8(cfg)}
+	if err := d.transfer(rIOCON, buf[:], true); err != nil {
+		return err
+	}
+	d.iocon = uint8(cfg)
+	return nil
+}
+
+// InterruptMode represents the condition that causes
+// an interrupt to be triggered on a pin.
+type InterruptMode uint8
+
+const (
+	// Change triggers an interrupt whenever the pin
+	// value changes.
+	Change InterruptMode = iota
+
+	// Falling triggers an interrupt when the pin is low.
+	// Note that the chip compares the pin against a default
+	// value rather than detecting edges, so the interrupt will
+	// trigger again after being cleared if the pin remains low.
+	Falling
+
+	// Rising triggers an interrupt when the pin is high.
+	// As with Falling, the interrupt will trigger again
+	// after being cleared if the pin remains high.
+	Rising
+
+	// NoInterrupt disables interrupts on the pin.
+	// This is the default after the chip is reset.
+	NoInterrupt
+)
+
+// ConfigureInterruptPin configures the interrupt behavior of the
+// given pin (from 0 to 15). Note that the pin values are compared
+// after any inversion configured with the Invert mode.
+func (d *Device) ConfigureInterruptPin(pin int, mode InterruptMode) error {
+	if pin < 0 || pin >= PinCount {
+		panic("pin out of range")
+	}
+	var mask Pins
+	mask.High(pin)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	enable, err := d.readRegisterAB(rGPINTEN)
+	if err != nil {
+		return err
+	}
+	if mode == NoInterrupt {
+		if err := d.writeRegisterAB(rGPINTEN, enable&^mask); err != nil {
+			return err
+		}
+		d.intEnable = enable &^ mask
+		return nil
+	}
+	defval, err := d.readRegisterAB(rDEFVAL)
+	if err != nil {
+		return err
+	}
+	intcon, err := d.readRegisterAB(rINTCON)
+	if err != nil {
+		return err
+	}
+	switch mode {
+	case Change:
+		intcon.Low(pin)
+	case Falling:
+		// Interrupt when the pin differs from a high default value.
+		intcon.High(pin)
+		defval.High(pin)
+	case Rising:
+		// Interrupt when the pin differs from a low default value.
+		intcon.High(pin)
+		defval.Low(pin)
+	default:
+		panic("invalid interrupt mode")
+	}
+	if err := d.writeRegisterAB(rDEFVAL, defval); err != nil {
+		return err
+	}
+	d.defval = defval
+	if err := d.writeRegisterAB(rINTCON, intcon); err != nil {
+		return err
+	}
+	d.intcon = intcon
+	if err := d.writeRegisterAB(rGPINTEN, enable|mask); err != nil {
+		return err
+	}
+	d.intEnable = enable | mask
+	return nil
+}
+
+// ErrNoInterrupt is returned by InterruptInfo when no
+// interrupt is pending.
+var ErrNoInterrupt = errors.New("no interrupt has occurred")
+
+// Interrupts returns the pins that caused pending interrupts
+// (at most one for each port), and the values of all the pins
+// captured at the time the interrupts occurred.
+// Reading the captured values clears the interrupts.
+//
+// Captured values for a port are only meaningful when one
+// of the flags for that port is set.
+func (d *Device) Interrupts() (flags, captured Pins, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	flags, err = d.readRegisterAB(rINTF)
+	if err != nil {
+		return 0, 0, err
+	}
+	captured, err = d.readRegisterAB(rINTCAP)
+	if err != nil {
+		return 0, 0, err
+	}
+	return flags, captured, nil
+}
+
+// InterruptInfo returns information on the pin that caused
+// the last interrupt and its value at the time of the interrupt.
+// If interrupts are pending on both ports, the port A pin is
+// returned. If there was no interrupt, it returns ErrNoInterrupt.
+//
+// Calling InterruptInfo clears any pending interrupts.
+func (d *Device) InterruptInfo() (pin int, value bool, err error) {
+	flags, captured, err := d.Interrupts()
+	if err != nil {
+		return 0, false, err
+	}
+	if flags == 0 {
+		return 0, false, ErrNoInterrupt
+	}
+	for pin = 0; !flags.Get(pin); pin++ {
+	}
+	return pin, captured.Get(pin), nil
+}
@@ -0,0 +1,176 @@
+package mcp23017
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestConfigureInterrupts(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	// Set a bit that isn't related to interrupts
+	// to check that it's preserved.
+	fdev.Registers[rIOCON] = 0b0010_0000
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+
+	err = dev.ConfigureInterrupts(InterruptConfig{
+		Mirror:     true,
+		ActiveHigh: true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.Registers[rIOCON], qt.Equals, uint8(0b0110_0010))
+
+	err = dev.ConfigureInterrupts(InterruptConfig{
+		OpenDrain: true,
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.Registers[rIOCON], qt.Equals, uint8(0b0010_0100))
+}
+
+func TestConfigureInterruptPin(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+
+	err = dev.ConfigureInterruptPin(1, Change)
+	c.Assert(err, qt.IsNil)
+	err = dev.ConfigureInterruptPin(2, Falling)
+	c.Assert(err, qt.IsNil)
+	err = dev.ConfigureInterruptPin(9, Rising)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.regPins(rGPINTEN), qt.Equals, Pins(0b00000010_00000110))
+	c.Assert(fdev.regPins(rINTCON), qt.Equals, Pins(0b00000010_00000100))
+	c.Assert(fdev.regPins(rDEFVAL), qt.Equals, Pins(0b00000000_00000100))
+
+	// Changing the mode of a pin should leave the others alone.
+	err = dev.ConfigureInterruptPin(2, Rising)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.regPins(rGPINTEN), qt.Equals, Pins(0b00000010_00000110))
+	c.Assert(fdev.regPins(rINTCON), qt.Equals, Pins(0b00000010_00000100))
+	c.Assert(fdev.regPins(rDEFVAL), qt.Equals, Pins(0))
+
+	err = dev.ConfigureInterruptPin(1, NoInterrupt)
+	c.Assert(err, qt.IsNil)
+	c.Assert(fdev.regPins(rGPINTEN), qt.Equals, Pins(0b00000010_00000100))
+}
+
+func TestInterruptOnChange(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	err = dev.ConfigureInterruptPin(3, Change)
+	c.Assert(err, qt.IsNil)
+
+	_, _, err = dev.InterruptInfo()
+	c.Assert(err, qt.Equals, ErrNoInterrupt)
+
+	// A change on a pin without interrupts enabled does nothing.
+	fdev.setInputs(0b0001)
+	intA, intB := fdev.interruptLines()
+	c.Assert(intA, qt.IsFalse)
+	c.Assert(intB, qt.IsFalse)
+
+	fdev.setInputs(0b1001)
+	intA, intB = fdev.interruptLines()
+	c.Assert(intA, qt.IsTrue)
+	c.Assert(intB, qt.IsFalse)
+
+	// Further changes aren't recorded until the interrupt is cleared,
+	// so the captured value reflects the state when the interrupt
+	// happened.
+	fdev.setInputs(0b0000)
+
+	pin, value, err := dev.InterruptInfo()
+	c.Assert(err, qt.IsNil)
+	c.Assert(pin, qt.Equals, 3)
+	c.Assert(value, qt.IsTrue)
+
+	intA, _ = fdev.interruptLines()
+	c.Assert(intA, qt.IsFalse)
+	_, _, err = dev.InterruptInfo()
+	c.Assert(err, qt.Equals, ErrNoInterrupt)
+}
+
+func TestInterruptOnLevel(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	fdev.setInputs(0xffff)
+	err = dev.ConfigureInterruptPin(10, Falling)
+	c.Assert(err, qt.IsNil)
+
+	// The pin is high, so there is no interrupt.
+	fdev.setInputs(0xffff)
+	_, _, err = dev.InterruptInfo()
+	c.Assert(err, qt.Equals, ErrNoInterrupt)
+
+	fdev.setInputs(0xffff &^ (1 << 10))
+	intA, intB := fdev.interruptLines()
+	c.Assert(intA, qt.IsFalse)
+	c.Assert(intB, qt.IsTrue)
+
+	flags, captured, err := dev.Interrupts()
+	c.Assert(err, qt.IsNil)
+	c.Assert(flags, qt.Equals, Pins(1<<10))
+	// Only the port B captured values are meaningful because
+	// there was no interrupt on port A.
+	c.Assert(captured>>8, qt.Equals, Pins(0xff&^(1<<2)))
+
+	// The pin is still low, so the interrupt fires again
+	// immediately after being cleared.
+	_, intB = fdev.interruptLines()
+	c.Assert(intB, qt.IsTrue)
+
+	fdev.setInputs(0xffff)
+	pin, value, err := dev.InterruptInfo()
+	c.Assert(err, qt.IsNil)
+	c.Assert(pin, qt.Equals, 10)
+	c.Assert(value, qt.IsFalse)
+	_, intB = fdev.interruptLines()
+	c.Assert(intB, qt.IsFalse)
+}
+
+func TestInterruptMirrorAndInvert(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c)
+	fdev := bus.addDevice(0x20)
+	dev, err := NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	err = dev.SetModes([]PinMode{Input | Pullup | Invert})
+	c.Assert(err, qt.IsNil)
+	err = dev.ConfigureInterrupts(InterruptConfig{
+		Mirror: true,
+	})
+	c.Assert(err, qt.IsNil)
+	err = dev.ConfigureInterruptPin(8, Rising)
+	c.Assert(err, qt.IsNil)
+
+	// The pins are pulled up, so a button press pulls the
+	// pin low, which is inverted to a high value, triggering
+	// the interrupt.
+	fdev.setInputs(0xffff)
+	_, _, err = dev.InterruptInfo()
+	c.Assert(err, qt.Equals, ErrNoInterrupt)
+	fdev.setInputs(0xffff &^ (1 << 8))
+	intA, intB := fdev.interruptLines()
+	c.Assert(intA, qt.IsTrue)
+	c.Assert(intB, qt.IsTrue)
+
+	// Reading the GPIO values also clears the interrupt.
+	fdev.setInputs(0xffff)
+	pins, err := dev.GetPins()
+	c.Assert(err, qt.IsNil)
+	c.Assert(pins, qt.Equals, Pins(0))
+	intA, intB = fdev.interruptLines()
+	c.Assert(intA, qt.IsFalse)
+	c.Assert(intB, qt.IsFalse)
+}
@@ -7,6 +7,10 @@ var All = PinSlice{0xffff}
 // contiguous set of devices. Earlier entries in the slice have
 // lower-numbered pins, so index 0 holds pins 0-7, index 1 holds
 // pins 8-15, etc.
+//
+// Like Device, Devices is safe for concurrent use, but an
+// operation that spans several devices, such as SetPins,
+// is only atomic with respect to each individual device.
 type Devices []*Device
 
 // NewI2CDevices returns a Devices slice holding the Device values
@@ -25,6 +29,13 @@ func NewI2CDevices(bus I2C, addrs ...uint8) (Devices, error) {
 	return devs, nil
 }
 
+// SetRetryPolicy sets the retry policy of all the devices.
+func (devs Devices) SetRetryPolicy(p RetryPolicy) {
+	for _, dev := range devs {
+		dev.SetRetryPolicy(p)
+	}
+}
+
 // SetModes sets the pin modes of all the pins on all the devices in devs.
 // If there are less entries in modes than there are pins, the
 // last entry is replicated to all of them (or PinMode(0) if modes
@@ -98,6 +109,10 @@ func (devs Devices) GetPins(pins PinSlice) error {
 //			d.Pin(i).Set(pins.Get(i))
 //		}
 //	}
+//
+// except that it uses at most one bus transaction for each
+// device (see Device.SetPins), so pins on the same device
+// change at the same time.
 func (devs Devices) SetPins(pins, mask PinSlice) error {
 	defaultPins := pins.extra()
 	defaultMask := mask.extra()
@@ -122,6 +137,12 @@ func (devs Devices) SetPins(pins, mask PinSlice) error {
 // slice is extended to all other pins beyond the end of the slice.
 type PinSlice []Pins
 
+// NewPinSlice returns a PinSlice with room for
+// at least n pins, all low.
+func NewPinSlice(n int) PinSlice {
+	return make(PinSlice, (n+PinCount-1)/PinCount)
+}
+
 // Get returns the value for the given pin. If the length of pins is too short
 // for the pin number, the value of the highest available pin is returned.
 // That is, the highest numbered pin in the last element of pins
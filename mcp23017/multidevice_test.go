@@ -164,3 +164,11 @@ func TestPinSlice(t *testing.T) {
 	pins.High(16)
 	c.Assert(pins.Get(16), qt.Equals, true)
 }
+
+func TestNewPinSlice(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(NewPinSlice(0), qt.HasLen, 0)
+	c.Assert(NewPinSlice(1), qt.DeepEquals, PinSlice{0})
+	c.Assert(NewPinSlice(16), qt.DeepEquals, PinSlice{0})
+	c.Assert(NewPinSlice(17), qt.DeepEquals, PinSlice{0, 0})
+}
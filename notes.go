@@ -31,30 +31,32 @@ const (
 	noteB2
 )
 
-var dingActions = []sequence.Action{{
-	Chan: noteC2,
-	On:   true,
-	When: 0,
-}, {
-	Chan: noteC2,
-	On:   false,
-	When: solenoidDuration,
-}}
+var dingActions = chime(noteC2)
 
-var dongActions = []sequence.Action{{
-	Chan: noteG2,
-	On:   true,
-	When: 0,
-}, {
-	Chan: noteG2,
-	On:   false,
-	When: solenoidDuration,
-}}
+var dongActions = chime(noteG2)
 
-var tunesData = [][]byte{
-	sequenceTune,
-	happyBirthdayTune,
-	rippleTune,
+// chime returns the actions to strike a single note.
+func chime(note uint8) []sequence.Action {
+	return []sequence.Action{{
+		Chan: note,
+		On:   true,
+		When: 0,
+	}, {
+		Chan: note,
+		On:   false,
+		When: solenoidDuration,
+	}}
+}
+
+// builtinTunes holds the tunes that are added to the tune
+// store when it's empty.
+var builtinTunes = []struct {
+	name string
+	data []byte
+}{
+	{"sequence", sequenceTune},
+	{"happy-birthday", happyBirthdayTune},
+	{"ripple", rippleTune},
 }
 
 // Note: channel, delay before activation (milliseconds, two bytes)
@@ -0,0 +1,527 @@
+// Package protect protects the doorbell solenoids (and the power
+// supply that drives them) from being driven too hard.
+//
+// A Protector sits between the code that plays sequences and the
+// output pins. Before a sequence is played, Plan adjusts it so that
+// it stays within the configured limits, delaying or dropping
+// activations as needed and taking into account the sequences that
+// have already been planned. If a planned sequence is stopped
+// early, it should be cancelled so that it doesn't hold up
+// other sequences. As a last line of defence, Set refuses
+// to turn on more solenoids at once than the configured maximum.
+package protect
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/sequence"
+	"github.com/rogpeppe/doorbell/timer"
+)
+
+// Pins represents a set of output pins, one per channel.
+type Pins interface {
+	// Len returns the number of channels.
+	Len() int
+	// Set sets the output for the given channel.
+	Set(ch int, on bool) error
+	// SetPins sets the outputs of all the channels for which
+	// mask is high to their values in values, as nearly
+	// simultaneously as possible.
+	SetPins(values, mask mcp23017.PinSlice) error
+}
+
+// Config holds the protection limits.
+// A zero value in any field means that there's no limit.
+type Config struct {
+	// MinOffTime holds the minimum time that a solenoid must
+	// be off between activations, giving it time to recover.
+	MinOffTime time.Duration
+
+	// MaxActive holds the maximum number of solenoids that
+	// can be on at the same time.
+	MaxActive int
+
+	// MaxDuty holds the maximum proportion of time (between 0
+	// and 1) that a solenoid can be on over any period of
+	// DutyWindow. It's ignored if DutyWindow is zero.
+	MaxDuty    float64
+	DutyWindow time.Duration
+
+	// MaxDelay holds the maximum time that Plan will delay an
+	// activation for to keep within the other limits. Activations
+	// that would need to be delayed for longer are dropped.
+	// When it's zero, activations are only dropped if no
+	// suitable time can be found for them (see TooManyTries).
+	MaxDelay time.Duration
+}
+
+// Reason represents the limit that caused a change.
+type Reason uint8
+
+const (
+	// MinOffTime means that the solenoid had not
+	// been off for long enough.
+	MinOffTime Reason = iota
+
+	// MaxActive means that too many solenoids were on.
+	MaxActive
+
+	// MaxDuty means that the solenoid had been
+	// on for too much of the duty window.
+	MaxDuty
+
+	// TooManyTries means that no suitable time could be found
+	// for the activation even though it could be delayed further.
+	TooManyTries
+)
+
+var reasonNames = []string{
+	MinOffTime:   "min-off-time",
+	MaxActive:    "max-active",
+	MaxDuty:      "max-duty",
+	TooManyTries: "too-many-tries",
+}
+
+// String implements fmt.Stringer.
+func (r Reason) String() string {
+	if int(r) < len(reasonNames) {
+		return reasonNames[r]
+	}
+	return "Reason(" + strconv.Itoa(int(r)) + ")"
+}
+
+// Change describes an activation that Plan changed.
+type Change struct {
+	// Chan holds the channel of the activation.
+	Chan uint8
+	// When holds the original time of the activation
+	// from the start of the sequence.
+	When time.Duration
+	// Dropped holds whether the activation was dropped.
+	// If it's false, the activation was delayed by Delay.
+	Dropped bool
+	Delay   time.Duration
+	// Reason holds the limit that caused the change. When an
+	// activation has been delayed, it's the last limit that
+	// caused a delay.
+	Reason Reason
+}
+
+// String returns a one-line description of the change.
+func (c Change) String() string {
+	s := "channel " + strconv.Itoa(int(c.Chan)) + " at " + c.When.String()
+	if c.Dropped {
+		s += " dropped"
+	} else {
+		s += " delayed by " + c.Delay.String()
+	}
+	return s + " (" + c.Reason.String() + ")"
+}
+
+// ErrTooManyActive is returned by Protector.Set when turning
+// on a channel would exceed Config.MaxActive.
+var ErrTooManyActive = errors.New("too many solenoids active")
+
+// maxTries holds the maximum number of times that Plan will try
+// to find a time for an activation before dropping it.
+const maxTries = 20
+
+// Protector enforces protection limits on a set of pins.
+// It's safe to use concurrently.
+type Protector struct {
+	config Config
+	pins   Pins
+	clock  timer.Clock
+
+	mu sync.Mutex
+	// planned holds the activations that have been planned
+	// and that might still affect future plans, in no
+	// particular order.
+	planned []interval
+	// planID holds the id of the most recent plan.
+	planID uint32
+	// on holds the channels that are currently on.
+	on []bool
+	// active holds the number of channels that are on.
+	active int
+	// mask is used by SetPins to avoid allocation.
+	mask mcp23017.PinSlice
+}
+
+// interval holds the time that a channel is on for.
+type interval struct {
+	ch         uint8
+	start, end time.Time
+	// plan holds the id of the plan that the
+	// activation is part of.
+	plan uint32
+}
+
+// Plan holds a sequence that's been adjusted by Protector.Plan.
+type Plan struct {
+	// Actions holds the adjusted sequence.
+	Actions []sequence.Action
+	// Changes describes the changes that were made.
+	Changes []Change
+
+	p  *Protector
+	id uint32
+}
+
+// Cancel tells the Protector that any activations in the plan
+// that start after the current time won't happen. It doesn't
+// affect any other plans.
+func (plan *Plan) Cancel() {
+	p := plan.p
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.clock.Now()
+	j := 0
+	for _, iv := range p.planned {
+		if iv.plan != plan.id || !iv.start.After(now) {
+			p.planned[j] = iv
+			j++
+		}
+	}
+	p.planned = p.planned[:j]
+}
+
+// New returns a Protector that enforces the given limits on
+// the given pins, using the given clock to find out the current
+// time. If clock is nil, the real clock will be used.
+func New(pins Pins, cfg Config, clock timer.Clock) *Protector {
+	if clock == nil {
+		clock = timer.RealClock
+	}
+	return &Protector{
+		config: cfg,
+		pins:   pins,
+		clock:  clock,
+		on:     make([]bool, pins.Len()),
+		mask:   mcp23017.NewPinSlice(pins.Len()),
+	}
+}
+
+// Len returns the number of channels.
+func (p *Protector) Len() int {
+	return len(p.on)
+}
+
+// Set sets the output for the given channel. If turning on the
+// channel would exceed the maximum number of active solenoids,
+// it leaves it off and returns ErrTooManyActive.
+func (p *Protector) Set(ch int, on bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ch < 0 || ch >= len(p.on) {
+		return errors.New("channel " + strconv.Itoa(ch) + " out of range")
+	}
+	if on && !p.on[ch] && p.config.MaxActive > 0 && p.active >= p.config.MaxActive {
+		return ErrTooManyActive
+	}
+	if err := p.pins.Set(ch, on); err != nil {
+		return err
+	}
+	if on != p.on[ch] {
+		p.on[ch] = on
+		if on {
+			p.active++
+		} else {
+			p.active--
+		}
+	}
+	return nil
+}
+
+// SetPins sets the outputs of all the channels for which mask
+// is high to their respective values in values. Like Set, it
+// won't turn on more channels than the maximum: the channels
+// being turned off are taken into account first, then
+// channels are turned on in channel order until the
+// maximum is reached. If any channels are left off
+// because of that, it returns ErrTooManyActive after
+// setting the others.
+func (p *Protector) SetPins(values, mask mcp23017.PinSlice) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	active := p.active
+	for ch, on := range p.on {
+		if on && mask.Get(ch) && !values.Get(ch) {
+			active--
+		}
+	}
+	var tooMany bool
+	for ch, on := range p.on {
+		set := mask.Get(ch)
+		if set && values.Get(ch) && !on {
+			if p.config.MaxActive > 0 && active >= p.config.MaxActive {
+				set = false
+				tooMany = true
+			} else {
+				active++
+			}
+		}
+		p.mask.Set(ch, set)
+	}
+	if err := p.pins.SetPins(values, p.mask); err != nil {
+		return err
+	}
+	for ch := range p.on {
+		if p.mask.Get(ch) {
+			p.on[ch] = values.Get(ch)
+		}
+	}
+	p.active = active
+	if tooMany {
+		return ErrTooManyActive
+	}
+	return nil
+}
+
+// Plan returns a plan holding a version of the given actions,
+// which must be sorted in time order and are to be played starting
+// at the given time, that keeps within the protection limits,
+// and a description of any changes that it's made.
+//
+// The activations in the plan are taken into account by later
+// calls to Plan, so its actions should be played in full, or the
+// plan should be cancelled if they're stopped early.
+func (p *Protector) Plan(actions []sequence.Action, start time.Time) *Plan {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prune(start)
+	p.planID++
+	var result []sequence.Action
+	var changes []Change
+	for _, pulse := range pulses(actions) {
+		if pulse.offOnly {
+			// Turning a channel off is always OK.
+			result = append(result, pulse.off)
+			continue
+		}
+		a, d := pulse.on, pulse.off
+		delay, reason, ok := p.findTime(a.Chan, start.Add(a.When), d.When-a.When)
+		if !ok {
+			changes = append(changes, Change{
+				Chan:    a.Chan,
+				When:    a.When,
+				Dropped: true,
+				Reason:  reason,
+			})
+			continue
+		}
+		if delay > 0 {
+			changes = append(changes, Change{
+				Chan:   a.Chan,
+				When:   a.When,
+				Delay:  delay,
+				Reason: reason,
+			})
+			a.When += delay
+			d.When += delay
+		}
+		p.planned = append(p.planned, interval{
+			ch:    a.Chan,
+			start: start.Add(a.When),
+			end:   start.Add(d.When),
+			plan:  p.planID,
+		})
+		result = append(result, a, d)
+	}
+	sort.Stable(actionsByTime(result))
+	return &Plan{
+		Actions: result,
+		Changes: changes,
+		p:       p,
+		id:      p.planID,
+	}
+}
+
+// prune removes planned activations that can't
+// affect activations after the given time.
+func (p *Protector) prune(t time.Time) {
+	keep := p.config.MinOffTime
+	if p.config.DutyWindow > keep {
+		keep = p.config.DutyWindow
+	}
+	j := 0
+	for _, iv := range p.planned {
+		if iv.end.Add(keep).After(t) {
+			p.planned[j] = iv
+			j++
+		}
+	}
+	p.planned = p.planned[:j]
+}
+
+// findTime finds the earliest time not before t and not more than
+// MaxDelay (if it's set) after it that a channel can be turned on for the
+// given duration. It returns the delay and the reason for it.
+// If there's no such time, it returns false.
+func (p *Protector) findTime(ch uint8, t time.Time, dur time.Duration) (time.Duration, Reason, bool) {
+	var reason Reason
+	t0 := t
+	for i := 0; i < maxTries; i++ {
+		next, r, ok := p.check(ch, t, dur)
+		if ok {
+			return t.Sub(t0), reason, true
+		}
+		reason = r
+		if p.config.MaxDelay > 0 && next.Sub(t0) > p.config.MaxDelay {
+			return 0, reason, false
+		}
+		t = next
+	}
+	return 0, TooManyTries, false
+}
+
+// check checks whether the channel can be turned on at time t for
+// the given duration. If not, it returns the reason and the next
+// time that's worth trying.
+func (p *Protector) check(ch uint8, t time.Time, dur time.Duration) (time.Time, Reason, bool) {
+	end := t.Add(dur)
+	cfg := &p.config
+	// Check that the channel has time to recover before
+	// and after the new activation.
+	for _, iv := range p.planned {
+		if iv.ch == ch && t.Before(iv.end.Add(cfg.MinOffTime)) && iv.start.Before(end.Add(cfg.MinOffTime)) {
+			return iv.end.Add(cfg.MinOffTime), MinOffTime, false
+		}
+	}
+	if cfg.MaxActive > 0 {
+		// The number of active channels only increases at the
+		// start of an activation, so it's enough to check
+		// at t and the start of each overlapping activation.
+		var earliestEnd time.Time
+		for _, iv := range p.planned {
+			if !overlaps(iv, t, end) {
+				continue
+			}
+			if earliestEnd.IsZero() || iv.end.Before(earliestEnd) {
+				earliestEnd = iv.end
+			}
+			at := t
+			if iv.start.After(t) {
+				at = iv.start
+			}
+			if p.activeAt(at) >= cfg.MaxActive {
+				return earliestEnd, MaxActive, false
+			}
+		}
+	}
+	if cfg.DutyWindow > 0 {
+		limit := time.Duration(cfg.MaxDuty * float64(cfg.DutyWindow))
+		windowStart := end.Add(-cfg.DutyWindow)
+		onTime := dur
+		// first holds the start of the earliest on time in the window.
+		var first time.Time
+		for _, iv := range p.planned {
+			if iv.ch == ch && overlaps(iv, windowStart, end) {
+				start := maxTime(iv.start, windowStart)
+				onTime += minTime(iv.end, end).Sub(start)
+				if first.IsZero() || start.Before(first) {
+					first = start
+				}
+			}
+		}
+		if onTime > limit {
+			// On time only starts leaving the window when the
+			// start of the window passes first, so try the time
+			// when the start of the window is the excess beyond that.
+			excess := onTime - limit
+			return first.Add(cfg.DutyWindow - dur + excess), MaxDuty, false
+		}
+	}
+	return time.Time{}, 0, true
+}
+
+// activeAt returns the number of planned activations
+// that are on at time t.
+func (p *Protector) activeAt(t time.Time) int {
+	n := 0
+	for _, iv := range p.planned {
+		if !t.Before(iv.start) && t.Before(iv.end) {
+			n++
+		}
+	}
+	return n
+}
+
+// overlaps reports whether iv overlaps the period [t0, t1).
+func overlaps(iv interval, t0, t1 time.Time) bool {
+	return iv.start.Before(t1) && t0.Before(iv.end)
+}
+
+func minTime(t0, t1 time.Time) time.Time {
+	if t0.Before(t1) {
+		return t0
+	}
+	return t1
+}
+
+func maxTime(t0, t1 time.Time) time.Time {
+	if t0.After(t1) {
+		return t0
+	}
+	return t1
+}
+
+// pulse holds an action that turns a channel on and
+// the action that next turns it off again.
+type pulse struct {
+	on, off sequence.Action
+	// offOnly holds whether the pulse holds only an
+	// action turning off a channel that isn't on.
+	offOnly bool
+}
+
+// pulses returns the pulses in actions in time order. An action
+// that turns a channel off when it's not on is returned as a pulse
+// with offOnly set. An action that turns a channel on and is never
+// followed by one turning it off is given an off action at the
+// end of the sequence, so a solenoid is never left on.
+func pulses(actions []sequence.Action) []pulse {
+	var ps []pulse
+	on := make(map[uint8]int)
+	for _, a := range actions {
+		j, isOn := on[a.Chan]
+		switch {
+		case a.On && isOn:
+			// Already on; ignore.
+		case a.On:
+			ps = append(ps, pulse{on: a})
+			on[a.Chan] = len(ps) - 1
+		case isOn:
+			ps[j].off = a
+			delete(on, a.Chan)
+		default:
+			ps = append(ps, pulse{off: a, offOnly: true})
+		}
+	}
+	for _, j := range on {
+		ps[j].off = sequence.Action{
+			Chan: ps[j].on.Chan,
+			When: actions[len(actions)-1].When,
+		}
+	}
+	return ps
+}
+
+type actionsByTime []sequence.Action
+
+func (s actionsByTime) Less(i, j int) bool {
+	return s[i].When < s[j].When
+}
+
+func (s actionsByTime) Len() int {
+	return len(s)
+}
+
+func (s actionsByTime) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,421 @@
+package protect
+
+import (
+	"errors"
+	"sort"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/sequence"
+	"github.com/rogpeppe/doorbell/timer"
+)
+
+const ms = time.Millisecond
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var planTests = []struct {
+	testName      string
+	config        Config
+	actions       []sequence.Action
+	expect        []sequence.Action
+	expectChanges []string
+}{{
+	testName: "no-limits",
+	actions: strikes(200*ms,
+		0, 0,
+		10*ms, 0,
+		20*ms, 1,
+	),
+	// Note: the second strike on channel 0 is ignored
+	// because the channel is already on.
+	expect: []sequence.Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 1, On: true, When: 20 * ms},
+		{Chan: 0, On: false, When: 200 * ms},
+		{Chan: 0, On: false, When: 210 * ms},
+		{Chan: 1, On: false, When: 220 * ms},
+	},
+}, {
+	testName: "min-off-time-delay",
+	config: Config{
+		MinOffTime: 100 * ms,
+		MaxDelay:   100 * ms,
+	},
+	actions: strikes(50*ms,
+		0, 0,
+		100*ms, 0,
+		200*ms, 0,
+	),
+	expect: strikes(50*ms,
+		0, 0,
+		150*ms, 0,
+		300*ms, 0,
+	),
+	expectChanges: []string{
+		"channel 0 at 100ms delayed by 50ms (min-off-time)",
+		"channel 0 at 200ms delayed by 100ms (min-off-time)",
+	},
+}, {
+	testName: "min-off-time-drop",
+	config: Config{
+		MinOffTime: 100 * ms,
+		MaxDelay:   20 * ms,
+	},
+	actions: strikes(50*ms,
+		0, 0,
+		60*ms, 1,
+		100*ms, 0,
+		170*ms, 0,
+	),
+	expect: strikes(50*ms,
+		0, 0,
+		60*ms, 1,
+		170*ms, 0,
+	),
+	expectChanges: []string{
+		"channel 0 at 100ms dropped (min-off-time)",
+	},
+}, {
+	testName: "max-active",
+	config: Config{
+		MaxActive: 2,
+		MaxDelay:  100 * ms,
+	},
+	actions: strikes(100*ms,
+		0, 0,
+		10*ms, 1,
+		20*ms, 2,
+		30*ms, 3,
+		40*ms, 4,
+	),
+	// Channel 2 waits for channel 0 to finish, channel 3
+	// waits for channel 1, and channel 4 would have to
+	// wait too long.
+	expect: strikes(100*ms,
+		0, 0,
+		10*ms, 1,
+		100*ms, 2,
+		110*ms, 3,
+	),
+	expectChanges: []string{
+		"channel 2 at 20ms delayed by 80ms (max-active)",
+		"channel 3 at 30ms delayed by 80ms (max-active)",
+		"channel 4 at 40ms dropped (max-active)",
+	},
+}, {
+	testName: "max-active-checks-later-activations",
+	config: Config{
+		MaxActive: 2,
+		MaxDelay:  5 * ms,
+	},
+	actions: []sequence.Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 1, On: true, When: 50 * ms},
+		{Chan: 2, On: true, When: 60 * ms},
+		{Chan: 1, On: false, When: 70 * ms},
+		{Chan: 2, On: false, When: 80 * ms},
+		{Chan: 0, On: false, When: 100 * ms},
+	},
+	expect: []sequence.Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 1, On: true, When: 50 * ms},
+		{Chan: 1, On: false, When: 70 * ms},
+		{Chan: 0, On: false, When: 100 * ms},
+	},
+	expectChanges: []string{
+		"channel 2 at 60ms dropped (max-active)",
+	},
+}, {
+	testName: "no-max-delay",
+	config: Config{
+		MaxActive: 1,
+	},
+	actions: strikes(100*ms,
+		0, 0,
+		10*ms, 1,
+		20*ms, 2,
+	),
+	// With no maximum delay, nothing is dropped
+	// however long it has to wait.
+	expect: strikes(100*ms,
+		0, 0,
+		100*ms, 1,
+		200*ms, 2,
+	),
+	expectChanges: []string{
+		"channel 1 at 10ms delayed by 90ms (max-active)",
+		"channel 2 at 20ms delayed by 180ms (max-active)",
+	},
+}, {
+	testName: "duty-cycle",
+	config: Config{
+		DutyWindow: time.Second,
+		MaxDuty:    0.3,
+		MaxDelay:   time.Second,
+	},
+	actions: strikes(100*ms,
+		0, 0,
+		100*ms, 0,
+		200*ms, 0,
+		300*ms, 0,
+		300*ms, 1,
+	),
+	// The fourth strike on channel 0 would make it active for
+	// 400ms out of the 1s before it finishes, so it's delayed
+	// until the first strike is out of the window.
+	// Channel 1 is unaffected.
+	expect: strikes(100*ms,
+		0, 0,
+		100*ms, 0,
+		200*ms, 0,
+		300*ms, 1,
+		1000*ms, 0,
+	),
+	expectChanges: []string{
+		"channel 0 at 300ms delayed by 700ms (max-duty)",
+	},
+}, {
+	testName: "unmatched-actions",
+	config: Config{
+		MaxActive: 1,
+		MaxDelay:  5 * ms,
+	},
+	actions: []sequence.Action{
+		{Chan: 1, On: false, When: 0},
+		{Chan: 0, On: true, When: 10 * ms},
+		{Chan: 2, On: true, When: 20 * ms},
+		{Chan: 3, On: false, When: 30 * ms},
+	},
+	// Channel 0 is turned off at the end and channel 2
+	// is dropped because channel 0 is still on.
+	expect: []sequence.Action{
+		{Chan: 1, On: false, When: 0},
+		{Chan: 0, On: true, When: 10 * ms},
+		{Chan: 0, On: false, When: 30 * ms},
+		{Chan: 3, On: false, When: 30 * ms},
+	},
+	expectChanges: []string{
+		"channel 2 at 20ms dropped (max-active)",
+	},
+}}
+
+func TestPlan(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range planTests {
+		c.Run(test.testName, func(c *qt.C) {
+			p := New(newFakePins(8), test.config, timer.NewFakeClock(epoch))
+			plan := p.Plan(test.actions, epoch)
+			c.Assert(plan.Actions, qt.DeepEquals, test.expect)
+			c.Assert(changeStrings(plan.Changes), qt.DeepEquals, test.expectChanges)
+		})
+	}
+}
+
+func TestPlanRemembersEarlierPlans(t *testing.T) {
+	c := qt.New(t)
+	p := New(newFakePins(8), Config{
+		MinOffTime: 100 * ms,
+		MaxDelay:   time.Second,
+	}, timer.NewFakeClock(epoch))
+	plan := p.Plan(strikes(200*ms, 0, 0), epoch)
+	c.Assert(plan.Changes, qt.HasLen, 0)
+
+	// Channel 0 has to wait for the previous plan.
+	plan = p.Plan(strikes(200*ms, 0, 0, 0, 1), epoch.Add(50*ms))
+	c.Assert(plan.Actions, qt.DeepEquals, strikes(200*ms, 0, 1, 250*ms, 0))
+	c.Assert(changeStrings(plan.Changes), qt.DeepEquals, []string{
+		"channel 0 at 0s delayed by 250ms (min-off-time)",
+	})
+
+	// Once enough time has passed, the previous
+	// plans no longer have any effect.
+	plan = p.Plan(strikes(200*ms, 0, 0), epoch.Add(time.Second))
+	c.Assert(plan.Actions, qt.DeepEquals, strikes(200*ms, 0, 0))
+	c.Assert(plan.Changes, qt.HasLen, 0)
+	c.Assert(p.planned, qt.HasLen, 1)
+}
+
+func TestCancel(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	p := New(newFakePins(8), Config{
+		MaxActive: 1,
+		MaxDelay:  time.Second,
+	}, clock)
+	plan := p.Plan(strikes(100*ms, 0, 0, 200*ms, 1), epoch)
+	clock.Advance(150 * ms)
+	// The sequence is stopped after the first strike.
+	plan.Cancel()
+	plan = p.Plan(strikes(100*ms, 0, 2), clock.Now())
+	c.Assert(plan.Actions, qt.DeepEquals, strikes(100*ms, 0, 2))
+	c.Assert(plan.Changes, qt.HasLen, 0)
+}
+
+func TestCancelOnlyAffectsItsPlan(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	p := New(newFakePins(8), Config{
+		MaxActive: 1,
+		MaxDelay:  time.Second,
+	}, clock)
+	// Two sequences are playing at once, for example
+	// one from the console and one from a button.
+	plan1 := p.Plan(strikes(100*ms, 0, 0, 300*ms, 1), epoch)
+	plan2 := p.Plan(strikes(100*ms, 0, 2, 300*ms, 3), epoch)
+	c.Assert(plan2.Actions, qt.DeepEquals, strikes(100*ms, 100*ms, 2, 400*ms, 3))
+	clock.Advance(150 * ms)
+
+	// Stopping the first sequence frees the time of its
+	// second strike but doesn't remove the protection
+	// for the second sequence.
+	plan1.Cancel()
+	plan := p.Plan(strikes(100*ms, 150*ms, 4), clock.Now())
+	c.Assert(plan.Actions, qt.DeepEquals, strikes(100*ms, 150*ms, 4))
+	plan = p.Plan(strikes(100*ms, 250*ms, 5), clock.Now())
+	c.Assert(plan.Actions, qt.DeepEquals, strikes(100*ms, 350*ms, 5))
+}
+
+func TestSet(t *testing.T) {
+	c := qt.New(t)
+	pins := newFakePins(4)
+	p := New(pins, Config{
+		MaxActive: 2,
+	}, nil)
+	c.Assert(p.Len(), qt.Equals, 4)
+	c.Assert(p.Set(0, true), qt.IsNil)
+	c.Assert(p.Set(1, true), qt.IsNil)
+	// Setting a channel that's already on is OK.
+	c.Assert(p.Set(1, true), qt.IsNil)
+	c.Assert(p.Set(2, true), qt.Equals, ErrTooManyActive)
+	c.Assert(pins.on, qt.DeepEquals, []bool{true, true, false, false})
+
+	c.Assert(p.Set(0, false), qt.IsNil)
+	c.Assert(p.Set(0, false), qt.IsNil)
+	c.Assert(p.Set(2, true), qt.IsNil)
+	c.Assert(pins.on, qt.DeepEquals, []bool{false, true, true, false})
+
+	c.Assert(p.Set(4, true), qt.ErrorMatches, `channel 4 out of range`)
+
+	// Errors from the pins are returned and the
+	// state isn't changed.
+	pins.err = errors.New("i2c failure")
+	c.Assert(p.Set(1, false), qt.ErrorMatches, `i2c failure`)
+	pins.err = nil
+	c.Assert(p.Set(3, true), qt.Equals, ErrTooManyActive)
+}
+
+func TestSetPins(t *testing.T) {
+	c := qt.New(t)
+	pins := newFakePins(20)
+	p := New(pins, Config{
+		MaxActive: 3,
+	}, nil)
+	values := mcp23017.NewPinSlice(20)
+	mask := mcp23017.NewPinSlice(20)
+	set := func(ch int, on bool) {
+		values.Set(ch, on)
+		mask.High(ch)
+	}
+	set(0, true)
+	set(17, true)
+	c.Assert(p.SetPins(values, mask), qt.IsNil)
+	c.Assert(pins.on[0], qt.IsTrue)
+	c.Assert(pins.on[17], qt.IsTrue)
+
+	// Channels being turned off make room for channels
+	// being turned on, and channels that would exceed the
+	// maximum are left off.
+	values, mask = mcp23017.NewPinSlice(20), mcp23017.NewPinSlice(20)
+	set(0, false)
+	set(1, true)
+	set(2, true)
+	set(3, true)
+	c.Assert(p.SetPins(values, mask), qt.Equals, ErrTooManyActive)
+	c.Assert(pins.on[:4], qt.DeepEquals, []bool{false, true, true, false})
+	c.Assert(p.Set(3, true), qt.Equals, ErrTooManyActive)
+
+	// Errors from the pins are returned and the
+	// state isn't changed.
+	values, mask = mcp23017.NewPinSlice(20), mcp23017.NewPinSlice(20)
+	set(1, false)
+	set(2, false)
+	pins.err = errors.New("i2c failure")
+	c.Assert(p.SetPins(values, mask), qt.ErrorMatches, `i2c failure`)
+	pins.err = nil
+	c.Assert(p.Set(3, true), qt.Equals, ErrTooManyActive)
+	c.Assert(p.SetPins(values, mask), qt.IsNil)
+	c.Assert(p.Set(3, true), qt.IsNil)
+}
+
+func TestReasonString(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(MaxDuty.String(), qt.Equals, "max-duty")
+	c.Assert(Reason(99).String(), qt.Equals, "Reason(99)")
+}
+
+// fakePins implements Pins by recording the pin state.
+type fakePins struct {
+	on  []bool
+	err error
+}
+
+func newFakePins(n int) *fakePins {
+	return &fakePins{
+		on: make([]bool, n),
+	}
+}
+
+func (p *fakePins) Len() int {
+	return len(p.on)
+}
+
+func (p *fakePins) Set(ch int, on bool) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.on[ch] = on
+	return nil
+}
+
+func (p *fakePins) SetPins(values, mask mcp23017.PinSlice) error {
+	if p.err != nil {
+		return p.err
+	}
+	for ch := range p.on {
+		if mask.Get(ch) {
+			p.on[ch] = values.Get(ch)
+		}
+	}
+	return nil
+}
+
+func changeStrings(changes []Change) []string {
+	var s []string
+	for _, c := range changes {
+		s = append(s, c.String())
+	}
+	return s
+}
+
+// strikes returns a sequence of strikes of duration d.
+// The arguments are pairs of (time, channel).
+func strikes(d time.Duration, args ...time.Duration) []sequence.Action {
+	var actions []sequence.Action
+	for i := 0; i < len(args); i += 2 {
+		actions = append(actions, sequence.Action{
+			Chan: uint8(args[i+1]),
+			On:   true,
+			When: args[i],
+		}, sequence.Action{
+			Chan: uint8(args[i+1]),
+			On:   false,
+			When: args[i] + d,
+		})
+	}
+	sort.Stable(actionsByTime(actions))
+	return actions
+}
@@ -0,0 +1,445 @@
+// Package schedule implements do-not-disturb schedules: periods
+// of the week during which the doorbell should be quieter than usual.
+//
+// A schedule is written as a sequence of rules, one per line
+// or separated by semicolons. Each rule has the form:
+//
+//	[DAYS] START-END MODE [CHANNELS]
+//
+// DAYS is a comma-separated list of days (mon, tue, wed, thu, fri,
+// sat, sun) or ranges of days such as mon-fri. If it's omitted,
+// the rule applies every day.
+//
+// START and END are times of day in 24-hour HH:MM form. If END is
+// not after START, the period runs past midnight into the next day,
+// so "fri 22:00-07:00" covers Friday night and Saturday morning.
+// If START and END are the same, the period lasts the whole day.
+//
+// MODE is one of:
+//
+//	chime    play only the ding and dong sounds, never tunes
+//	reduced  only use the channels listed in CHANNELS
+//	silent   make no sound at all
+//
+// CHANNELS is a comma-separated list of channels or ranges
+// of channels such as 0-7. It must be given for the reduced mode
+// and only for that mode. Text from // to the end of a line is ignored.
+//
+// Rules may overlap, in which case the restrictions of all the rules
+// that apply are combined, so a chime rule and a reduced rule
+// together allow only the ding and dong on the reduced channels.
+//
+// For example:
+//
+//	22:00-07:00 chime                 // nights
+//	mon-fri 13:00-15:00 silent        // nap time
+//	sat,sun 07:00-09:00 reduced 0-7
+package schedule
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxChannels holds the maximum number of channels
+// that can be used in a Reduced rule.
+const MaxChannels = 32
+
+// Mode represents how the doorbell should behave.
+// Quieter modes have higher values.
+type Mode uint8
+
+const (
+	// Normal means that the doorbell behaves as usual.
+	Normal Mode = iota
+
+	// ChimeOnly means that only the ding and dong
+	// sounds should be played, not tunes.
+	ChimeOnly
+
+	// Reduced means that only some of the channels
+	// should be used (see Policy.Channels).
+	Reduced
+
+	// Silent means that no sound should be made. Button
+	// presses should just be logged.
+	Silent
+)
+
+var modeNames = []string{
+	Normal:    "normal",
+	ChimeOnly: "chime",
+	Reduced:   "reduced",
+	Silent:    "silent",
+}
+
+// String implements fmt.Stringer.
+func (m Mode) String() string {
+	if int(m) < len(modeNames) {
+		return modeNames[m]
+	}
+	return "Mode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// Policy describes how the doorbell should behave.
+type Policy struct {
+	Mode Mode
+	// Channels holds the channels that may be used in
+	// Reduced mode, one bit per channel.
+	Channels uint32
+	// NoTunes holds whether tunes are disallowed in
+	// Reduced mode too, because a ChimeOnly rule also
+	// applies. It's only set by Schedule.At.
+	NoTunes bool
+}
+
+// AllowsTunes reports whether the policy allows
+// tunes to be played.
+func (p Policy) AllowsTunes() bool {
+	switch p.Mode {
+	case ChimeOnly, Silent:
+		return false
+	case Reduced:
+		return !p.NoTunes
+	}
+	return true
+}
+
+// Allows reports whether the policy allows the
+// given channel to be used.
+func (p Policy) Allows(ch int) bool {
+	switch p.Mode {
+	case Silent:
+		return false
+	case Reduced:
+		return ch >= 0 && ch < MaxChannels && p.Channels&(1<<ch) != 0
+	}
+	return true
+}
+
+// Weekdays holds a set of days of the week,
+// one bit for each time.Weekday.
+type Weekdays uint8
+
+// AllDays holds every day of the week.
+const AllDays Weekdays = 1<<7 - 1
+
+// Has reports whether the set holds the given day.
+func (w Weekdays) Has(day time.Weekday) bool {
+	return w&(1<<day) != 0
+}
+
+// Rule represents a single quiet period.
+type Rule struct {
+	// Days holds the days that the period starts on.
+	Days Weekdays
+	// Start and End hold the time of day that the
+	// period starts and ends, as an offset from midnight.
+	Start, End time.Duration
+	Policy
+}
+
+// Schedule holds a set of rules.
+type Schedule struct {
+	Rules []Rule
+}
+
+// At returns the policy in force at the given time, which is
+// interpreted in its own location. When several rules apply,
+// the restrictions of all of them are kept: the quietest mode
+// wins, but if a ChimeOnly rule applies as well as a Reduced
+// rule, tunes aren't allowed either (see Policy.NoTunes), and
+// when several Reduced rules apply, only the channels allowed
+// by all of them may be used.
+func (s *Schedule) At(t time.Time) Policy {
+	p := Policy{
+		Mode: Normal,
+	}
+	if s == nil {
+		return p
+	}
+	noTunes := false
+	for i := range s.Rules {
+		r := &s.Rules[i]
+		if !r.contains(t) {
+			continue
+		}
+		if r.Mode == ChimeOnly {
+			noTunes = true
+		}
+		switch {
+		case r.Mode > p.Mode:
+			p = r.Policy
+		case r.Mode == Reduced && p.Mode == Reduced:
+			p.Channels &= r.Channels
+		}
+	}
+	if p.Mode == Reduced {
+		p.NoTunes = noTunes
+	}
+	return p
+}
+
+// contains reports whether the rule's period contains t.
+func (r *Rule) contains(t time.Time) bool {
+	h, m, sec := t.Clock()
+	tod := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(sec)*time.Second
+	day := t.Weekday()
+	yesterday := (day + 6) % 7
+	switch {
+	case r.Start == r.End:
+		return r.Days.Has(day)
+	case r.Start < r.End:
+		return r.Days.Has(day) && tod >= r.Start && tod < r.End
+	}
+	return r.Days.Has(day) && tod >= r.Start ||
+		r.Days.Has(yesterday) && tod < r.End
+}
+
+// String returns the schedule in the form read by Parse,
+// with rules separated by semicolons.
+func (s *Schedule) String() string {
+	rules := make([]string, len(s.Rules))
+	for i := range s.Rules {
+		rules[i] = s.Rules[i].String()
+	}
+	return strings.Join(rules, "; ")
+}
+
+// String returns the rule in the form read by Parse.
+func (r *Rule) String() string {
+	var buf strings.Builder
+	if r.Days != AllDays {
+		buf.WriteString(formatDays(r.Days))
+		buf.WriteByte(' ')
+	}
+	buf.WriteString(formatTimeOfDay(r.Start))
+	buf.WriteByte('-')
+	buf.WriteString(formatTimeOfDay(r.End))
+	buf.WriteByte(' ')
+	buf.WriteString(r.Mode.String())
+	if r.Mode == Reduced {
+		buf.WriteByte(' ')
+		buf.WriteString(formatChannels(r.Channels))
+	}
+	return buf.String()
+}
+
+var dayNames = []string{
+	time.Sunday:    "sun",
+	time.Monday:    "mon",
+	time.Tuesday:   "tue",
+	time.Wednesday: "wed",
+	time.Thursday:  "thu",
+	time.Friday:    "fri",
+	time.Saturday:  "sat",
+}
+
+// Parse parses a schedule in the format described in the
+// package documentation.
+func Parse(text string) (*Schedule, error) {
+	s := &Schedule{}
+	n := 0
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		for _, rule := range strings.Split(line, ";") {
+			words := strings.Fields(rule)
+			if len(words) == 0 {
+				continue
+			}
+			n++
+			r, err := parseRule(words)
+			if err != nil {
+				return nil, errors.New("rule " + strconv.Itoa(n) + ": " + err.Error())
+			}
+			s.Rules = append(s.Rules, r)
+		}
+	}
+	return s, nil
+}
+
+func parseRule(words []string) (Rule, error) {
+	r := Rule{
+		Days: AllDays,
+	}
+	if len(words) > 0 && !isDigit(words[0]) {
+		days, err := parseDays(words[0])
+		if err != nil {
+			return Rule{}, err
+		}
+		r.Days = days
+		words = words[1:]
+	}
+	if len(words) < 2 {
+		return Rule{}, errors.New("expected [DAYS] START-END MODE [CHANNELS]")
+	}
+	i := strings.Index(words[0], "-")
+	if i < 0 {
+		return Rule{}, errors.New("invalid period " + strconv.Quote(words[0]))
+	}
+	var err error
+	if r.Start, err = parseTimeOfDay(words[0][:i]); err != nil {
+		return Rule{}, err
+	}
+	if r.End, err = parseTimeOfDay(words[0][i+1:]); err != nil {
+		return Rule{}, err
+	}
+	switch words[1] {
+	case "chime":
+		r.Mode = ChimeOnly
+	case "reduced":
+		r.Mode = Reduced
+	case "silent":
+		r.Mode = Silent
+	default:
+		return Rule{}, errors.New("unknown mode " + strconv.Quote(words[1]))
+	}
+	words = words[2:]
+	if r.Mode != Reduced {
+		if len(words) > 0 {
+			return Rule{}, errors.New("unexpected " + strconv.Quote(words[0]))
+		}
+		return r, nil
+	}
+	if len(words) != 1 {
+		return Rule{}, errors.New("reduced mode needs a list of channels")
+	}
+	if r.Channels, err = parseChannels(words[0]); err != nil {
+		return Rule{}, err
+	}
+	return r, nil
+}
+
+// parseDays parses a comma-separated list of days
+// and day ranges.
+func parseDays(s string) (Weekdays, error) {
+	var days Weekdays
+	for _, item := range strings.Split(s, ",") {
+		first, last := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			first, last = item[:i], item[i+1:]
+		}
+		d0, ok0 := parseDay(first)
+		d1, ok1 := parseDay(last)
+		if !ok0 || !ok1 {
+			return 0, errors.New("invalid days " + strconv.Quote(item))
+		}
+		// Ranges can wrap around the end of the week (e.g. fri-mon).
+		for d := d0; ; d = (d + 1) % 7 {
+			days |= 1 << d
+			if d == d1 {
+				break
+			}
+		}
+	}
+	return days, nil
+}
+
+func parseDay(s string) (time.Weekday, bool) {
+	for d, name := range dayNames {
+		if s == name {
+			return time.Weekday(d), true
+		}
+	}
+	return 0, false
+}
+
+// parseTimeOfDay parses a time in HH:MM form.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	i := strings.Index(s, ":")
+	if i >= 0 {
+		h, err1 := strconv.Atoi(s[:i])
+		m, err2 := strconv.Atoi(s[i+1:])
+		if err1 == nil && err2 == nil && len(s[i+1:]) == 2 && h >= 0 && h < 24 && m >= 0 && m < 60 {
+			return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+		}
+	}
+	return 0, errors.New("invalid time of day " + strconv.Quote(s))
+}
+
+// parseChannels parses a comma-separated list of channels
+// and channel ranges.
+func parseChannels(s string) (uint32, error) {
+	var chans uint32
+	for _, item := range strings.Split(s, ",") {
+		first, last := item, item
+		if i := strings.Index(item, "-"); i >= 0 {
+			first, last = item[:i], item[i+1:]
+		}
+		c0, err0 := strconv.Atoi(first)
+		c1, err1 := strconv.Atoi(last)
+		if err0 != nil || err1 != nil || c0 < 0 || c1 >= MaxChannels || c0 > c1 {
+			return 0, errors.New("invalid channels " + strconv.Quote(item))
+		}
+		for c := c0; c <= c1; c++ {
+			chans |= 1 << c
+		}
+	}
+	return chans, nil
+}
+
+func formatDays(days Weekdays) string {
+	var items []string
+	// Start the week on Monday, which is more natural
+	// for schedules than time.Weekday's Sunday.
+	for i := 0; i < 7; {
+		d := time.Weekday((i + 1) % 7)
+		if !days.Has(d) {
+			i++
+			continue
+		}
+		j := i
+		for j+1 < 7 && days.Has(time.Weekday((j+2)%7)) {
+			j++
+		}
+		item := dayNames[d]
+		if j > i {
+			item += "-" + dayNames[(j+1)%7]
+		}
+		items = append(items, item)
+		i = j + 1
+	}
+	return strings.Join(items, ",")
+}
+
+func formatTimeOfDay(d time.Duration) string {
+	h := int(d / time.Hour)
+	m := int(d % time.Hour / time.Minute)
+	return twoDigits(h) + ":" + twoDigits(m)
+}
+
+func twoDigits(n int) string {
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+func formatChannels(chans uint32) string {
+	var items []string
+	for c := 0; c < MaxChannels; {
+		if chans&(1<<c) == 0 {
+			c++
+			continue
+		}
+		end := c
+		for end+1 < MaxChannels && chans&(1<<(end+1)) != 0 {
+			end++
+		}
+		item := strconv.Itoa(c)
+		if end > c {
+			item += "-" + strconv.Itoa(end)
+		}
+		items = append(items, item)
+		c = end + 1
+	}
+	return strings.Join(items, ",")
+}
+
+func isDigit(s string) bool {
+	return s != "" && '0' <= s[0] && s[0] <= '9'
+}
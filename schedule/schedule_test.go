@@ -0,0 +1,255 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var parseTests = []struct {
+	testName    string
+	text        string
+	expect      []Rule
+	expectError string
+}{{
+	testName: "empty",
+	text:     " // nothing\n\n",
+}, {
+	testName: "every-day",
+	text:     "22:00-07:30 chime",
+	expect: []Rule{{
+		Days:   AllDays,
+		Start:  22 * time.Hour,
+		End:    7*time.Hour + 30*time.Minute,
+		Policy: Policy{Mode: ChimeOnly},
+	}},
+}, {
+	testName: "days-and-channels",
+	text: `
+		mon-wed,fri 13:00-15:00 silent // nap
+		sat,sun 07:00-09:00 reduced 0-3,8
+	`,
+	expect: []Rule{{
+		Days:   1<<time.Monday | 1<<time.Tuesday | 1<<time.Wednesday | 1<<time.Friday,
+		Start:  13 * time.Hour,
+		End:    15 * time.Hour,
+		Policy: Policy{Mode: Silent},
+	}, {
+		Days:  1<<time.Saturday | 1<<time.Sunday,
+		Start: 7 * time.Hour,
+		End:   9 * time.Hour,
+		Policy: Policy{
+			Mode:     Reduced,
+			Channels: 0b1_0000_1111,
+		},
+	}},
+}, {
+	testName: "semicolons-and-wrapping-days",
+	text:     "fri-mon 00:00-00:00 silent; 01:00-02:00 chime",
+	expect: []Rule{{
+		Days:   1<<time.Friday | 1<<time.Saturday | 1<<time.Sunday | 1<<time.Monday,
+		Policy: Policy{Mode: Silent},
+	}, {
+		Days:   AllDays,
+		Start:  time.Hour,
+		End:    2 * time.Hour,
+		Policy: Policy{Mode: ChimeOnly},
+	}},
+}, {
+	testName:    "missing-period",
+	text:        "chime; mon,xxx 01:00-02:00 silent",
+	expectError: `rule 1: invalid days "chime"`,
+}, {
+	testName:    "bad-day-in-list",
+	text:        "mon,xxx 01:00-02:00 silent",
+	expectError: `rule 1: invalid days "xxx"`,
+}, {
+	testName:    "missing-mode",
+	text:        "01:00-02:00",
+	expectError: `rule 1: expected \[DAYS\] START-END MODE \[CHANNELS\]`,
+}, {
+	testName:    "bad-period",
+	text:        "01:00 silent",
+	expectError: `rule 1: invalid period "01:00"`,
+}, {
+	testName:    "bad-time-of-day",
+	text:        "23:00-24:00 silent",
+	expectError: `rule 1: invalid time of day "24:00"`,
+}, {
+	testName:    "unknown-mode",
+	text:        "01:00-02:00 silent; 01:00-02:00 loud",
+	expectError: `rule 2: unknown mode "loud"`,
+}, {
+	testName:    "channels-without-reduced",
+	text:        "01:00-02:00 silent 0-3",
+	expectError: `rule 1: unexpected "0-3"`,
+}, {
+	testName:    "reduced-without-channels",
+	text:        "01:00-02:00 reduced",
+	expectError: `rule 1: reduced mode needs a list of channels`,
+}, {
+	testName:    "channel-out-of-range",
+	text:        "01:00-02:00 reduced 30-32",
+	expectError: `rule 1: invalid channels "30-32"`,
+}}
+
+func TestParse(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseTests {
+		c.Run(test.testName, func(c *qt.C) {
+			s, err := Parse(test.text)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(s.Rules, qt.DeepEquals, test.expect)
+			// Check that the schedule round-trips.
+			s1, err := Parse(s.String())
+			c.Assert(err, qt.IsNil)
+			c.Assert(s1, qt.DeepEquals, s)
+		})
+	}
+}
+
+func TestString(t *testing.T) {
+	c := qt.New(t)
+	s, err := Parse(`
+		22:00-07:00 chime
+		sun,tue-thu,sat 13:00-15:00 silent
+		fri-mon 07:00-09:00 reduced 0-3,5,7-8
+	`)
+	c.Assert(err, qt.IsNil)
+	c.Assert(s.String(), qt.Equals, "22:00-07:00 chime; tue-thu,sat-sun 13:00-15:00 silent; mon,fri-sun 07:00-09:00 reduced 0-3,5,7-8")
+}
+
+// 2020-01-06 is a Monday.
+var monday = time.Date(2020, 1, 6, 0, 0, 0, 0, time.UTC)
+
+var atTests = []struct {
+	testName string
+	schedule string
+	// at holds the time since the start of the Monday.
+	at     time.Duration
+	expect Policy
+}{{
+	testName: "no-rules",
+	at:       12 * time.Hour,
+	expect:   Policy{Mode: Normal},
+}, {
+	testName: "inside-period",
+	schedule: "mon 13:00-15:00 silent",
+	at:       13 * time.Hour,
+	expect:   Policy{Mode: Silent},
+}, {
+	testName: "at-end-of-period",
+	schedule: "mon 13:00-15:00 silent",
+	at:       15 * time.Hour,
+	expect:   Policy{Mode: Normal},
+}, {
+	testName: "wrong-day",
+	schedule: "tue 13:00-15:00 silent",
+	at:       14 * time.Hour,
+	expect:   Policy{Mode: Normal},
+}, {
+	testName: "after-midnight-continues-from-previous-day",
+	schedule: "sun 22:00-07:00 chime",
+	at:       6*time.Hour + 59*time.Minute,
+	expect:   Policy{Mode: ChimeOnly},
+}, {
+	testName: "after-midnight-from-other-day",
+	schedule: "mon 22:00-07:00 chime",
+	at:       6 * time.Hour,
+	expect:   Policy{Mode: Normal},
+}, {
+	testName: "before-midnight",
+	schedule: "mon 22:00-07:00 chime",
+	at:       23 * time.Hour,
+	expect:   Policy{Mode: ChimeOnly},
+}, {
+	testName: "whole-day",
+	schedule: "mon 09:00-09:00 silent",
+	at:       time.Hour,
+	expect:   Policy{Mode: Silent},
+}, {
+	testName: "quietest-wins",
+	schedule: "00:00-00:00 reduced 0-7; 08:00-09:00 silent; 00:00-00:00 chime",
+	at:       8 * time.Hour,
+	expect:   Policy{Mode: Silent},
+}, {
+	testName: "reduced-channels-intersect",
+	schedule: "00:00-00:00 reduced 0-7; 08:00-09:00 reduced 4-10; 08:00-09:00 chime",
+	at:       8 * time.Hour,
+	expect: Policy{
+		Mode:     Reduced,
+		Channels: 0xf0,
+		NoTunes:  true,
+	},
+}, {
+	testName: "reduced-after-chime",
+	schedule: "22:00-07:00 chime; 23:00-23:30 reduced 0-7",
+	at:       23 * time.Hour,
+	expect: Policy{
+		Mode:     Reduced,
+		Channels: 0xff,
+		NoTunes:  true,
+	},
+}, {
+	testName: "chime-after-reduced",
+	schedule: "23:00-23:30 reduced 0-7; 22:00-07:00 chime",
+	at:       23 * time.Hour,
+	expect: Policy{
+		Mode:     Reduced,
+		Channels: 0xff,
+		NoTunes:  true,
+	},
+}, {
+	testName: "chime-outside-reduced",
+	schedule: "23:00-23:30 reduced 0-7; 22:00-07:00 chime",
+	at:       22 * time.Hour,
+	expect:   Policy{Mode: ChimeOnly},
+}}
+
+func TestAt(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range atTests {
+		c.Run(test.testName, func(c *qt.C) {
+			s, err := Parse(test.schedule)
+			c.Assert(err, qt.IsNil)
+			c.Assert(s.At(monday.Add(test.at)), qt.Equals, test.expect)
+		})
+	}
+}
+
+func TestAtNilSchedule(t *testing.T) {
+	c := qt.New(t)
+	var s *Schedule
+	c.Assert(s.At(monday), qt.Equals, Policy{Mode: Normal})
+}
+
+func TestAllows(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(Policy{Mode: Normal}.Allows(5), qt.IsTrue)
+	c.Assert(Policy{Mode: ChimeOnly}.Allows(5), qt.IsTrue)
+	c.Assert(Policy{Mode: Silent}.Allows(5), qt.IsFalse)
+	p := Policy{Mode: Reduced, Channels: 0b100}
+	c.Assert(p.Allows(2), qt.IsTrue)
+	c.Assert(p.Allows(3), qt.IsFalse)
+	c.Assert(p.Allows(40), qt.IsFalse)
+}
+
+func TestAllowsTunes(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(Policy{Mode: Normal}.AllowsTunes(), qt.IsTrue)
+	c.Assert(Policy{Mode: ChimeOnly}.AllowsTunes(), qt.IsFalse)
+	c.Assert(Policy{Mode: Reduced}.AllowsTunes(), qt.IsTrue)
+	c.Assert(Policy{Mode: Reduced, NoTunes: true}.AllowsTunes(), qt.IsFalse)
+	c.Assert(Policy{Mode: Silent}.AllowsTunes(), qt.IsFalse)
+}
+
+func TestModeString(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(ChimeOnly.String(), qt.Equals, "chime")
+	c.Assert(Mode(99).String(), qt.Equals, "Mode(99)")
+}
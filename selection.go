@@ -6,14 +6,20 @@ import (
 	"github.com/rogpeppe/doorbell/sequence"
 )
 
+// tune holds a tune and its name.
+type tune struct {
+	name    string
+	actions []sequence.Action
+}
+
 type tuneSelection struct {
-	tunes     [][]sequence.Action
+	tunes     []tune
 	rand      *rand.Rand
 	played    []bool
 	numPlayed int
 }
 
-func newTuneSelection(tunes [][]sequence.Action, randGen *rand.Rand) *tuneSelection {
+func newTuneSelection(tunes []tune, randGen *rand.Rand) *tuneSelection {
 	return &tuneSelection{
 		tunes:  tunes,
 		rand:   randGen,
@@ -28,7 +34,7 @@ func (ts *tuneSelection) reset() {
 	ts.numPlayed = 0
 }
 
-func (ts *tuneSelection) choose() []sequence.Action {
+func (ts *tuneSelection) choose() tune {
 	if ts.numPlayed >= len(ts.tunes) {
 		// We've played all the tunes, so start again with a new random selection.
 		ts.reset()
@@ -0,0 +1,542 @@
+package sequence
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// OutOfRangePolicy determines what happens to notes that
+// don't map to any available channel.
+type OutOfRangePolicy uint8
+
+const (
+	// DropOutOfRange causes notes outside the available
+	// range to be ignored.
+	DropOutOfRange OutOfRangePolicy = iota
+
+	// FoldOutOfRange moves each out-of-range note up or down
+	// by whole octaves until it falls within the available range.
+	// If there are fewer than 12 channels, notes that
+	// can't be folded into range are dropped.
+	FoldOutOfRange
+
+	// TransposeOutOfRange transposes the whole tune by the
+	// number of octaves that fits the most notes within the
+	// available range. Notes that are still out of range
+	// after that are dropped.
+	TransposeOutOfRange
+)
+
+// MiddleC holds the MIDI note number of middle C.
+const MiddleC = 60
+
+// percussionChannel holds the MIDI channel (zero-based) conventionally
+// used for percussion instruments.
+const percussionChannel = 9
+
+// MIDIParams holds parameters for ActionsForMIDI.
+type MIDIParams struct {
+	// ChanCount holds the number of available channels.
+	ChanCount int
+
+	// BaseNote holds the MIDI note number that maps
+	// to channel 0. Successive channels are mapped to
+	// successive semitones. If this is zero, MiddleC is used.
+	BaseNote int
+
+	// OutOfRange determines what happens to notes
+	// that fall outside the available channels.
+	OutOfRange OutOfRangePolicy
+
+	// SolenoidDuration holds the length of time each
+	// channel is activated for.
+	SolenoidDuration time.Duration
+
+	// MinVelocityScale, if non-zero, makes the activation time
+	// depend on the velocity of each note. Notes with the
+	// maximum velocity (127) are activated for SolenoidDuration,
+	// and quieter notes for proportionally less time, down to
+	// MinVelocityScale*SolenoidDuration for velocity 1.
+	MinVelocityScale float64
+
+	// Percussion specifies that notes on the MIDI percussion
+	// channel (channel 10) should be included. By default
+	// they are ignored because they don't represent pitches.
+	Percussion bool
+
+	// OnDrop, if non-nil, is called with the MIDI note number
+	// and time of each note that's dropped because it's
+	// out of range.
+	OnDrop func(note int, when time.Duration)
+}
+
+// ActionsForMIDI reads Standard MIDI File data (type 0 or type 1)
+// and returns the actions needed to play it. Each note-on event
+// causes a channel to be activated for p.SolenoidDuration, scaled
+// by the note's velocity if p.MinVelocityScale is set; note-off
+// events are ignored because a solenoid can't sustain a note.
+//
+// All tracks are merged, and tempo changes on any track
+// apply to all tracks.
+//
+// The returned actions will be sorted in time order.
+func ActionsForMIDI(data []byte, p MIDIParams) ([]Action, error) {
+	f, err := parseMIDI(data)
+	if err != nil {
+		return nil, err
+	}
+	baseNote := p.BaseNote
+	if baseNote == 0 {
+		baseNote = MiddleC
+	}
+	notes := make([]midiNote, 0, len(f.notes))
+	for _, n := range f.notes {
+		if n.channel == percussionChannel && !p.Percussion {
+			continue
+		}
+		notes = append(notes, n)
+	}
+	transpose := 0
+	if p.OutOfRange == TransposeOutOfRange {
+		transpose = bestTranspose(notes, baseNote, p.ChanCount)
+	}
+	times := f.tickTimes()
+	actions := make([]Action, 0, len(notes)*2)
+	// played holds the channels that have been activated
+	// at the current time, so that notes that map to
+	// the same channel (for example because of folding) don't
+	// produce duplicate actions.
+	var played []uint8
+	var lastTime time.Duration
+	for _, n := range notes {
+		ch := int(n.note) - baseNote + transpose
+		if p.OutOfRange == FoldOutOfRange {
+			ch = foldIntoRange(ch, p.ChanCount)
+		}
+		when := times.time(n.tick)
+		if ch < 0 || ch >= p.ChanCount {
+			if p.OnDrop != nil {
+				p.OnDrop(int(n.note), when)
+			}
+			continue
+		}
+		if when != lastTime {
+			played = played[:0]
+			lastTime = when
+		}
+		if containsChan(played, uint8(ch)) {
+			continue
+		}
+		played = append(played, uint8(ch))
+		actions = append(actions, Action{
+			Chan: uint8(ch),
+			On:   true,
+			When: when,
+		}, Action{
+			Chan: uint8(ch),
+			On:   false,
+			When: when + p.duration(n.velocity),
+		})
+	}
+	sort.Stable(actionsByTime(actions))
+	return actions, nil
+}
+
+// duration returns the activation time for a note
+// with the given velocity.
+func (p MIDIParams) duration(velocity uint8) time.Duration {
+	if p.MinVelocityScale == 0 {
+		return p.SolenoidDuration
+	}
+	if velocity > 127 {
+		velocity = 127
+	}
+	scale := p.MinVelocityScale + (1-p.MinVelocityScale)*float64(velocity-1)/126
+	return time.Duration(float64(p.SolenoidDuration) * scale)
+}
+
+// foldIntoRange moves ch by octaves until it's within [0, n).
+// If that's not possible, it returns ch unchanged.
+func foldIntoRange(ch int, n int) int {
+	if n < 12 {
+		// Not every pitch class is available.
+		if ch < 0 || ch >= n {
+			for c := ch % 12; c < n; c += 12 {
+				if c >= 0 {
+					return c
+				}
+			}
+		}
+		return ch
+	}
+	for ch < 0 {
+		ch += 12
+	}
+	for ch >= n {
+		ch -= 12
+	}
+	return ch
+}
+
+// bestTranspose returns the number of semitones (always a multiple
+// of 12) to transpose the given notes by so that the largest
+// number of them fall within n channels starting at baseNote.
+// When there's a tie, the transposition closest to zero wins.
+func bestTranspose(notes []midiNote, baseNote int, n int) int {
+	best, bestCount := 0, -1
+	for _, t := range []int{0, -12, 12, -24, 24, -36, 36, -48, 48, -60, 60} {
+		count := 0
+		for _, note := range notes {
+			ch := int(note.note) - baseNote + t
+			if ch >= 0 && ch < n {
+				count++
+			}
+		}
+		if count > bestCount {
+			best, bestCount = t, count
+		}
+	}
+	return best
+}
+
+func containsChan(chans []uint8, ch uint8) bool {
+	for _, c := range chans {
+		if c == ch {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultTempo holds the tempo (in microseconds per
+// quarter note) used when a file doesn't specify one.
+const defaultTempo = 500000
+
+// midiFile holds the information we care about
+// from a MIDI file.
+type midiFile struct {
+	// division holds the division field from the header.
+	division uint16
+	// notes holds all the note-on events, in time order.
+	notes []midiNote
+	// tempos holds all the tempo changes, in time order.
+	tempos []tempoChange
+}
+
+type midiNote struct {
+	tick     uint32
+	channel  uint8
+	note     uint8
+	velocity uint8
+}
+
+type tempoChange struct {
+	tick uint32
+	// usPerQuarter holds the number of microseconds per quarter note.
+	usPerQuarter uint32
+}
+
+// parseMIDI parses the note and tempo information
+// from the given MIDI file data.
+func parseMIDI(data []byte) (*midiFile, error) {
+	id, hdr, data, err := readChunk(data)
+	if err != nil {
+		return nil, err
+	}
+	if id != "MThd" {
+		return nil, errors.New("not a MIDI file")
+	}
+	if len(hdr) < 6 {
+		return nil, errors.New("MIDI header too short")
+	}
+	format := binary.BigEndian.Uint16(hdr[0:2])
+	ntracks := int(binary.BigEndian.Uint16(hdr[2:4]))
+	f := &midiFile{
+		division: binary.BigEndian.Uint16(hdr[4:6]),
+	}
+	if format > 1 {
+		return nil, errors.New("unsupported MIDI file format " + strconv.Itoa(int(format)))
+	}
+	if f.division == 0 {
+		return nil, errors.New("invalid zero MIDI time division")
+	}
+	if f.division&0x8000 != 0 {
+		// SMPTE time: tickTimes relies on these being valid.
+		switch fps := -int8(f.division >> 8); fps {
+		case 24, 25, 29, 30:
+		default:
+			return nil, errors.New("invalid SMPTE frame rate " + strconv.Itoa(int(fps)) + " in MIDI time division")
+		}
+		if f.division&0xff == 0 {
+			return nil, errors.New("invalid zero ticks per frame in MIDI time division")
+		}
+	}
+	for track := 0; track < ntracks; {
+		if len(data) == 0 {
+			return nil, errors.New("MIDI file has " + strconv.Itoa(track) + " tracks; expected " + strconv.Itoa(ntracks))
+		}
+		var chunk []byte
+		id, chunk, data, err = readChunk(data)
+		if err != nil {
+			return nil, err
+		}
+		if id != "MTrk" {
+			// Unknown chunks must be ignored.
+			continue
+		}
+		if err := f.parseTrack(chunk); err != nil {
+			return nil, errors.New("MIDI track " + strconv.Itoa(track) + ": " + err.Error())
+		}
+		track++
+	}
+	sort.Stable(notesByTick(f.notes))
+	sort.Stable(temposByTick(f.tempos))
+	return f, nil
+}
+
+// readChunk reads a chunk from the start of data, returning its
+// type, its contents and the remaining data.
+func readChunk(data []byte) (id string, chunk, rest []byte, err error) {
+	if len(data) < 8 {
+		return "", nil, nil, errors.New("truncated MIDI chunk header")
+	}
+	n := binary.BigEndian.Uint32(data[4:8])
+	if uint64(n) > uint64(len(data)-8) {
+		return "", nil, nil, errors.New("truncated MIDI chunk")
+	}
+	return string(data[0:4]), data[8 : 8+n], data[8+n:], nil
+}
+
+// parseTrack parses the events in a track chunk.
+func (f *midiFile) parseTrack(data []byte) error {
+	var tick uint32
+	// status holds the running status byte; zero
+	// when there is none.
+	var status byte
+	for len(data) > 0 {
+		delta, n := readVarint(data)
+		if n == 0 {
+			return errors.New("invalid delta time")
+		}
+		data = data[n:]
+		tick += delta
+		if len(data) == 0 {
+			return errors.New("missing event")
+		}
+		switch b := data[0]; {
+		case b == 0xff:
+			// Meta event.
+			if len(data) < 2 {
+				return errors.New("truncated meta event")
+			}
+			metaType := data[1]
+			body, rest, err := readVarData(data[2:])
+			if err != nil {
+				return err
+			}
+			data = rest
+			status = 0
+			switch metaType {
+			case 0x2f:
+				// End of track.
+				return nil
+			case 0x51:
+				if len(body) != 3 {
+					return errors.New("invalid tempo event")
+				}
+				f.tempos = append(f.tempos, tempoChange{
+					tick:         tick,
+					usPerQuarter: uint32(body[0])<<16 | uint32(body[1])<<8 | uint32(body[2]),
+				})
+			}
+			continue
+		case b == 0xf0 || b == 0xf7:
+			// System exclusive event.
+			_, rest, err := readVarData(data[1:])
+			if err != nil {
+				return err
+			}
+			data = rest
+			status = 0
+			continue
+		case b >= 0x80:
+			status = b
+			data = data[1:]
+		case status == 0:
+			return errors.New("data byte without running status")
+		}
+		n = channelMessageLen(status)
+		if n == 0 {
+			return errors.New("unexpected status byte " + strconv.Itoa(int(status)))
+		}
+		if len(data) < n {
+			return errors.New("truncated channel message")
+		}
+		msg := data[:n]
+		data = data[n:]
+		// Note that a note-on message with zero velocity
+		// is conventionally equivalent to note-off.
+		if status&0xf0 == 0x90 && msg[1] != 0 {
+			f.notes = append(f.notes, midiNote{
+				tick:     tick,
+				channel:  status & 0xf,
+				note:     msg[0],
+				velocity: msg[1],
+			})
+		}
+	}
+	return nil
+}
+
+// channelMessageLen returns the number of data bytes that
+// follow the given channel message status byte, or zero
+// if it's not a channel message.
+func channelMessageLen(status byte) int {
+	switch status & 0xf0 {
+	case 0x80, 0x90, 0xa0, 0xb0, 0xe0:
+		return 2
+	case 0xc0, 0xd0:
+		return 1
+	}
+	return 0
+}
+
+// readVarData reads some length-prefixed data
+// from the start of data and returns it and the remaining data.
+func readVarData(data []byte) (body, rest []byte, err error) {
+	size, n := readVarint(data)
+	if n == 0 {
+		return nil, nil, errors.New("invalid length")
+	}
+	data = data[n:]
+	if uint64(size) > uint64(len(data)) {
+		return nil, nil, errors.New("truncated event")
+	}
+	return data[:size], data[size:], nil
+}
+
+// readVarint reads a MIDI variable-length quantity
+// from the start of data and returns it along with the
+// number of bytes read. It returns zero bytes read if
+// the quantity is invalid or truncated.
+func readVarint(data []byte) (uint32, int) {
+	var x uint32
+	for i := 0; i < 4 && i < len(data); i++ {
+		b := data[i]
+		x = x<<7 | uint32(b&0x7f)
+		if b&0x80 == 0 {
+			return x, i + 1
+		}
+	}
+	return 0, 0
+}
+
+// tickTimes returns a mapping from tick values to time values for f.
+func (f *midiFile) tickTimes() tickTimes {
+	if f.division&0x8000 != 0 {
+		// SMPTE time: the upper byte holds the negative number of
+		// frames per second and the lower byte holds the
+		// number of ticks per frame.
+		fps := time.Duration(-int8(f.division >> 8))
+		tpf := time.Duration(f.division & 0xff)
+		if fps == 29 {
+			// 29 actually means 29.97 (drop-frame) timecode.
+			return tickTimes{
+				smpteNum: 1001,
+				smpteDen: 30000 * tpf,
+			}
+		}
+		return tickTimes{
+			smpteNum: 1,
+			smpteDen: fps * tpf,
+		}
+	}
+	tt := tickTimes{
+		division: time.Duration(f.division),
+		segments: []tempoSegment{{
+			usPerQuarter: defaultTempo,
+		}},
+	}
+	for _, tc := range f.tempos {
+		seg := tempoSegment{
+			tick:         tc.tick,
+			usPerQuarter: tc.usPerQuarter,
+			start:        tt.time(tc.tick),
+		}
+		if last := &tt.segments[len(tt.segments)-1]; last.tick == tc.tick {
+			// Later tempo changes at the same time override earlier ones.
+			*last = seg
+		} else {
+			tt.segments = append(tt.segments, seg)
+		}
+	}
+	return tt
+}
+
+// tickTimes maps from MIDI ticks to time.
+type tickTimes struct {
+	// smpteNum and smpteDen hold the duration of a tick
+	// in seconds as a fraction when SMPTE timing is used;
+	// otherwise they're zero. The duration isn't held directly
+	// because it isn't usually a whole number of nanoseconds.
+	smpteNum, smpteDen time.Duration
+
+	// division holds the number of ticks per quarter note.
+	division time.Duration
+	// segments holds the tempo in use from each
+	// tempo change onwards.
+	segments []tempoSegment
+}
+
+type tempoSegment struct {
+	tick         uint32
+	usPerQuarter uint32
+	// start holds the time at the start of the segment.
+	start time.Duration
+}
+
+// time returns the time of the given tick.
+func (tt tickTimes) time(tick uint32) time.Duration {
+	if tt.smpteDen != 0 {
+		// Calculate the whole seconds separately
+		// so that the multiplication can't overflow.
+		n := time.Duration(tick) * tt.smpteNum
+		return n/tt.smpteDen*time.Second + n%tt.smpteDen*time.Second/tt.smpteDen
+	}
+	i := sort.Search(len(tt.segments), func(i int) bool {
+		return tt.segments[i].tick > tick
+	}) - 1
+	seg := tt.segments[i]
+	return seg.start + time.Duration(tick-seg.tick)*time.Duration(seg.usPerQuarter)*time.Microsecond/tt.division
+}
+
+type notesByTick []midiNote
+
+func (s notesByTick) Less(i, j int) bool {
+	return s[i].tick < s[j].tick
+}
+
+func (s notesByTick) Len() int {
+	return len(s)
+}
+
+func (s notesByTick) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+type temposByTick []tempoChange
+
+func (s temposByTick) Less(i, j int) bool {
+	return s[i].tick < s[j].tick
+}
+
+func (s temposByTick) Len() int {
+	return len(s)
+}
+
+func (s temposByTick) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
@@ -0,0 +1,413 @@
+package sequence
+
+import (
+	"encoding/binary"
+	"sort"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var actionsForMIDITests = []struct {
+	testName    string
+	data        []byte
+	params      MIDIParams
+	expect      []Action
+	expectError string
+}{{
+	testName: "single-track",
+	data: midiData(0, 96, []byte{
+		0x00, 0x90, 60, 0x40,
+		0x60, 0x80, 60, 0x00,
+		0x00, 0x90, 62, 0x40,
+		0x00, 0xff, 0x2f, 0x00,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 0,
+		500*time.Millisecond, 2,
+	),
+}, {
+	testName: "running-status",
+	data: midiData(0, 96, []byte{
+		0x00, 0x90, 60, 0x40,
+		0x60, 62, 0x40,
+		// Zero velocity acts as note-off.
+		0x00, 60, 0x00,
+		// Other channel messages are ignored.
+		0x00, 0xc0, 0x05,
+		0x00, 0xb0, 0x07, 0x7f,
+		0x00, 0x90, 64, 0x40,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 0,
+		500*time.Millisecond, 2,
+		500*time.Millisecond, 4,
+	),
+}, {
+	testName: "tempo-change",
+	data: midiData(0, 96, []byte{
+		0x00, 0xff, 0x51, 0x03, 0x07, 0xa1, 0x20,
+		0x00, 0x90, 60, 0x40,
+		0x60, 0xff, 0x51, 0x03, 0x0f, 0x42, 0x40,
+		0x00, 0x90, 62, 0x40,
+		0x60, 0x90, 64, 0x40,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 0,
+		500*time.Millisecond, 2,
+		1500*time.Millisecond, 4,
+	),
+}, {
+	testName: "multiple-tracks",
+	data: midiData(1, 96,
+		// The tempo track applies to all tracks.
+		[]byte{
+			0x00, 0xff, 0x51, 0x03, 0x03, 0xd0, 0x90,
+			0x00, 0xff, 0x2f, 0x00,
+		},
+		[]byte{
+			0x00, 0x90, 60, 0x40,
+			0x60, 0x90, 62, 0x40,
+		},
+		[]byte{
+			0x30, 0x91, 64, 0x40,
+			// Sysex events are skipped.
+			0x00, 0xf0, 0x03, 0x01, 0x02, 0xf7,
+			0x81, 0x40, 0x91, 65, 0x40,
+		},
+	),
+	params: MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 0,
+		125*time.Millisecond, 4,
+		250*time.Millisecond, 2,
+		625*time.Millisecond, 5,
+	),
+}, {
+	testName: "base-note",
+	data: midiData(0, 96, []byte{
+		0x00, 0x90, 72, 0x40,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		BaseNote:         70,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 2,
+	),
+}, {
+	testName: "drop-out-of-range",
+	data: midiData(0, 96, []byte{
+		0x00, 0x90, 59, 0x40,
+		0x00, 0x90, 60, 0x40,
+		0x00, 0x90, 83, 0x40,
+		0x00, 0x90, 84, 0x40,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 0,
+		0, 23,
+	),
+}, {
+	testName: "fold-out-of-range",
+	data: midiData(0, 96, []byte{
+		0x00, 0x90, 47, 0x40,
+		0x00, 0x90, 84, 0x40,
+		// This folds to the same channel as the
+		// previous note, so it's only played once.
+		0x00, 0x90, 96, 0x40,
+		0x60, 0x90, 108, 0x40,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		OutOfRange:       FoldOutOfRange,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 11,
+		0, 12,
+		500*time.Millisecond, 12,
+	),
+}, {
+	testName: "transpose-out-of-range",
+	data: midiData(0, 96, []byte{
+		0x00, 0x90, 36, 0x40,
+		0x00, 0x90, 40, 0x40,
+		0x00, 0x90, 43, 0x40,
+		0x00, 0x90, 70, 0x40,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		OutOfRange:       TransposeOutOfRange,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 0,
+		0, 4,
+		0, 7,
+	),
+}, {
+	testName: "percussion-ignored",
+	data: midiData(0, 96, []byte{
+		0x00, 0x99, 60, 0x40,
+		0x00, 0x90, 61, 0x40,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 1,
+	),
+}, {
+	testName: "percussion-included",
+	data: midiData(0, 96, []byte{
+		0x00, 0x99, 60, 0x40,
+		0x00, 0x90, 61, 0x40,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		Percussion:       true,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 0,
+		0, 1,
+	),
+}, {
+	testName: "smpte-timing",
+	// 25 frames per second, 40 ticks per frame: 1ms per tick.
+	data: midiData(0, 0xe728, []byte{
+		0x64, 0x90, 60, 0x40,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		100*time.Millisecond, 0,
+	),
+}, {
+	testName: "velocity",
+	data: midiData(0, 96, []byte{
+		0x00, 0x90, 60, 0x7f,
+		0x00, 0x90, 61, 0x40,
+		0x00, 0x90, 62, 0x01,
+	}),
+	params: MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 100 * time.Millisecond,
+		MinVelocityScale: 0.2,
+	},
+	expect: []Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 1, On: true, When: 0},
+		{Chan: 2, On: true, When: 0},
+		{Chan: 2, On: false, When: 20 * time.Millisecond},
+		{Chan: 1, On: false, When: 60 * time.Millisecond},
+		{Chan: 0, On: false, When: 100 * time.Millisecond},
+	},
+}, {
+	testName: "unknown-chunk",
+	data: append(append(midiData(0, 96, nil)[:14:14],
+		chunk("XFoo", []byte{1, 2, 3})...),
+		chunk("MTrk", []byte{0x00, 0x90, 60, 0x40})...,
+	),
+	params: MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 10 * time.Millisecond,
+	},
+	expect: strikes(10*time.Millisecond,
+		0, 0,
+	),
+}, {
+	testName:    "not-midi",
+	data:        chunk("RIFF", []byte{0, 0, 0, 0, 0, 0}),
+	expectError: `not a MIDI file`,
+}, {
+	testName:    "unsupported-format",
+	data:        midiData(2, 96),
+	expectError: `unsupported MIDI file format 2`,
+}, {
+	testName:    "smpte-zero-ticks-per-frame",
+	data:        midiData(0, 0xe700, []byte{0x00, 0xff, 0x2f, 0x00}),
+	expectError: `invalid zero ticks per frame in MIDI time division`,
+}, {
+	testName:    "smpte-invalid-frame-rate",
+	data:        midiData(0, 0x8028, []byte{0x00, 0xff, 0x2f, 0x00}),
+	expectError: `invalid SMPTE frame rate -128 in MIDI time division`,
+}, {
+	testName:    "smpte-unknown-frame-rate",
+	data:        midiData(0, 0xec28, []byte{0x00, 0xff, 0x2f, 0x00}),
+	expectError: `invalid SMPTE frame rate 20 in MIDI time division`,
+}, {
+	testName:    "missing-track",
+	data:        midiData(1, 96, nil)[:14],
+	expectError: `MIDI file has 0 tracks; expected 1`,
+}, {
+	testName:    "truncated-chunk",
+	data:        midiData(0, 96, []byte{0x00, 0x90, 60, 0x40})[:24],
+	expectError: `truncated MIDI chunk`,
+}, {
+	testName: "no-running-status",
+	data: midiData(0, 96, []byte{
+		0x00, 60, 0x40,
+	}),
+	expectError: `MIDI track 0: data byte without running status`,
+}, {
+	testName: "truncated-event",
+	data: midiData(0, 96, []byte{
+		0x00, 0x90, 60,
+	}),
+	expectError: `MIDI track 0: truncated channel message`,
+}}
+
+func TestActionsForMIDIOnDrop(t *testing.T) {
+	c := qt.New(t)
+	data := midiData(0, 96, []byte{
+		0x00, 0x90, 59, 0x40,
+		0x60, 0x90, 60, 0x40,
+		0x60, 0x90, 84, 0x40,
+	})
+	type dropped struct {
+		Note int
+		When time.Duration
+	}
+	var got []dropped
+	actions, err := ActionsForMIDI(data, MIDIParams{
+		ChanCount:        24,
+		SolenoidDuration: 10 * time.Millisecond,
+		OnDrop: func(note int, when time.Duration) {
+			got = append(got, dropped{note, when})
+		},
+	})
+	c.Assert(err, qt.IsNil)
+	c.Assert(actions, qt.DeepEquals, strikes(10*time.Millisecond, 500*time.Millisecond, 0))
+	c.Assert(got, qt.DeepEquals, []dropped{
+		{59, 0},
+		{84, time.Second},
+	})
+}
+
+func TestActionsForMIDI(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range actionsForMIDITests {
+		c.Run(test.testName, func(c *qt.C) {
+			actions, err := ActionsForMIDI(test.data, test.params)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(actions, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+var smpteTickTimesTests = []struct {
+	testName string
+	division uint16
+	tick     uint32
+	expect   time.Duration
+}{{
+	testName: "whole-ticks",
+	// 25 frames per second, 40 ticks per frame.
+	division: 0xe728,
+	tick:     1000,
+	expect:   time.Second,
+}, {
+	testName: "fractional-tick",
+	// 30 frames per second, 80 ticks per frame.
+	division: 0xe250,
+	tick:     1,
+	expect:   416666,
+}, {
+	testName: "no-drift",
+	division: 0xe250,
+	tick:     2400 * 3600,
+	expect:   time.Hour,
+}, {
+	testName: "drop-frame",
+	// 29.97 frames per second, 100 ticks per frame.
+	division: 0xe364,
+	tick:     3000 * 60,
+	expect:   60060 * time.Millisecond,
+}, {
+	testName: "largest-tick",
+	division: 0xe364,
+	tick:     0xffffffff,
+	expect:   1433087*time.Second + 420765000,
+}}
+
+func TestSMPTETickTimes(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range smpteTickTimesTests {
+		c.Run(test.testName, func(c *qt.C) {
+			f := &midiFile{
+				division: test.division,
+			}
+			c.Assert(f.tickTimes().time(test.tick), qt.Equals, test.expect)
+		})
+	}
+}
+
+// midiData returns the data for a MIDI file of the given format
+// holding the given tracks.
+func midiData(format uint16, division uint16, tracks ...[]byte) []byte {
+	hdr := make([]byte, 6)
+	binary.BigEndian.PutUint16(hdr[0:], format)
+	binary.BigEndian.PutUint16(hdr[2:], uint16(len(tracks)))
+	binary.BigEndian.PutUint16(hdr[4:], division)
+	data := chunk("MThd", hdr)
+	for _, track := range tracks {
+		data = append(data, chunk("MTrk", track)...)
+	}
+	return data
+}
+
+func chunk(id string, data []byte) []byte {
+	buf := make([]byte, 8, 8+len(data))
+	copy(buf, id)
+	binary.BigEndian.PutUint32(buf[4:], uint32(len(data)))
+	return append(buf, data...)
+}
+
+// strikes returns the actions for a set of solenoid strikes,
+// each of the given duration. The arguments are in pairs:
+// the time of the strike followed by its channel.
+func strikes(d time.Duration, args ...time.Duration) []Action {
+	var actions []Action
+	for i := 0; i < len(args); i += 2 {
+		actions = append(actions, Action{
+			Chan: uint8(args[i+1]),
+			On:   true,
+			When: args[i],
+		}, Action{
+			Chan: uint8(args[i+1]),
+			On:   false,
+			When: args[i] + d,
+		})
+	}
+	sort.Stable(actionsByTime(actions))
+	return actions
+}
@@ -52,6 +52,34 @@ func ActionsForTune(chanCount int, data []byte, solenoidDuration time.Duration)
 	return actions
 }
 
+// RestChan holds the channel used by TuneForActions for padding
+// entries when the delay between activations is too long to fit
+// in two bytes. ActionsForTune ignores it as out of range.
+const RestChan = 0xff
+
+// TuneForActions returns the actions in the format read by
+// ActionsForTune. Only the activations in actions are
+// encoded; the deactivations are implied by the solenoid duration
+// passed to ActionsForTune. Times are rounded to the nearest
+// millisecond. The actions must be sorted in time order.
+func TuneForActions(actions []Action) []byte {
+	var data []byte
+	var prev time.Duration
+	for _, a := range actions {
+		if !a.On {
+			continue
+		}
+		when := a.When.Round(time.Millisecond)
+		delay := (when - prev) / time.Millisecond
+		for ; delay > 0xffff; delay -= 0xffff {
+			data = append(data, 0xff, 0xff, RestChan)
+		}
+		data = append(data, byte(delay>>8), byte(delay), a.Chan)
+		prev = when
+	}
+	return data
+}
+
 type actionsByTime []Action
 
 func (s actionsByTime) Less(i, j int) bool {
@@ -18,7 +18,7 @@ var actionsForTuneTests = []struct {
 	chanCount:        4,
 	solenoidDuration: time.Millisecond,
 	data: []byte{
-		2, 0, 5,
+		0, 5, 2,
 	},
 	expect: []Action{{
 		Chan: 2,
@@ -34,9 +34,9 @@ var actionsForTuneTests = []struct {
 	chanCount:        4,
 	solenoidDuration: time.Millisecond,
 	data: []byte{
-		2, 0, 5,
-		2, 0, 3,
-		3, 0, 2,
+		0, 5, 2,
+		0, 3, 2,
+		0, 2, 3,
 	},
 	expect: []Action{{
 		Chan: 2,
@@ -68,11 +68,11 @@ var actionsForTuneTests = []struct {
 	chanCount:        6,
 	solenoidDuration: time.Millisecond,
 	data: []byte{
-		2, 0, 5,
-		4, 0, 0,
-		2, 0, 3,
-		4, 0, 0,
-		3, 0, 2,
+		0, 5, 2,
+		0, 0, 4,
+		0, 3, 2,
+		0, 0, 4,
+		0, 2, 3,
 	},
 	expect: []Action{{
 		Chan: 2,
@@ -119,10 +119,61 @@ var actionsForTuneTests = []struct {
 
 func TestActionsForTune(t *testing.T) {
 	c := qt.New(t)
-	for _, test := range sequenceForTuneTests {
+	for _, test := range actionsForTuneTests {
 		c.Run(test.testName, func(c *qt.C) {
-			actions := SequenceForTune(test.chanCount, test.data, test.solenoidDuration)
+			actions := ActionsForTune(test.chanCount, test.data, test.solenoidDuration)
 			c.Assert(actions, qt.DeepEquals, test.expect)
 		})
 	}
 }
+
+func TestTuneForActions(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range actionsForTuneTests {
+		c.Run(test.testName, func(c *qt.C) {
+			data := TuneForActions(test.expect)
+			c.Assert(data, qt.DeepEquals, test.data)
+		})
+	}
+}
+
+func TestTuneForActionsLongDelay(t *testing.T) {
+	c := qt.New(t)
+	actions := []Action{{
+		Chan: 1,
+		On:   true,
+		When: 500 * time.Microsecond,
+	}, {
+		Chan: 1,
+		On:   false,
+		When: 2 * time.Millisecond,
+	}, {
+		Chan: 2,
+		On:   true,
+		When: 140 * time.Second,
+	}}
+	data := TuneForActions(actions)
+	c.Assert(data, qt.DeepEquals, []byte{
+		0, 1, 1,
+		0xff, 0xff, 0xff,
+		0xff, 0xff, 0xff,
+		0x22, 0xe1, 2,
+	})
+	c.Assert(ActionsForTune(24, data, time.Millisecond), qt.DeepEquals, []Action{{
+		Chan: 1,
+		On:   true,
+		When: time.Millisecond,
+	}, {
+		Chan: 1,
+		On:   false,
+		When: 2 * time.Millisecond,
+	}, {
+		Chan: 2,
+		On:   true,
+		When: 140 * time.Second,
+	}, {
+		Chan: 2,
+		On:   false,
+		When: 140*time.Second + time.Millisecond,
+	}})
+}
@@ -0,0 +1,253 @@
+package sequence
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultTempo holds the tempo, in beats per minute,
+// used by ParseTune when no tempo has been specified.
+const DefaultTempo = 120
+
+// ParseError is the error returned by ParseTune when
+// the text is invalid.
+type ParseError struct {
+	// Line and Col hold the position of the error.
+	// Both are 1-based; Col counts characters.
+	Line, Col int
+	Msg       string
+}
+
+// Error implements the error interface.
+func (e *ParseError) Error() string {
+	return strconv.Itoa(e.Line) + ":" + strconv.Itoa(e.Col) + ": " + e.Msg
+}
+
+// ParseTune parses a tune written in a compact text notation and
+// returns the corresponding actions, each note activating a channel
+// for solenoidDuration. The returned actions will be sorted in time order.
+//
+// The text consists of whitespace-separated items, played in turn:
+//
+//	G1      a note: a letter from A to G, an optional accidental
+//	        (# for sharp or b for flat) and an octave number.
+//	        C1 is channel 0, C#1 channel 1 and so on, so B2 is channel 23.
+//	r       a rest.
+//	C1+E1   a chord: notes joined with + are played together.
+//	tempo N sets the tempo to N beats per minute.
+//	|       a bar line, which is ignored.
+//
+// By default notes, chords and rests last for one beat; a duration can
+// be specified in beats by adding a colon followed by a whole
+// number or a fraction, so G1:1/2 is half a beat, and C2:3/2 is
+// one and a half beats. Text from // to the end of a line is ignored.
+//
+// For example, this is the first line of "Happy Birthday":
+//
+//	tempo 80
+//	G1:3/4 G1:1/4 | A1 G1 C2 | B1:2
+//
+// It is an error for a note to refer to a channel outside
+// the range [0, chanCount).
+func ParseTune(chanCount int, text string, solenoidDuration time.Duration) ([]Action, error) {
+	p := &tuneParser{
+		chanCount:        chanCount,
+		solenoidDuration: solenoidDuration,
+		beat:             time.Minute / DefaultTempo,
+	}
+	for i, line := range strings.Split(text, "\n") {
+		if err := p.parseLine(i+1, line); err != nil {
+			return nil, err
+		}
+	}
+	if p.needTempo {
+		return nil, parseError(p.tempoPos, "missing tempo value")
+	}
+	sort.Stable(actionsByTime(p.actions))
+	return p.actions, nil
+}
+
+type tuneParser struct {
+	chanCount        int
+	solenoidDuration time.Duration
+
+	actions []Action
+	// now holds the time of the next item.
+	now time.Duration
+	// beat holds the length of a beat at the current tempo.
+	beat time.Duration
+
+	// needTempo holds whether a tempo keyword has been
+	// seen without a value, and tempoPos holds its position.
+	needTempo bool
+	tempoPos  pos
+}
+
+type pos struct {
+	line, col int
+}
+
+// token holds a whitespace-separated word and its position.
+type token struct {
+	pos
+	text string
+}
+
+func (p *tuneParser) parseLine(lineNum int, line string) error {
+	if i := strings.Index(line, "//"); i >= 0 {
+		line = line[:i]
+	}
+	for _, tok := range tokens(lineNum, line) {
+		if p.needTempo {
+			p.needTempo = false
+			tempo, err := strconv.ParseFloat(tok.text, 64)
+			if err != nil || tempo <= 0 {
+				return parseError(tok.pos, "invalid tempo "+strconv.Quote(tok.text))
+			}
+			p.beat = time.Duration(float64(time.Minute) / tempo)
+			continue
+		}
+		switch tok.text {
+		case "tempo":
+			p.needTempo = true
+			p.tempoPos = tok.pos
+		case "|":
+		default:
+			if err := p.parseItem(tok); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseItem parses a note, chord or rest with an optional duration.
+func (p *tuneParser) parseItem(tok token) error {
+	notes := tok.text
+	duration := p.beat
+	if i := strings.IndexByte(notes, ':'); i >= 0 {
+		durText := notes[i+1:]
+		notes = notes[:i]
+		num, den, ok := parseFraction(durText)
+		if !ok {
+			durPos := tok.pos
+			durPos.col += utf8.RuneCountInString(notes) + 1
+			return parseError(durPos, "invalid duration "+strconv.Quote(durText))
+		}
+		duration = p.beat * time.Duration(num) / time.Duration(den)
+	}
+	if notes == "r" {
+		p.now += duration
+		return nil
+	}
+	notePos := tok.pos
+	for _, note := range strings.Split(notes, "+") {
+		ch, ok := parseNote(note)
+		if !ok {
+			return parseError(notePos, "invalid note "+strconv.Quote(note))
+		}
+		if ch < 0 || ch >= p.chanCount {
+			return parseError(notePos, "note "+note+" is out of range")
+		}
+		p.actions = append(p.actions, Action{
+			Chan: uint8(ch),
+			On:   true,
+			When: p.now,
+		}, Action{
+			Chan: uint8(ch),
+			On:   false,
+			When: p.now + p.solenoidDuration,
+		})
+		notePos.col += utf8.RuneCountInString(note) + 1
+	}
+	p.now += duration
+	return nil
+}
+
+func parseError(pos pos, msg string) error {
+	return &ParseError{
+		Line: pos.line,
+		Col:  pos.col,
+		Msg:  msg,
+	}
+}
+
+// tokens splits line into whitespace-separated tokens.
+func tokens(lineNum int, line string) []token {
+	var toks []token
+	col := 0
+	start, startCol := -1, 0
+	for i, r := range line {
+		col++
+		isSpace := r == ' ' || r == '\t' || r == '\r'
+		switch {
+		case isSpace && start >= 0:
+			toks = append(toks, token{pos{lineNum, startCol}, line[start:i]})
+			start = -1
+		case !isSpace && start < 0:
+			start, startCol = i, col
+		}
+	}
+	if start >= 0 {
+		toks = append(toks, token{pos{lineNum, startCol}, line[start:]})
+	}
+	return toks
+}
+
+// noteOffsets holds the semitone offset of each
+// note letter from C.
+var noteOffsets = map[byte]int{
+	'C': 0,
+	'D': 2,
+	'E': 4,
+	'F': 5,
+	'G': 7,
+	'A': 9,
+	'B': 11,
+}
+
+// parseNote parses a note such as C#1 and returns its channel
+// number (which may be out of range).
+func parseNote(s string) (int, bool) {
+	if len(s) < 2 {
+		return 0, false
+	}
+	ch, ok := noteOffsets[s[0]]
+	if !ok {
+		return 0, false
+	}
+	s = s[1:]
+	switch s[0] {
+	case '#':
+		ch++
+		s = s[1:]
+	case 'b':
+		ch--
+		s = s[1:]
+	}
+	octave, err := strconv.Atoi(s)
+	if err != nil || s[0] == '+' || s[0] == '-' {
+		return 0, false
+	}
+	return ch + (octave-1)*12, true
+}
+
+// parseFraction parses a positive whole number or fraction.
+func parseFraction(s string) (num, den int, ok bool) {
+	numText, denText := s, "1"
+	if i := strings.IndexByte(s, '/'); i >= 0 {
+		numText, denText = s[:i], s[i+1:]
+	}
+	num, err := strconv.Atoi(numText)
+	if err != nil || num <= 0 {
+		return 0, 0, false
+	}
+	den, err = strconv.Atoi(denText)
+	if err != nil || den <= 0 {
+		return 0, 0, false
+	}
+	return num, den, true
+}
@@ -0,0 +1,131 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+const ms = time.Millisecond
+
+var parseTuneTests = []struct {
+	testName    string
+	text        string
+	expect      []Action
+	expectError string
+}{{
+	testName: "single-note",
+	text:     "G1",
+	expect:   strikes(10*ms, 0, 7),
+}, {
+	testName: "note-names",
+	text:     "C1 C#1 Db1 E1 Eb2 B2",
+	expect: strikes(10*ms,
+		0, 0,
+		500*ms, 1,
+		1000*ms, 1,
+		1500*ms, 4,
+		2000*ms, 15,
+		2500*ms, 23,
+	),
+}, {
+	testName: "durations",
+	text:     "C1:1/2 D1:2 E1:3/4 F1",
+	expect: strikes(10*ms,
+		0, 0,
+		250*ms, 2,
+		1250*ms, 4,
+		1625*ms, 5,
+	),
+}, {
+	testName: "tempo",
+	text: `
+		C1
+		tempo 60
+		D1 E1
+		tempo
+			240 F1
+	`,
+	expect: strikes(10*ms,
+		0, 0,
+		500*ms, 2,
+		1500*ms, 4,
+		2500*ms, 5,
+	),
+}, {
+	testName: "rests-chords-and-bars",
+	text:     "C1+E1+G1:2 | r:1/2 r D1 | C2+C1",
+	expect: strikes(10*ms,
+		0, 0,
+		0, 4,
+		0, 7,
+		1750*ms, 2,
+		2250*ms, 12,
+		2250*ms, 0,
+	),
+}, {
+	testName: "comments",
+	text: `
+		// Happy Birthday.
+		C1 // The first note.
+		D1
+	`,
+	expect: strikes(10*ms,
+		0, 0,
+		500*ms, 2,
+	),
+}, {
+	testName:    "invalid-note",
+	text:        "C1\n\tC1 H1",
+	expectError: `2:5: invalid note "H1"`,
+}, {
+	testName:    "missing-octave",
+	text:        "C#",
+	expectError: `1:1: invalid note "C#"`,
+}, {
+	testName:    "invalid-chord-note",
+	text:        "C1+E1+X1:2",
+	expectError: `1:7: invalid note "X1"`,
+}, {
+	testName:    "out-of-range",
+	text:        "C1 C3",
+	expectError: `1:4: note C3 is out of range`,
+}, {
+	testName:    "flat-out-of-range",
+	text:        "Cb1",
+	expectError: `1:1: note Cb1 is out of range`,
+}, {
+	testName:    "invalid-duration",
+	text:        "C1 D1:1/0",
+	expectError: `1:7: invalid duration "1/0"`,
+}, {
+	testName:    "empty-duration",
+	text:        "C1:",
+	expectError: `1:4: invalid duration ""`,
+}, {
+	testName:    "invalid-tempo",
+	text:        "tempo fast",
+	expectError: `1:7: invalid tempo "fast"`,
+}, {
+	testName:    "missing-tempo",
+	text:        "C1\n  tempo",
+	expectError: `2:3: missing tempo value`,
+}}
+
+func TestParseTune(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseTuneTests {
+		c.Run(test.testName, func(c *qt.C) {
+			actions, err := ParseTune(24, test.text, 10*ms)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				_, ok := err.(*ParseError)
+				c.Assert(ok, qt.IsTrue)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(actions, qt.DeepEquals, test.expect)
+		})
+	}
+}
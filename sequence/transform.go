@@ -0,0 +1,176 @@
+package sequence
+
+import (
+	"sort"
+	"time"
+)
+
+// Transform represents a transformation of a sequence of actions
+// sorted in time order. It returns a new sorted sequence
+// and doesn't change the original.
+//
+// Most transforms work in terms of pulses: each action that turns
+// a channel on is paired with the next action that turns it off.
+// A transform moves or removes whole pulses, so the length of
+// time each solenoid is activated for stays the same.
+type Transform func([]Action) []Action
+
+// Compose returns a transform that applies each of the
+// given transforms in turn.
+func Compose(transforms ...Transform) Transform {
+	return func(actions []Action) []Action {
+		for _, t := range transforms {
+			actions = t(actions)
+		}
+		return actions
+	}
+}
+
+// Stretch returns a transform that multiplies the start time
+// of each pulse by the given factor, so a factor of 2 plays a tune
+// at half the speed and a factor of 0.5 plays it at double speed.
+// The factor must be positive.
+func Stretch(factor float64) Transform {
+	return pulseTransform(func(p pulse) (pulse, bool) {
+		p.start = time.Duration(float64(p.start) * factor)
+		return p, true
+	})
+}
+
+// Transpose returns a transform that moves each pulse up by the
+// given number of semitones (channels). Pulses that end up outside
+// the range [0, chanCount) are moved by octaves to fit into the
+// range, or removed if that's not possible.
+func Transpose(semitones int, chanCount int) Transform {
+	return pulseTransform(func(p pulse) (pulse, bool) {
+		ch := foldIntoRange(int(p.ch)+semitones, chanCount)
+		if ch < 0 || ch >= chanCount {
+			return p, false
+		}
+		p.ch = uint8(ch)
+		return p, true
+	})
+}
+
+// Remap returns a transform that moves pulses on each channel c to
+// channel mapping[c]. Pulses on channels that aren't in the mapping
+// or where mapping[c] is negative are removed.
+func Remap(mapping []int) Transform {
+	return pulseTransform(func(p pulse) (pulse, bool) {
+		if int(p.ch) >= len(mapping) || mapping[p.ch] < 0 {
+			return p, false
+		}
+		p.ch = uint8(mapping[p.ch])
+		return p, true
+	})
+}
+
+// Truncate returns a transform that removes all pulses
+// that start at or after the given time.
+func Truncate(max time.Duration) Transform {
+	return pulseTransform(func(p pulse) (pulse, bool) {
+		return p, p.start < max
+	})
+}
+
+// Reverse returns a transform that plays the pulses in the
+// reverse order. The last pulse is played at the start,
+// and the first pulse is played at the time the last
+// one was originally played.
+func Reverse() Transform {
+	return func(actions []Action) []Action {
+		pulses := toPulses(actions)
+		if len(pulses) == 0 {
+			return fromPulses(pulses)
+		}
+		end := pulses[len(pulses)-1].start
+		for i := range pulses {
+			pulses[i].start = end - pulses[i].start
+		}
+		return fromPulses(pulses)
+	}
+}
+
+// Merge returns all the given sequences played together.
+// Note that if pulses on the same channel overlap, the
+// solenoid will be turned off by the end of the first one.
+func Merge(seqs ...[]Action) []Action {
+	var actions []Action
+	for _, seq := range seqs {
+		actions = append(actions, seq...)
+	}
+	sort.Stable(actionsByTime(actions))
+	return actions
+}
+
+// pulse represents a channel being turned on and then off again.
+type pulse struct {
+	ch    uint8
+	start time.Duration
+	// duration holds how long the channel is on for.
+	// It's negative if the channel is never turned off.
+	duration time.Duration
+}
+
+// pulseTransform returns a transform that calls f for each
+// pulse, removing the pulse if f returns false.
+func pulseTransform(f func(p pulse) (pulse, bool)) Transform {
+	return func(actions []Action) []Action {
+		pulses := toPulses(actions)
+		j := 0
+		for _, p := range pulses {
+			if p, ok := f(p); ok {
+				pulses[j] = p
+				j++
+			}
+		}
+		return fromPulses(pulses[:j])
+	}
+}
+
+// toPulses returns the pulses in actions, in order of their start
+// time. Actions that turn off a channel that isn't on are ignored.
+func toPulses(actions []Action) []pulse {
+	var pulses []pulse
+	// on holds the index into pulses of the pulse
+	// that's currently on for each channel, plus one.
+	on := make(map[uint8]int)
+	for _, a := range actions {
+		switch {
+		case a.On:
+			pulses = append(pulses, pulse{
+				ch:       a.Chan,
+				start:    a.When,
+				duration: -1,
+			})
+			on[a.Chan] = len(pulses)
+		case on[a.Chan] > 0:
+			p := &pulses[on[a.Chan]-1]
+			p.duration = a.When - p.start
+			on[a.Chan] = 0
+		}
+	}
+	return pulses
+}
+
+// fromPulses returns the actions for the given pulses,
+// sorted in time order.
+func fromPulses(pulses []pulse) []Action {
+	actions := make([]Action, 0, len(pulses)*2)
+	for _, p := range pulses {
+		actions = append(actions, Action{
+			Chan: p.ch,
+			On:   true,
+			When: p.start,
+		})
+		if p.duration >= 0 {
+			actions = append(actions, Action{
+				Chan: p.ch,
+				On:   false,
+				When: p.start + p.duration,
+			})
+		}
+	}
+	sort.Stable(actionsByTime(actions))
+	return actions
+}
@@ -0,0 +1,177 @@
+package sequence
+
+import (
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var transformTests = []struct {
+	testName  string
+	transform Transform
+	actions   []Action
+	expect    []Action
+}{{
+	testName:  "stretch-slower",
+	transform: Stretch(2),
+	actions: strikes(10*ms,
+		0, 0,
+		100*ms, 1,
+		150*ms, 2,
+	),
+	// The pulse durations stay the same.
+	expect: strikes(10*ms,
+		0, 0,
+		200*ms, 1,
+		300*ms, 2,
+	),
+}, {
+	testName:  "stretch-faster",
+	transform: Stretch(0.5),
+	actions: strikes(100*ms,
+		0, 0,
+		100*ms, 1,
+		150*ms, 2,
+	),
+	expect: strikes(100*ms,
+		0, 0,
+		50*ms, 1,
+		75*ms, 2,
+	),
+}, {
+	testName:  "transpose-up",
+	transform: Transpose(5, 24),
+	actions: strikes(10*ms,
+		0, 0,
+		100*ms, 18,
+		200*ms, 20,
+	),
+	// Channel 25 is out of range so it's moved down an octave.
+	expect: strikes(10*ms,
+		0, 5,
+		100*ms, 23,
+		200*ms, 13,
+	),
+}, {
+	testName:  "transpose-down",
+	transform: Transpose(-3, 24),
+	actions: strikes(10*ms,
+		0, 2,
+		100*ms, 3,
+	),
+	expect: strikes(10*ms,
+		0, 11,
+		100*ms, 0,
+	),
+}, {
+	testName:  "transpose-drops-unplayable-notes",
+	transform: Transpose(2, 6),
+	actions: strikes(10*ms,
+		0, 0,
+		100*ms, 4,
+	),
+	expect: strikes(10*ms,
+		0, 2,
+	),
+}, {
+	testName:  "remap",
+	transform: Remap([]int{3, -1, 0}),
+	actions: strikes(10*ms,
+		0, 0,
+		100*ms, 1,
+		200*ms, 2,
+		300*ms, 3,
+	),
+	expect: strikes(10*ms,
+		0, 3,
+		200*ms, 0,
+	),
+}, {
+	testName:  "truncate",
+	transform: Truncate(200 * ms),
+	actions: strikes(50*ms,
+		0, 0,
+		199*ms, 1,
+		200*ms, 2,
+	),
+	// The pulse that's already started is allowed to finish.
+	expect: strikes(50*ms,
+		0, 0,
+		199*ms, 1,
+	),
+}, {
+	testName:  "reverse",
+	transform: Reverse(),
+	actions: strikes(10*ms,
+		100*ms, 0,
+		150*ms, 1,
+		400*ms, 2,
+	),
+	expect: strikes(10*ms,
+		0, 2,
+		250*ms, 1,
+		300*ms, 0,
+	),
+}, {
+	testName:  "reverse-empty",
+	transform: Reverse(),
+	expect:    []Action{},
+}, {
+	testName:  "compose",
+	transform: Compose(Truncate(150*ms), Stretch(2), Transpose(12, 24)),
+	actions: strikes(10*ms,
+		0, 0,
+		100*ms, 1,
+		200*ms, 2,
+	),
+	expect: strikes(10*ms,
+		0, 12,
+		200*ms, 13,
+	),
+}, {
+	testName:  "unpaired-actions",
+	transform: Stretch(2),
+	actions: []Action{{
+		Chan: 1,
+		On:   false,
+		When: 0,
+	}, {
+		Chan: 2,
+		On:   true,
+		When: 10 * ms,
+	}},
+	// The off action without an on action is dropped,
+	// and the on action without an off action is kept.
+	expect: []Action{{
+		Chan: 2,
+		On:   true,
+		When: 20 * ms,
+	}},
+}}
+
+func TestTransform(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range transformTests {
+		c.Run(test.testName, func(c *qt.C) {
+			orig := append([]Action(nil), test.actions...)
+			got := test.transform(test.actions)
+			c.Assert(got, qt.DeepEquals, test.expect)
+			// Check that the original actions haven't been changed.
+			c.Assert(test.actions, qt.DeepEquals, orig)
+		})
+	}
+}
+
+func TestMerge(t *testing.T) {
+	c := qt.New(t)
+	got := Merge(
+		strikes(10*ms, 0, 0, 100*ms, 1),
+		strikes(10*ms, 50*ms, 2),
+		nil,
+	)
+	c.Assert(got, qt.DeepEquals, strikes(10*ms,
+		0, 0,
+		50*ms, 2,
+		100*ms, 1,
+	))
+}
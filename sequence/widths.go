@@ -0,0 +1,125 @@
+package sequence
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Widths holds the length of time to activate each channel for
+// when it's struck. Different chime bars can need different
+// pulse widths to sound right.
+type Widths struct {
+	// Default holds the width used for channels
+	// that have no width of their own.
+	Default time.Duration
+
+	// Chans holds the width for each channel, indexed
+	// by channel number. Zero entries, and channels beyond
+	// the end of the slice, use Default.
+	Chans []time.Duration
+}
+
+// For returns the width for the given channel.
+func (w Widths) For(ch uint8) time.Duration {
+	if int(ch) < len(w.Chans) && w.Chans[ch] != 0 {
+		return w.Chans[ch]
+	}
+	return w.Default
+}
+
+// String returns the widths in the form read by ParseWidths.
+func (w Widths) String() string {
+	var buf strings.Builder
+	buf.WriteString(w.Default.String())
+	for ch, d := range w.Chans {
+		if d != 0 {
+			buf.WriteString(" " + strconv.Itoa(ch) + "=" + d.String())
+		}
+	}
+	return buf.String()
+}
+
+// ParseWidths parses a set of widths in the form returned by
+// Widths.String: space-separated items, each of which is either
+// a duration, setting the default width, or CHAN=DURATION,
+// setting the width of a single channel. For example:
+//
+//	200ms 0=150ms 7=250ms
+//
+// The default width is left as zero if it isn't specified.
+// It is an error for a channel to be outside the range
+// [0, chanCount) or for a width not to be positive.
+func ParseWidths(chanCount int, text string) (Widths, error) {
+	var w Widths
+	for _, item := range strings.Fields(text) {
+		i := strings.Index(item, "=")
+		if i == -1 {
+			d, err := parseWidth(item)
+			if err != nil {
+				return Widths{}, err
+			}
+			w.Default = d
+			continue
+		}
+		ch, err := strconv.Atoi(item[:i])
+		if err != nil || ch < 0 || ch >= chanCount {
+			return Widths{}, errors.New("invalid channel " + strconv.Quote(item[:i]))
+		}
+		d, err := parseWidth(item[i+1:])
+		if err != nil {
+			return Widths{}, err
+		}
+		if ch >= len(w.Chans) {
+			w.Chans = append(w.Chans, make([]time.Duration, ch+1-len(w.Chans))...)
+		}
+		w.Chans[ch] = d
+	}
+	return w, nil
+}
+
+func parseWidth(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, errors.New("invalid width " + strconv.Quote(s))
+	}
+	return d, nil
+}
+
+// Resize returns a transform that changes the length of each
+// pulse to the width for its channel, leaving its start time
+// unchanged. Pulses that are never turned off are given an end too.
+func Resize(w Widths) Transform {
+	return pulseTransform(func(p pulse) (pulse, bool) {
+		p.duration = w.For(p.ch)
+		return p, true
+	})
+}
+
+// Calibration returns a sequence that strikes each of the given
+// channels in turn, once for each of the given widths, with
+// each strike starting interval after the previous one.
+// It's useful for finding out the width that sounds
+// best on each channel.
+func Calibration(chans []uint8, widths []time.Duration, interval time.Duration) []Action {
+	actions := make([]Action, 0, len(chans)*len(widths)*2)
+	var now time.Duration
+	for _, ch := range chans {
+		for _, d := range widths {
+			actions = append(actions, Action{
+				Chan: ch,
+				On:   true,
+				When: now,
+			}, Action{
+				Chan: ch,
+				On:   false,
+				When: now + d,
+			})
+			now += interval
+		}
+	}
+	sort.Stable(actionsByTime(actions))
+	return actions
+}
@@ -0,0 +1,117 @@
+package sequence
+
+import (
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+)
+
+var parseWidthsTests = []struct {
+	testName    string
+	text        string
+	expect      Widths
+	expectError string
+}{{
+	testName: "empty",
+	text:     "",
+}, {
+	testName: "default-only",
+	text:     "200ms",
+	expect: Widths{
+		Default: 200 * ms,
+	},
+}, {
+	testName: "channels",
+	text:     "3=150ms  200ms 0=1s",
+	expect: Widths{
+		Default: 200 * ms,
+		Chans:   []time.Duration{time.Second, 0, 0, 150 * ms},
+	},
+}, {
+	testName:    "channel-out-of-range",
+	text:        "24=100ms",
+	expectError: `invalid channel "24"`,
+}, {
+	testName:    "invalid-channel",
+	text:        "x=100ms",
+	expectError: `invalid channel "x"`,
+}, {
+	testName:    "invalid-width",
+	text:        "1=foo",
+	expectError: `invalid width "foo"`,
+}, {
+	testName:    "zero-width",
+	text:        "0s",
+	expectError: `invalid width "0s"`,
+}}
+
+func TestParseWidths(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parseWidthsTests {
+		c.Run(test.testName, func(c *qt.C) {
+			w, err := ParseWidths(24, test.text)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				return
+			}
+			c.Assert(err, qt.IsNil)
+			c.Assert(w, qt.DeepEquals, test.expect)
+		})
+	}
+}
+
+func TestWidthsString(t *testing.T) {
+	c := qt.New(t)
+	w := Widths{
+		Default: 200 * ms,
+		Chans:   []time.Duration{150 * ms, 0, 1500 * ms},
+	}
+	c.Assert(w.String(), qt.Equals, "200ms 0=150ms 2=1.5s")
+	c.Assert(w.For(0), qt.Equals, 150*ms)
+	c.Assert(w.For(1), qt.Equals, 200*ms)
+	c.Assert(w.For(5), qt.Equals, 200*ms)
+
+	w1, err := ParseWidths(24, w.String())
+	c.Assert(err, qt.IsNil)
+	c.Assert(w1, qt.DeepEquals, w)
+}
+
+func TestResize(t *testing.T) {
+	c := qt.New(t)
+	w := Widths{
+		Default: 100 * ms,
+		Chans:   []time.Duration{0, 300 * ms},
+	}
+	got := Resize(w)([]Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 1, On: true, When: 0},
+		{Chan: 0, On: false, When: 10 * ms},
+		{Chan: 1, On: false, When: 10 * ms},
+		// A pulse that's never turned off.
+		{Chan: 2, On: true, When: 50 * ms},
+	})
+	c.Assert(got, qt.DeepEquals, []Action{
+		{Chan: 0, On: true, When: 0},
+		{Chan: 1, On: true, When: 0},
+		{Chan: 2, On: true, When: 50 * ms},
+		{Chan: 0, On: false, When: 100 * ms},
+		{Chan: 2, On: false, When: 150 * ms},
+		{Chan: 1, On: false, When: 300 * ms},
+	})
+}
+
+func TestCalibration(t *testing.T) {
+	c := qt.New(t)
+	got := Calibration([]uint8{3, 5}, []time.Duration{50 * ms, 100 * ms}, time.Second)
+	c.Assert(got, qt.DeepEquals, []Action{
+		{Chan: 3, On: true, When: 0},
+		{Chan: 3, On: false, When: 50 * ms},
+		{Chan: 3, On: true, When: time.Second},
+		{Chan: 3, On: false, When: 1100 * ms},
+		{Chan: 5, On: true, When: 2 * time.Second},
+		{Chan: 5, On: false, When: 2050 * ms},
+		{Chan: 5, On: true, When: 3 * time.Second},
+		{Chan: 5, On: false, When: 3100 * ms},
+	})
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rogpeppe/doorbell/schedule"
+	"github.com/rogpeppe/doorbell/sequence"
+	"github.com/rogpeppe/doorbell/tunestore"
+)
+
+// minTempo and maxTempo hold the allowed range
+// of the tempo setting.
+const (
+	minTempo = 0.25
+	maxTempo = 4
+)
+
+// maxPulseWidth holds the longest pulse width that
+// can be set, to avoid overheating the solenoids.
+const maxPulseWidth = time.Second
+
+// timeLayout holds the layout used for the time setting.
+const timeLayout = "2006-01-02 15:04:05"
+
+// settingsFile holds the file that the persistent
+// settings are saved to, one "key value" line each.
+const settingsFile = "/settings"
+
+// persistentSettings holds the keys of the settings that are
+// saved when they change. The time isn't saved because the
+// board's clock starts from zero when it boots.
+var persistentSettings = []string{
+	"long-press",
+	"silent",
+	"tempo",
+	"pulse-widths",
+	"quiet-hours",
+}
+
+// settings holds the doorbell settings that can be
+// changed from the console. It implements console.Config.
+type settings struct {
+	mu sync.Mutex
+	// longPress holds how long a button must be held
+	// to play a tune rather than the usual ding-dong.
+	longPress time.Duration
+	// silent holds whether the door buttons are silenced.
+	silent bool
+	// tempo holds the speed that tunes are played at
+	// relative to their normal speed.
+	tempo float64
+	// pulseWidths holds how long to activate
+	// each solenoid for when it's struck.
+	pulseWidths sequence.Widths
+	// quietHours holds the do-not-disturb schedule.
+	quietHours *schedule.Schedule
+	// clockOffset holds the difference between the wall clock
+	// time and time.Now. The board's clock starts from zero
+	// when it boots, so the time needs to be set from the
+	// console for the quiet hours to work.
+	clockOffset time.Duration
+	// timeSet holds whether the time has been set.
+	// Until it is, the quiet hours are ignored.
+	timeSet bool
+	// fs holds the filesystem that the settings are
+	// saved to. It's nil if they aren't saved.
+	fs tunestore.FS
+}
+
+func newSettings() *settings {
+	return &settings{
+		longPress: 750 * time.Millisecond,
+		tempo:     1,
+		pulseWidths: sequence.Widths{
+			Default: solenoidDuration,
+		},
+		quietHours: &schedule.Schedule{},
+	}
+}
+
+// Keys implements console.Config.Keys.
+func (s *settings) Keys() []string {
+	return []string{
+		"long-press",
+		"silent",
+		"tempo",
+		"pulse-widths",
+		"quiet-hours",
+		"time",
+	}
+}
+
+// Get implements console.Config.Get.
+func (s *settings) Get(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.getLocked(key)
+}
+
+// getLocked is the internal version of Get.
+// It must be called with s.mu held.
+func (s *settings) getLocked(key string) (string, error) {
+	switch key {
+	case "long-press":
+		return s.longPress.String(), nil
+	case "silent":
+		return strconv.FormatBool(s.silent), nil
+	case "tempo":
+		return strconv.FormatFloat(s.tempo, 'g', -1, 64), nil
+	case "pulse-widths":
+		return s.pulseWidths.String(), nil
+	case "quiet-hours":
+		return s.quietHours.String(), nil
+	case "time":
+		t := s.nowLocked().Format(timeLayout)
+		if !s.timeSet {
+			t += " (not set; quiet hours are ignored)"
+		}
+		return t, nil
+	}
+	return "", errUnknownSetting(key)
+}
+
+// Set implements console.Config.Set.
+// Persistent settings are saved as soon as they're set.
+func (s *settings) Set(key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.setLocked(key, value); err != nil {
+		return err
+	}
+	if isPersistent(key) {
+		return s.saveLocked()
+	}
+	return nil
+}
+
+// setLocked is the internal version of Set.
+// It must be called with s.mu held.
+func (s *settings) setLocked(key, value string) error {
+	switch key {
+	case "long-press":
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return errors.New("invalid duration " + strconv.Quote(value))
+		}
+		s.longPress = d
+		return nil
+	case "silent":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return errors.New("invalid boolean " + strconv.Quote(value))
+		}
+		s.silent = b
+		return nil
+	case "tempo":
+		tempo, err := strconv.ParseFloat(value, 64)
+		if err != nil || tempo < minTempo || tempo > maxTempo {
+			return errors.New("invalid tempo " + strconv.Quote(value) + "; must be between 0.25 and 4")
+		}
+		s.tempo = tempo
+		return nil
+	case "pulse-widths":
+		w, err := sequence.ParseWidths(numSolenoids, value)
+		if err != nil {
+			return err
+		}
+		if w.Default == 0 {
+			w.Default = solenoidDuration
+		}
+		for _, d := range append(w.Chans, w.Default) {
+			if d > maxPulseWidth {
+				return errors.New("pulse width " + d.String() + " too long; must be at most " + maxPulseWidth.String())
+			}
+		}
+		s.pulseWidths = w
+		return nil
+	case "quiet-hours":
+		sched, err := schedule.Parse(value)
+		if err != nil {
+			return err
+		}
+		s.quietHours = sched
+		return nil
+	case "time":
+		t, err := time.Parse(timeLayout, value)
+		if err != nil {
+			return errors.New("invalid time " + strconv.Quote(value) + "; want YYYY-MM-DD HH:MM:SS")
+		}
+		s.clockOffset = t.Sub(time.Now())
+		s.timeSet = true
+		return nil
+	}
+	return errUnknownSetting(key)
+}
+
+// longPressTime returns the current long-press setting.
+func (s *settings) longPressTime() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.longPress
+}
+
+// tempoScale returns the current tempo setting.
+func (s *settings) tempoScale() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tempo
+}
+
+// widths returns the current pulse widths setting.
+func (s *settings) widths() sequence.Widths {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pulseWidths
+}
+
+// silentMode reports whether the door buttons are silenced.
+func (s *settings) silentMode() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.silent
+}
+
+// toggleSilent toggles silent mode and returns the new value.
+func (s *settings) toggleSilent() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silent = !s.silent
+	if err := s.saveLocked(); err != nil {
+		println("cannot save settings: ", err.Error())
+	}
+	return s.silent
+}
+
+// quietPolicy returns the do-not-disturb policy
+// that's currently in force. The quiet hours are
+// ignored until the time has been set.
+func (s *settings) quietPolicy() schedule.Policy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.timeSet {
+		return schedule.Policy{
+			Mode: schedule.Normal,
+		}
+	}
+	return s.quietHours.At(s.nowLocked())
+}
+
+// now returns the current wall clock time.
+func (s *settings) now() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nowLocked()
+}
+
+// nowLocked returns the current wall clock time.
+// It must be called with s.mu held.
+func (s *settings) nowLocked() time.Time {
+	return time.Now().Add(s.clockOffset)
+}
+
+// load reads any saved settings from fs and arranges
+// for subsequent changes to be saved there. It does
+// nothing if fs is nil.
+func (s *settings) load(fs tunestore.FS) error {
+	if fs == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fs = fs
+	data, err := fs.ReadFile(settingsFile)
+	if err == tunestore.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	// Carry on after an invalid setting so that
+	// one bad line doesn't lose all the others.
+	var firstErr error
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		key, value := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			key, value = line[:i], line[i+1:]
+		}
+		if !isPersistent(key) {
+			err = errUnknownSetting(key)
+		} else {
+			err = s.setLocked(key, value)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = errors.New("invalid saved setting: " + err.Error())
+		}
+	}
+	return firstErr
+}
+
+// saveLocked saves the persistent settings, writing to
+// a temporary file first so that the old settings are kept
+// if the write fails. It must be called with s.mu held.
+func (s *settings) saveLocked() error {
+	if s.fs == nil {
+		return nil
+	}
+	var buf []byte
+	for _, key := range persistentSettings {
+		value, err := s.getLocked(key)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, key+" "+value+"\n"...)
+	}
+	tmp := settingsFile + ".new"
+	if err := s.fs.WriteFile(tmp, buf); err != nil {
+		return errors.New("cannot save settings: " + err.Error())
+	}
+	if err := s.fs.Rename(tmp, settingsFile); err != nil {
+		return errors.New("cannot save settings: " + err.Error())
+	}
+	return nil
+}
+
+// isPersistent reports whether the setting
+// with the given key is saved.
+func isPersistent(key string) bool {
+	for _, k := range persistentSettings {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+func errUnknownSetting(key string) error {
+	return errors.New("unknown setting " + strconv.Quote(key))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/schedule"
+	"github.com/rogpeppe/doorbell/tunestore"
+)
+
+func TestSettingsSaved(t *testing.T) {
+	c := qt.New(t)
+	fs := tunestore.DirFS(c.TempDir())
+	cfg := newSettings()
+	c.Assert(cfg.load(fs), qt.IsNil)
+	c.Assert(cfg.Set("quiet-hours", "22:00-07:00 chime"), qt.IsNil)
+	c.Assert(cfg.Set("tempo", "1.5"), qt.IsNil)
+	c.Assert(cfg.Set("time", "2020-06-01 14:30:00"), qt.IsNil)
+	cfg.toggleSilent()
+
+	// The settings survive a reboot, except for the time.
+	cfg = newSettings()
+	c.Assert(cfg.load(fs), qt.IsNil)
+	for key, want := range map[string]string{
+		"quiet-hours": "22:00-07:00 chime",
+		"tempo":       "1.5",
+		"silent":      "true",
+		"long-press":  "750ms",
+	} {
+		got, err := cfg.Get(key)
+		c.Assert(err, qt.IsNil)
+		c.Check(got, qt.Equals, want, qt.Commentf("%s", key))
+	}
+	got, err := cfg.Get("time")
+	c.Assert(err, qt.IsNil)
+	c.Assert(got, qt.Matches, `.* \(not set; quiet hours are ignored\)`)
+}
+
+func TestPulseWidthsSaved(t *testing.T) {
+	c := qt.New(t)
+	fs := tunestore.DirFS(c.TempDir())
+	cfg := newSettings()
+	c.Assert(cfg.load(fs), qt.IsNil)
+	c.Assert(cfg.Set("pulse-widths", "150ms 0=120ms 7=250ms"), qt.IsNil)
+
+	cfg = newSettings()
+	c.Assert(cfg.load(fs), qt.IsNil)
+	w := cfg.widths()
+	c.Assert(w.Default, qt.Equals, 150*ms)
+	c.Assert(w.For(0), qt.Equals, 120*ms)
+	c.Assert(w.For(1), qt.Equals, 150*ms)
+	c.Assert(w.For(7), qt.Equals, 250*ms)
+}
+
+func TestSettingsLoadInvalid(t *testing.T) {
+	c := qt.New(t)
+	fs := tunestore.DirFS(c.TempDir())
+	err := fs.WriteFile(settingsFile, []byte("tempo 100\nfoo bar\nlong-press 2s\n"))
+	c.Assert(err, qt.IsNil)
+	cfg := newSettings()
+	err = cfg.load(fs)
+	c.Assert(err, qt.ErrorMatches, `invalid saved setting: invalid tempo "100"; must be between 0.25 and 4`)
+	// The valid settings are still loaded.
+	c.Assert(cfg.longPressTime().String(), qt.Equals, "2s")
+	c.Assert(cfg.tempoScale(), qt.Equals, 1.0)
+}
+
+func TestSettingsNotSavedWithoutFS(t *testing.T) {
+	c := qt.New(t)
+	cfg := newSettings()
+	c.Assert(cfg.load(nil), qt.IsNil)
+	c.Assert(cfg.Set("tempo", "2"), qt.IsNil)
+}
+
+func TestQuietHoursIgnoredUntilTimeSet(t *testing.T) {
+	c := qt.New(t)
+	cfg := newSettings()
+	// Quiet all the time.
+	c.Assert(cfg.Set("quiet-hours", "00:00-23:59 silent"), qt.IsNil)
+	c.Assert(cfg.quietPolicy().Mode, qt.Equals, schedule.Normal)
+
+	c.Assert(cfg.Set("time", "2020-06-01 14:30:00"), qt.IsNil)
+	c.Assert(cfg.quietPolicy().Mode, qt.Equals, schedule.Silent)
+	got, err := cfg.Get("time")
+	c.Assert(err, qt.IsNil)
+	c.Assert(strings.HasPrefix(got, "2020-06-01 14:30"), qt.IsTrue, qt.Commentf("%s", got))
+	c.Assert(got, qt.Not(qt.Matches), `.*not set.*`)
+}
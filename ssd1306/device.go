@@ -0,0 +1,157 @@
+// Package ssd1306 implements a driver for the SSD1306 OLED display
+// controller attached to a 128x64 pixel display over I2C. See
+// https://cdn-shop.adafruit.com/datasheets/SSD1306.pdf for details
+// of the interface.
+//
+// Drawing is done on a Frame in memory, which is then sent
+// to the display in one go with Device.Display.
+package ssd1306
+
+import (
+	"errors"
+)
+
+const (
+	// Width and Height hold the size of the display in pixels.
+	Width  = 128
+	Height = 64
+
+	// pageCount holds the number of 8-pixel-high
+	// pages that make up the display.
+	pageCount = Height / 8
+)
+
+// The SSD1306 interprets the first byte of an I2C write
+// (the "register" in terms of the I2C interface) as a control
+// byte that says whether the following bytes are commands
+// or display data.
+const (
+	controlCommand = 0x00
+	controlData    = 0x40
+)
+
+// Commands used by the driver. Some of these take argument bytes
+// that follow them in the command stream.
+const (
+	cmdSetContrast        = 0x81 // 1 arg: contrast
+	cmdDisplayAllOnResume = 0xA4
+	cmdNormalDisplay      = 0xA6
+	cmdDisplayOff         = 0xAE
+	cmdDisplayOn          = 0xAF
+	cmdSetDisplayOffset   = 0xD3 // 1 arg: offset
+	cmdSetComPins         = 0xDA // 1 arg: configuration
+	cmdSetVCOMDetect      = 0xDB // 1 arg: level
+	cmdSetClockDiv        = 0xD5 // 1 arg: ratio and frequency
+	cmdSetPrecharge       = 0xD9 // 1 arg: periods
+	cmdSetMultiplex       = 0xA8 // 1 arg: ratio
+	cmdSetStartLine       = 0x40 // low 6 bits hold the line
+	cmdMemoryMode         = 0x20 // 1 arg: addressing mode
+	cmdColumnAddr         = 0x21 // 2 args: start and end column
+	cmdPageAddr           = 0x22 // 2 args: start and end page
+	cmdComScanDec         = 0xC8
+	cmdSegRemap           = 0xA0 // low bit set maps column 127 to SEG0
+	cmdChargePump         = 0x8D // 1 arg: 0x14 enables the charge pump
+	cmdDeactivateScroll   = 0x2E
+)
+
+// initCommands holds the command sequence that initializes the display.
+// It's the sequence recommended by the datasheet for a 128x64
+// display using the internal charge pump.
+var initCommands = []byte{
+	cmdDisplayOff,
+	cmdSetClockDiv, 0x80,
+	cmdSetMultiplex, Height - 1,
+	cmdSetDisplayOffset, 0,
+	cmdSetStartLine | 0,
+	cmdChargePump, 0x14,
+	cmdMemoryMode, 0, // Horizontal addressing.
+	cmdSegRemap | 1,
+	cmdComScanDec,
+	cmdSetComPins, 0x12,
+	cmdSetContrast, 0xCF,
+	cmdSetPrecharge, 0xF1,
+	cmdSetVCOMDetect, 0x40,
+	cmdDisplayAllOnResume,
+	cmdNormalDisplay,
+	cmdDeactivateScroll,
+	cmdDisplayOn,
+}
+
+// ErrInvalidHWAddress is returned when the hardware address
+// of the device is not valid (the SA0 pin selects between
+// the only two possible addresses, 0x3c and 0x3d).
+var ErrInvalidHWAddress = errors.New("invalid hardware address")
+
+// I2C represents an I2C bus. It is notably implemented by the
+// machine.I2C type. It's the same as mcp23017.I2C, so the
+// same bus can be shared by both kinds of device.
+type I2C interface {
+	ReadRegister(addr uint8, r uint8, buf []byte) error
+	WriteRegister(addr uint8, r uint8, buf []byte) error
+}
+
+// Device represents an SSD1306 device.
+type Device struct {
+	bus  I2C
+	addr uint8
+}
+
+// NewI2C returns a new SSD1306 device at the given I2C address
+// on the given bus, and initializes the display so that it's
+// ready to show a frame.
+// It returns ErrInvalidHWAddress if the address isn't possible for the device.
+func NewI2C(bus I2C, address uint8) (*Device, error) {
+	if address != 0x3c && address != 0x3d {
+		return nil, ErrInvalidHWAddress
+	}
+	d := &Device{
+		bus:  bus,
+		addr: address,
+	}
+	if err := d.command(initCommands...); err != nil {
+		return nil, errors.New("cannot initialize ssd1306 device at " + hex(address) + ": " + err.Error())
+	}
+	return d, nil
+}
+
+// Display sends the contents of the given frame to the display.
+func (d *Device) Display(f *Frame) error {
+	if err := d.command(
+		cmdColumnAddr, 0, Width-1,
+		cmdPageAddr, 0, pageCount-1,
+	); err != nil {
+		return err
+	}
+	// Send a page at a time so that the I2C
+	// implementation doesn't need a large buffer.
+	for page := 0; page < pageCount; page++ {
+		if err := d.bus.WriteRegister(d.addr, controlData, f.pix[page*Width:(page+1)*Width]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetContrast sets the contrast of the display,
+// from 0 (dimmest) to 255 (brightest).
+func (d *Device) SetContrast(contrast uint8) error {
+	return d.command(cmdSetContrast, contrast)
+}
+
+// SetOn turns the display on or off. The display
+// contents are retained while it's off.
+func (d *Device) SetOn(on bool) error {
+	if on {
+		return d.command(cmdDisplayOn)
+	}
+	return d.command(cmdDisplayOff)
+}
+
+func (d *Device) command(cmds ...byte) error {
+	return d.bus.WriteRegister(d.addr, controlCommand, cmds)
+}
+
+func hex(x uint8) string {
+	digits := "0123456789abcdef"
+	return "0x" + digits[x>>4:x>>4+1] + digits[x&0xf:x&0xf+1]
+}
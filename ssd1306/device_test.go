@@ -0,0 +1,67 @@
+package ssd1306
+
+import (
+	"errors"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestNewI2C(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c, 0x3c)
+	_, err := NewI2C(bus, 0x3c)
+	c.Assert(err, qt.IsNil)
+	c.Assert(bus.On, qt.IsTrue)
+	c.Assert(bus.Contrast, qt.Equals, uint8(0xcf))
+}
+
+func TestNewI2CInvalidAddress(t *testing.T) {
+	c := qt.New(t)
+	_, err := NewI2C(newBus(c, 0x3e), 0x3e)
+	c.Assert(err, qt.Equals, ErrInvalidHWAddress)
+}
+
+func TestNewI2CError(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c, 0x3d)
+	bus.Err = errors.New("bus error")
+	_, err := NewI2C(bus, 0x3d)
+	c.Assert(err, qt.ErrorMatches, `cannot initialize ssd1306 device at 0x3d: bus error`)
+}
+
+func TestDisplay(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c, 0x3c)
+	dev, err := NewI2C(bus, 0x3c)
+	c.Assert(err, qt.IsNil)
+
+	var f Frame
+	f.Set(0, 0, true)
+	f.Set(127, 63, true)
+	f.FillRect(10, 6, 3, 4, true)
+	// Leave the fake RAM address somewhere other than the origin
+	// to check that Display resets it.
+	bus.WriteRegister(0x3c, controlData, []byte{1, 2, 3})
+	err = dev.Display(&f)
+	c.Assert(err, qt.IsNil)
+	c.Assert(bus.RAM, qt.Equals, f.pix)
+	c.Assert(bus.RAM[0], qt.Equals, byte(0x01))
+	c.Assert(bus.RAM[7*Width+127], qt.Equals, byte(0x80))
+	// The rectangle spans pages 0 and 1.
+	c.Assert(bus.RAM[11], qt.Equals, byte(0xc0))
+	c.Assert(bus.RAM[Width+11], qt.Equals, byte(0x03))
+}
+
+func TestSetOnAndContrast(t *testing.T) {
+	c := qt.New(t)
+	bus := newBus(c, 0x3c)
+	dev, err := NewI2C(bus, 0x3c)
+	c.Assert(err, qt.IsNil)
+	c.Assert(dev.SetOn(false), qt.IsNil)
+	c.Assert(bus.On, qt.IsFalse)
+	c.Assert(dev.SetOn(true), qt.IsNil)
+	c.Assert(bus.On, qt.IsTrue)
+	c.Assert(dev.SetContrast(0x10), qt.IsNil)
+	c.Assert(bus.Contrast, qt.Equals, uint8(0x10))
+}
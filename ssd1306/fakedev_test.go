@@ -0,0 +1,117 @@
+package ssd1306
+
+import (
+	"errors"
+
+	qt "github.com/frankban/quicktest"
+)
+
+// fakeBus implements the I2C interface in memory for testing.
+// It emulates a single SSD1306 device, enough to check that the
+// driver puts the right data into the display RAM.
+type fakeBus struct {
+	c    *qt.C
+	addr uint8
+
+	// RAM holds the display RAM in the same layout as Frame.pix.
+	RAM [Width * pageCount]byte
+	// On holds whether the display has been turned on.
+	On bool
+	// Contrast holds the most recently set contrast.
+	Contrast uint8
+	// If Err is non-nil, it will be returned as the error from the
+	// I2C methods.
+	Err error
+
+	// colStart, colEnd, pageStart and pageEnd hold the
+	// current addressing window.
+	colStart, colEnd   int
+	pageStart, pageEnd int
+	// col and page hold the current RAM address.
+	col, page int
+}
+
+func newBus(c *qt.C, addr uint8) *fakeBus {
+	return &fakeBus{
+		c:       c,
+		addr:    addr,
+		colEnd:  Width - 1,
+		pageEnd: pageCount - 1,
+	}
+}
+
+// argCounts holds the number of argument bytes taken by
+// each command that has any.
+var argCounts = map[byte]int{
+	cmdSetContrast:      1,
+	cmdSetDisplayOffset: 1,
+	cmdSetComPins:       1,
+	cmdSetVCOMDetect:    1,
+	cmdSetClockDiv:      1,
+	cmdSetPrecharge:     1,
+	cmdSetMultiplex:     1,
+	cmdMemoryMode:       1,
+	cmdColumnAddr:       2,
+	cmdPageAddr:         2,
+	cmdChargePump:       1,
+}
+
+// ReadRegister implements I2C.ReadRegister.
+func (bus *fakeBus) ReadRegister(addr uint8, r uint8, buf []byte) error {
+	return errors.New("ssd1306 does not support reads over I2C")
+}
+
+// WriteRegister implements I2C.WriteRegister.
+func (bus *fakeBus) WriteRegister(addr uint8, r uint8, buf []byte) error {
+	bus.c.Assert(addr, qt.Equals, bus.addr)
+	if bus.Err != nil {
+		return bus.Err
+	}
+	switch r {
+	case controlCommand:
+		bus.commands(buf)
+	case controlData:
+		for _, b := range buf {
+			bus.RAM[bus.page*Width+bus.col] = b
+			bus.col++
+			if bus.col > bus.colEnd {
+				bus.col = bus.colStart
+				bus.page++
+				if bus.page > bus.pageEnd {
+					bus.page = bus.pageStart
+				}
+			}
+		}
+	default:
+		bus.c.Fatalf("unexpected control byte %#x", r)
+	}
+	return nil
+}
+
+func (bus *fakeBus) commands(buf []byte) {
+	for len(buf) > 0 {
+		cmd := buf[0]
+		n := argCounts[cmd]
+		if len(buf) < 1+n {
+			bus.c.Fatalf("command %#x is missing arguments", cmd)
+		}
+		args := buf[1 : 1+n]
+		buf = buf[1+n:]
+		switch cmd {
+		case cmdDisplayOn:
+			bus.On = true
+		case cmdDisplayOff:
+			bus.On = false
+		case cmdSetContrast:
+			bus.Contrast = args[0]
+		case cmdMemoryMode:
+			bus.c.Assert(args[0], qt.Equals, byte(0), qt.Commentf("only horizontal addressing is supported"))
+		case cmdColumnAddr:
+			bus.colStart, bus.colEnd = int(args[0]), int(args[1])
+			bus.col = bus.colStart
+		case cmdPageAddr:
+			bus.pageStart, bus.pageEnd = int(args[0]), int(args[1])
+			bus.page = bus.pageStart
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package ssd1306
+
+import (
+	"strings"
+)
+
+// Frame holds the contents of a display in memory.
+// The zero value is a blank frame.
+//
+// Coordinates have their origin at the top left of the display.
+// Drawing outside the bounds of the frame is silently ignored.
+type Frame struct {
+	// pix holds the pixels in the same layout that the
+	// display uses: each byte holds a vertical strip of 8 pixels
+	// with the topmost pixel in the least significant bit,
+	// and each page of 8 rows is held in Width consecutive bytes.
+	pix [Width * pageCount]byte
+}
+
+// Clear turns off all the pixels in the frame.
+func (f *Frame) Clear() {
+	f.pix = [Width * pageCount]byte{}
+}
+
+// Set sets the pixel at (x, y) on or off.
+func (f *Frame) Set(x, y int, on bool) {
+	if x < 0 || x >= Width || y < 0 || y >= Height {
+		return
+	}
+	i, bit := pixIndex(x, y)
+	if on {
+		f.pix[i] |= bit
+	} else {
+		f.pix[i] &^= bit
+	}
+}
+
+// Get reports whether the pixel at (x, y) is on.
+// It returns false for pixels outside the frame.
+func (f *Frame) Get(x, y int) bool {
+	if x < 0 || x >= Width || y < 0 || y >= Height {
+		return false
+	}
+	i, bit := pixIndex(x, y)
+	return f.pix[i]&bit != 0
+}
+
+func pixIndex(x, y int) (int, byte) {
+	return (y/8)*Width + x, 1 << (y % 8)
+}
+
+// FillRect sets all the pixels in the rectangle with
+// top left corner (x, y) and the given width and height.
+func (f *Frame) FillRect(x, y, width, height int, on bool) {
+	for py := y; py < y+height; py++ {
+		for px := x; px < x+width; px++ {
+			f.Set(px, py, on)
+		}
+	}
+}
+
+// Text draws the given text with its top left corner at (x, y)
+// and returns the x coordinate just after the last character.
+//
+// Characters are 5x7 pixels with a column of space after each one,
+// so each character takes up CharWidth by CharHeight pixels.
+// Only printable ASCII characters are supported; others
+// are drawn as a filled box.
+func (f *Frame) Text(x, y int, s string) int {
+	for i := 0; i < len(s); i++ {
+		glyph := glyphFor(s[i])
+		for col, bits := range glyph {
+			for row := 0; row < glyphHeight; row++ {
+				f.Set(x+col, y+row, bits&(1<<row) != 0)
+			}
+		}
+		x += CharWidth
+	}
+	return x
+}
+
+// String returns the frame as text, one line per row of pixels,
+// with "#" for each pixel that's on and "." for each pixel that's off.
+// It's useful for checking what's been drawn.
+func (f *Frame) String() string {
+	var buf strings.Builder
+	buf.Grow((Width + 1) * Height)
+	for y := 0; y < Height; y++ {
+		for x := 0; x < Width; x++ {
+			if f.Get(x, y) {
+				buf.WriteByte('#')
+			} else {
+				buf.WriteByte('.')
+			}
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
@@ -0,0 +1,84 @@
+package ssd1306
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestSetGet(t *testing.T) {
+	c := qt.New(t)
+	var f Frame
+	f.Set(3, 9, true)
+	c.Assert(f.Get(3, 9), qt.IsTrue)
+	c.Assert(f.Get(3, 8), qt.IsFalse)
+	f.Set(3, 9, false)
+	c.Assert(f.Get(3, 9), qt.IsFalse)
+
+	// Out of range pixels are ignored.
+	f.Set(-1, 0, true)
+	f.Set(Width, 0, true)
+	f.Set(0, Height, true)
+	c.Assert(f.pix, qt.Equals, [len(f.pix)]byte{})
+	c.Assert(f.Get(-1, 0), qt.IsFalse)
+}
+
+func TestClear(t *testing.T) {
+	c := qt.New(t)
+	var f Frame
+	f.FillRect(0, 0, Width, Height, true)
+	c.Assert(f.Get(Width-1, Height-1), qt.IsTrue)
+	f.Clear()
+	c.Assert(f.pix, qt.Equals, [len(f.pix)]byte{})
+}
+
+func TestText(t *testing.T) {
+	c := qt.New(t)
+	var f Frame
+	x := f.Text(1, 2, "Hi!\x01")
+	c.Assert(x, qt.Equals, 1+4*CharWidth)
+	c.Assert(topLeft(&f, 25, 10), qt.Equals, `
+.........................
+.........................
+.#...#...#.....#...#####.
+.#...#.........#...#####.
+.#...#..##.....#...#####.
+.#####...#.....#...#####.
+.#...#...#.....#...#####.
+.#...#...#.........#####.
+.#...#..###....#...#####.
+.........................
+`[1:])
+}
+
+func TestPNG(t *testing.T) {
+	c := qt.New(t)
+	var f Frame
+	f.Set(5, 6, true)
+	var buf bytes.Buffer
+	err := png.Encode(&buf, &f)
+	c.Assert(err, qt.IsNil)
+	img, err := png.Decode(&buf)
+	c.Assert(err, qt.IsNil)
+	c.Assert(img.Bounds().Dx(), qt.Equals, Width)
+	c.Assert(img.Bounds().Dy(), qt.Equals, Height)
+	r, _, _, _ := img.At(5, 6).RGBA()
+	c.Assert(r, qt.Equals, uint32(0xffff))
+	r, _, _, _ = img.At(6, 6).RGBA()
+	c.Assert(r, qt.Equals, uint32(0))
+}
+
+// topLeft returns the top left corner of the frame
+// as returned by String.
+func topLeft(f *Frame, width, height int) string {
+	lines := strings.Split(f.String(), "\n")
+	var buf strings.Builder
+	for _, line := range lines[:height] {
+		buf.WriteString(line[:width])
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
@@ -0,0 +1,34 @@
+// +build !tinygo
+
+package ssd1306
+
+import (
+	"image"
+	"image/color"
+)
+
+// The methods in this file make Frame implement image.Image
+// so that frames can be saved (for example with image/png)
+// when checking rendering on a host machine. They're not
+// built for TinyGo to avoid the code size.
+
+var _ image.Image = (*Frame)(nil)
+
+// ColorModel implements image.Image.ColorModel.
+func (f *Frame) ColorModel() color.Model {
+	return color.GrayModel
+}
+
+// Bounds implements image.Image.Bounds.
+func (f *Frame) Bounds() image.Rectangle {
+	return image.Rect(0, 0, Width, Height)
+}
+
+// At implements image.Image.At. Pixels that
+// are on are white; others are black.
+func (f *Frame) At(x, y int) color.Color {
+	if f.Get(x, y) {
+		return color.White
+	}
+	return color.Black
+}
@@ -0,0 +1,138 @@
+// Package status draws the doorbell status screens
+// shown on the SSD1306 displays.
+package status
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/rogpeppe/doorbell/sequence"
+	"github.com/rogpeppe/doorbell/ssd1306"
+)
+
+// Status holds the information shown on the status screens.
+type Status struct {
+	// Button holds the name of the door button that
+	// was most recently pressed, or "" if none has been.
+	Button string
+
+	// Tune holds the name of the tune that's playing,
+	// or "" if there isn't one.
+	Tune string
+
+	// Actions holds the actions being played.
+	Actions []sequence.Action
+
+	// Elapsed holds how far through Actions we are.
+	Elapsed time.Duration
+}
+
+// RollScale holds the amount of time represented
+// by each column of pixels in the piano roll.
+const RollScale = 25 * time.Millisecond
+
+// rollNow holds the column of the piano roll that
+// represents the current time. Columns to the left of it show
+// the recent past so that notes don't vanish as soon as they're struck.
+const rollNow = 8
+
+// DrawInfo draws a textual summary of the status into f.
+func DrawInfo(f *ssd1306.Frame, s Status) {
+	f.Clear()
+	y := 0
+	f.Text(0, y, "Doorbell")
+	y += ssd1306.CharHeight * 2
+	button := s.Button
+	if button == "" {
+		button = "-"
+	}
+	f.Text(0, y, "Button: "+button)
+	y += ssd1306.CharHeight
+	if s.Tune == "" {
+		f.Text(0, y, "Idle")
+		return
+	}
+	f.Text(0, y, "Playing:")
+	y += ssd1306.CharHeight
+	f.Text(0, y, s.Tune)
+	y += ssd1306.CharHeight * 2
+	total := duration(s.Actions)
+	f.Text(0, y, formatTime(s.Elapsed)+"/"+formatTime(total))
+	y += ssd1306.CharHeight
+	// Progress bar.
+	f.FillRect(0, y, ssd1306.Width, 1, true)
+	f.FillRect(0, y+ssd1306.CharHeight-2, ssd1306.Width, 1, true)
+	if total > 0 {
+		elapsed := s.Elapsed
+		if elapsed > total {
+			elapsed = total
+		}
+		w := int(int64(ssd1306.Width) * int64(elapsed) / int64(total))
+		f.FillRect(0, y+2, w, ssd1306.CharHeight-5, true)
+	}
+}
+
+// DrawRoll draws a piano roll of the given actions into f,
+// with time running from right to left so that notes scroll
+// towards a vertical line that represents the elapsed time.
+// Each channel is shown as a row, with channel 0 at the bottom,
+// and each note is shown as a bar for as long as
+// the channel is active.
+func DrawRoll(f *ssd1306.Frame, actions []sequence.Action, elapsed time.Duration, chanCount int) {
+	f.Clear()
+	if chanCount <= 0 {
+		return
+	}
+	rowHeight := ssd1306.Height / chanCount
+	if rowHeight < 1 {
+		rowHeight = 1
+	}
+	barHeight := rowHeight
+	if barHeight > 1 {
+		// Leave a gap between the rows.
+		barHeight--
+	}
+	f.FillRect(rollNow, 0, 1, ssd1306.Height, true)
+	start := elapsed - rollNow*RollScale
+	var onTime [256]time.Duration
+	for _, a := range actions {
+		if a.On {
+			onTime[a.Chan] = a.When
+			continue
+		}
+		on, off := onTime[a.Chan], a.When
+		if int(a.Chan) >= chanCount || off < start {
+			continue
+		}
+		x0 := int((on - start) / RollScale)
+		if x0 >= ssd1306.Width {
+			// Actions are in time order, so nothing
+			// else will be visible.
+			break
+		}
+		x1 := int((off - start + RollScale - 1) / RollScale)
+		if x1 <= x0 {
+			x1 = x0 + 1
+		}
+		y := ssd1306.Height - (int(a.Chan)+1)*rowHeight
+		f.FillRect(x0, y, x1-x0, barHeight, true)
+	}
+}
+
+// duration returns the time of the last action.
+func duration(actions []sequence.Action) time.Duration {
+	if len(actions) == 0 {
+		return 0
+	}
+	return actions[len(actions)-1].When
+}
+
+// formatTime formats d as minutes and seconds, for example "1:05".
+func formatTime(d time.Duration) string {
+	secs := int(d / time.Second)
+	s := strconv.Itoa(secs % 60)
+	if len(s) < 2 {
+		s = "0" + s
+	}
+	return strconv.Itoa(secs/60) + ":" + s
+}
@@ -0,0 +1,149 @@
+package status
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/sequence"
+	"github.com/rogpeppe/doorbell/ssd1306"
+)
+
+const ms = time.Millisecond
+
+var rollActions = []sequence.Action{
+	{Chan: 0, On: true, When: 0},
+	{Chan: 0, On: false, When: 100 * ms},
+	{Chan: 1, On: true, When: 200 * ms},
+	{Chan: 1, On: false, When: 250 * ms},
+	// Out of range channel.
+	{Chan: 40, On: true, When: 300 * ms},
+	{Chan: 40, On: false, When: 350 * ms},
+	// Too far in the future to be shown.
+	{Chan: 2, On: true, When: 10 * time.Second},
+	{Chan: 2, On: false, When: 11 * time.Second},
+}
+
+var drawRollTests = []struct {
+	testName string
+	elapsed  time.Duration
+	expect   string
+}{{
+	testName: "start",
+	elapsed:  0,
+	expect: `
+........#.......................
+........#.......................
+........#.......##..............
+........#.......................
+........####....................
+........#.......................
+`,
+}, {
+	testName: "scrolled",
+	elapsed:  150 * ms,
+	expect: `
+........#.......................
+........#.......................
+........#.##....................
+........#.......................
+..####..#.......................
+........#.......................
+`,
+}, {
+	testName: "finished",
+	elapsed:  time.Second,
+	expect: `
+........#.......................
+........#.......................
+........#.......................
+........#.......................
+........#.......................
+........#.......................
+`,
+}}
+
+func TestDrawRoll(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range drawRollTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var f ssd1306.Frame
+			DrawRoll(&f, rollActions, test.elapsed, 32)
+			c.Assert(region(&f, 0, ssd1306.Height-6, 32, 6), qt.Equals, test.expect[1:])
+			// Nothing else is drawn apart from the "now" line.
+			for y := 0; y < ssd1306.Height; y++ {
+				for x := 0; x < ssd1306.Width; x++ {
+					if x != rollNow && y < ssd1306.Height-6 {
+						c.Assert(f.Get(x, y), qt.IsFalse, qt.Commentf("x %d; y %d", x, y))
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDrawInfoIdle(t *testing.T) {
+	c := qt.New(t)
+	var f ssd1306.Frame
+	f.Set(100, 50, true)
+	DrawInfo(&f, Status{
+		Button: "red",
+	})
+	var expect ssd1306.Frame
+	expect.Text(0, 0, "Doorbell")
+	expect.Text(0, 16, "Button: red")
+	expect.Text(0, 24, "Idle")
+	c.Assert(f.String(), qt.Equals, expect.String())
+}
+
+func TestDrawInfoPlaying(t *testing.T) {
+	c := qt.New(t)
+	var f ssd1306.Frame
+	DrawInfo(&f, Status{
+		Tune: "ripple",
+		Actions: []sequence.Action{
+			{Chan: 0, On: true, When: 0},
+			{Chan: 0, On: false, When: 100 * time.Second},
+		},
+		Elapsed: 65 * time.Second,
+	})
+	var expect ssd1306.Frame
+	expect.Text(0, 0, "Doorbell")
+	expect.Text(0, 16, "Button: -")
+	expect.Text(0, 24, "Playing:")
+	expect.Text(0, 32, "ripple")
+	expect.Text(0, 48, "1:05/1:40")
+	c.Assert(region(&f, 0, 0, ssd1306.Width, 56), qt.Equals, region(&expect, 0, 0, ssd1306.Width, 56))
+	// The progress bar is 65% full.
+	c.Assert(region(&f, 80, 56, 8, 8), qt.Equals, `
+########
+........
+###.....
+###.....
+###.....
+........
+########
+........
+`[1:])
+}
+
+func TestFormatTime(t *testing.T) {
+	c := qt.New(t)
+	c.Assert(formatTime(0), qt.Equals, "0:00")
+	c.Assert(formatTime(9*time.Second+500*ms), qt.Equals, "0:09")
+	c.Assert(formatTime(2*time.Minute+30*time.Second), qt.Equals, "2:30")
+}
+
+// region returns the given rectangle of the frame
+// in the same form as Frame.String.
+func region(f *ssd1306.Frame, x, y, width, height int) string {
+	lines := strings.Split(f.String(), "\n")
+	var buf strings.Builder
+	for _, line := range lines[y : y+height] {
+		buf.WriteString(line[x : x+width])
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
@@ -0,0 +1,30 @@
+package timer
+
+import (
+	"time"
+)
+
+// Clock represents a source of time. It's used by Timer
+// so that tests can control the passing of time
+// (see FakeClock).
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Sleep sleeps for at least the given duration.
+	Sleep(d time.Duration)
+}
+
+// RealClock is a Clock that uses the system time.
+var RealClock Clock = realClock{}
+
+type realClock struct{}
+
+// Now implements Clock.Now by calling time.Now.
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep implements Clock.Sleep by calling time.Sleep.
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
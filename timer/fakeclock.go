@@ -0,0 +1,113 @@
+package timer
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock for use in tests. Its time only changes
+// when Advance is called.
+type FakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+	// sleepers holds the goroutines currently sleeping.
+	sleepers []fakeSleeper
+	// changed is closed and replaced when the
+	// set of sleepers changes.
+	changed chan struct{}
+}
+
+type fakeSleeper struct {
+	until time.Time
+	wake  chan struct{}
+}
+
+// NewFakeClock returns a FakeClock whose current
+// time is the given time.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+// Now implements Clock.Now.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Sleep implements Clock.Sleep by waiting until
+// the clock has been advanced by at least d.
+func (c *FakeClock) Sleep(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	wake := make(chan struct{})
+	c.sleepers = append(c.sleepers, fakeSleeper{
+		until: c.now.Add(d),
+		wake:  wake,
+	})
+	c.notify()
+	c.mu.Unlock()
+	<-wake
+}
+
+// Advance advances the clock by d, waking any
+// goroutines whose sleep has finished.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	// Wake sleepers in time order so that
+	// they're woken in a predictable order.
+	sort.SliceStable(c.sleepers, func(i, j int) bool {
+		return c.sleepers[i].until.Before(c.sleepers[j].until)
+	})
+	n := 0
+	for _, s := range c.sleepers {
+		if s.until.After(c.now) {
+			c.sleepers[n] = s
+			n++
+			continue
+		}
+		close(s.wake)
+	}
+	if n != len(c.sleepers) {
+		c.sleepers = c.sleepers[:n]
+		c.notify()
+	}
+}
+
+// Sleepers returns the number of goroutines
+// currently sleeping.
+func (c *FakeClock) Sleepers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.sleepers)
+}
+
+// WaitSleepers waits until at least n goroutines are sleeping.
+// This is useful to make sure that a goroutine has started
+// sleeping before calling Advance.
+func (c *FakeClock) WaitSleepers(n int) {
+	for {
+		c.mu.Lock()
+		count, changed := len(c.sleepers), c.changed
+		c.mu.Unlock()
+		if count >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// notify wakes up any WaitSleepers calls.
+// Called with c.mu held.
+func (c *FakeClock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
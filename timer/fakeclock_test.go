@@ -0,0 +1,44 @@
+package timer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	if got := clock.Now(); !got.Equal(epoch) {
+		t.Fatalf("unexpected time; got %v want %v", got, epoch)
+	}
+	// Sleeping for zero or negative durations doesn't block.
+	clock.Sleep(0)
+	clock.Sleep(-time.Second)
+
+	woken := make(chan time.Duration, 2)
+	for _, d := range []time.Duration{2 * time.Second, time.Second} {
+		d := d
+		go func() {
+			clock.Sleep(d)
+			woken <- d
+		}()
+	}
+	clock.WaitSleepers(2)
+	clock.Advance(999 * time.Millisecond)
+	if n := clock.Sleepers(); n != 2 {
+		t.Fatalf("unexpected sleeper count; got %d want 2", n)
+	}
+	clock.Advance(time.Millisecond)
+	if got, want := <-woken, time.Second; got != want {
+		t.Fatalf("unexpected wakeup; got %v want %v", got, want)
+	}
+	if n := clock.Sleepers(); n != 1 {
+		t.Fatalf("unexpected sleeper count; got %d want 1", n)
+	}
+	clock.Advance(5 * time.Second)
+	if got, want := <-woken, 2*time.Second; got != want {
+		t.Fatalf("unexpected wakeup; got %v want %v", got, want)
+	}
+	if got, want := clock.Now(), epoch.Add(6*time.Second); !got.Equal(want) {
+		t.Fatalf("unexpected time; got %v want %v", got, want)
+	}
+}
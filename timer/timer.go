@@ -19,6 +19,8 @@ type Timer struct {
 	C <-chan struct{}
 	// c holds a copy of C so that callers can't abuse it.
 	c chan struct{}
+	// clock holds the source of time for the timer.
+	clock Clock
 	// sleepc is used to send sleep requests to existing sleeper goroutines.
 	sleepc chan time.Time
 
@@ -34,21 +36,42 @@ type Timer struct {
 	// wakeTimes holds the times that the sleeping
 	// goroutines will wake, reverse-ordered.
 	wakeTimes []time.Time
+	// doneHook, if non-nil, is called with mu held when a
+	// sleeper goroutine finishes sleeping and has nothing
+	// more to wait for, so it becomes idle or exits.
+	// It's used by tests.
+	doneHook func()
 }
 
 // NewTimer returns a new stopped timer. It must be closed
 // with the Close method when done with, otherwise
 // it can leak goroutines.
 func NewTimer() *Timer {
+	return NewTimerWithClock(RealClock)
+}
+
+// NewTimerWithClock is like NewTimer except that the timer
+// uses the given clock rather than the system time.
+// If clock is nil, RealClock is used.
+func NewTimerWithClock(clock Clock) *Timer {
+	if clock == nil {
+		clock = RealClock
+	}
 	c := make(chan struct{}, 1)
 	t := &Timer{
 		C:      c,
 		c:      c,
+		clock:  clock,
 		sleepc: make(chan time.Time),
 	}
 	return t
 }
 
+// Clock returns the clock used by the timer.
+func (t *Timer) Clock() Clock {
+	return t.clock
+}
+
 // After resets the timer to d and returns t.C.
 // Note that unlike time.After, at most one goroutine
 // can use the channel at any one time.
@@ -65,7 +88,7 @@ const maxSleepTime = 500 * time.Millisecond
 // Reset starts the timer going, resetting any existing
 // timer expiration. After the given duration, a value will be sent on t.C.
 func (t *Timer) Reset(d time.Duration) {
-	expiry := time.Now().Add(d)
+	expiry := t.clock.Now().Add(d)
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.retract()
@@ -77,7 +100,7 @@ func (t *Timer) Reset(d time.Duration) {
 		return
 	}
 	// Don't sleep for longer than maxSleepTime.
-	now := time.Now()
+	now := t.clock.Now()
 	wakeup := expiry
 	if wakeup.Sub(now) > maxSleepTime {
 		wakeup = now.Add(maxSleepTime)
@@ -95,13 +118,16 @@ func (t *Timer) Reset(d time.Duration) {
 }
 
 func (t *Timer) sleeper(wakeup time.Time) {
-	sleep(time.Until(wakeup))
+	t.sleep(wakeup)
 	for {
 		t.mu.Lock()
 		t.removeWakeTime(wakeup)
 		wakeup = t.maybeSend()
 		isIdle := wakeup.IsZero()
 		if isIdle {
+			if t.doneHook != nil {
+				t.doneHook()
+			}
 			if t.idle >= maxIdle {
 				// Too many idle goroutines; stop this one.
 				t.mu.Unlock()
@@ -121,7 +147,7 @@ func (t *Timer) sleeper(wakeup time.Time) {
 				return
 			}
 		}
-		sleep(time.Until(wakeup))
+		t.sleep(wakeup)
 	}
 }
 
@@ -181,7 +207,7 @@ func (t *Timer) maybeSend() time.Time {
 		// The timer has stopped.
 		return expiry
 	}
-	now := time.Now()
+	now := t.clock.Now()
 	if now.Before(expiry) {
 		// The timer hasn't expired yet, either because the
 		// expiry time was changed to be later, or because the
@@ -215,11 +241,12 @@ func (t *Timer) firstWakeup() time.Time {
 	return t.wakeTimes[len(t.wakeTimes)-1]
 }
 
-// sleep works around a bug where sleeping for a negative
-// duration hangs up forever.
-// See https://github.com/tinygo-org/tinygo/issues/1268
-func sleep(dt time.Duration) {
-	if dt > 0 {
-		time.Sleep(dt)
+// sleep sleeps until the given time.
+func (t *Timer) sleep(wakeup time.Time) {
+	// Avoid sleeping for a negative duration, which
+	// hangs up forever with some TinyGo versions.
+	// See https://github.com/tinygo-org/tinygo/issues/1268
+	if dt := wakeup.Sub(t.clock.Now()); dt > 0 {
+		t.clock.Sleep(dt)
 	}
 }
@@ -5,26 +5,37 @@ import (
 	"time"
 )
 
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
 func TestSimpleTimer(t *testing.T) {
-	timer := NewTimer()
+	clock := NewFakeClock(epoch)
+	timer := NewTimerWithClock(clock)
 	defer timer.Close()
-	t0 := time.Now()
+	if timer.Clock() != clock {
+		t.Fatalf("unexpected clock")
+	}
 	timer.Reset(10 * time.Millisecond)
+	clock.WaitSleepers(1)
+	clock.Advance(9 * time.Millisecond)
+	assertNoReceive(t, timer)
+	clock.Advance(time.Millisecond)
 	<-timer.C
-	dt := time.Since(t0)
-	if got, want := dt, 10*time.Millisecond; got < want {
-		t.Fatalf("timer fired too soon; got %v want >=%v", got, want)
-	}
 }
 
 func TestResetShortToLong(t *testing.T) {
-	timer := NewTimer()
+	clock := NewFakeClock(epoch)
+	timer := NewTimerWithClock(clock)
 	defer timer.Close()
-	t0 := time.Now()
 	timer.Reset(10 * time.Millisecond)
 	timer.Reset(20 * time.Millisecond)
+	// The sleeper wakes up at the original time and
+	// goes back to sleep until the new one.
+	clock.WaitSleepers(1)
+	clock.Advance(10 * time.Millisecond)
+	clock.WaitSleepers(1)
+	assertNoReceive(t, timer)
+	clock.Advance(10 * time.Millisecond)
 	<-timer.C
-	assertDuration(t, time.Since(t0), 20*time.Millisecond)
 }
 
 func BenchmarkRepeatedTimer(b *testing.B) {
@@ -38,100 +49,177 @@ func BenchmarkRepeatedTimer(b *testing.B) {
 	}
 }
 
-const margin = time.Millisecond
-
 func TestResetLongToShort(t *testing.T) {
-	timer := NewTimer()
+	clock := NewFakeClock(epoch)
+	timer := NewTimerWithClock(clock)
+	done := watchDone(timer)
 	defer timer.Close()
-	t0 := time.Now()
 	timer.Reset(20 * time.Millisecond)
 	timer.Reset(10 * time.Millisecond)
+	clock.WaitSleepers(2)
+	clock.Advance(10 * time.Millisecond)
 	<-timer.C
-	assertDuration(t, time.Since(t0), 10*time.Millisecond)
-	// Check that the original timer doesn't fire:
-	select {
-	case <-timer.C:
-		t.Fatalf("unexpected receive on timer channel at %v", time.Since(t0))
-	case <-time.After(30 * time.Millisecond):
+	clock.Advance(10 * time.Millisecond)
+	// Wait for both sleepers to finish so that we
+	// know the original timer has had a chance to fire.
+	waitDone(done, 2)
+	assertNoReceive(t, timer)
+}
+
+func TestLongTimer(t *testing.T) {
+	clock := NewFakeClock(epoch)
+	timer := NewTimerWithClock(clock)
+	defer timer.Close()
+	timer.Reset(2 * time.Second)
+	// The sleeper wakes up every maxSleepTime
+	// to check the expiry time.
+	for i := 0; i < 3; i++ {
+		clock.WaitSleepers(1)
+		clock.Advance(maxSleepTime)
+		assertNoReceive(t, timer)
 	}
+	clock.WaitSleepers(1)
+	clock.Advance(maxSleepTime)
+	<-timer.C
 }
 
 func TestExpireWithoutReceive(t *testing.T) {
-	timer := NewTimer()
+	clock := NewFakeClock(epoch)
+	timer := NewTimerWithClock(clock)
+	done := watchDone(timer)
 	defer timer.Close()
 	timer.Reset(time.Millisecond)
-	time.Sleep(5 * time.Millisecond)
-	t0 := time.Now()
+	clock.WaitSleepers(1)
+	clock.Advance(time.Millisecond)
+	waitDone(done, 1)
+	// The value sent when the timer expired
+	// is taken back by Reset.
 	timer.Reset(10 * time.Millisecond)
+	assertNoReceive(t, timer)
+	clock.WaitSleepers(1)
+	clock.Advance(9 * time.Millisecond)
+	assertNoReceive(t, timer)
+	clock.Advance(time.Millisecond)
 	<-timer.C
-	assertDuration(t, time.Since(t0), 10*time.Millisecond)
-	select {
-	case <-timer.C:
-		t.Fatalf("unexpected receive on timer channel at %v", time.Since(t0))
-	case <-time.After(time.Millisecond):
-	}
+	waitDone(done, 1)
+	assertNoReceive(t, timer)
 }
 
 func TestStop(t *testing.T) {
-	timer := NewTimer()
+	clock := NewFakeClock(epoch)
+	timer := NewTimerWithClock(clock)
+	done := watchDone(timer)
 	defer timer.Close()
-	t0 := time.Now()
-	timer.Reset(time.Millisecond)
+	timer.Reset(10 * time.Millisecond)
+	clock.WaitSleepers(1)
 	timer.Stop()
-	select {
-	case <-timer.C:
-		t.Fatalf("unexpected receive on timer channel at %v", time.Since(t0))
-	case <-time.After(time.Millisecond):
-	}
+	clock.Advance(10 * time.Millisecond)
+	waitDone(done, 1)
+	assertNoReceive(t, timer)
 }
 
 func TestMultipleResets(t *testing.T) {
-	timer := NewTimer()
+	clock := NewFakeClock(epoch)
+	timer := NewTimerWithClock(clock)
+	done := watchDone(timer)
 	defer timer.Close()
-	var t0 time.Time
 	// This should cause a goroutine to be started each time,
 	// because each sleep is shorter than the last one.
+	n := 0
 	for d := 30 * time.Millisecond; d >= 5*time.Millisecond; d -= time.Millisecond {
-		t0 = time.Now()
 		timer.Reset(d)
+		n++
 	}
+	clock.WaitSleepers(n)
+	clock.Advance(5 * time.Millisecond)
 	<-timer.C
-	assertDuration(t, time.Since(t0), 5*time.Millisecond)
+	// Wake all the other sleepers, which have nothing to do.
+	clock.Advance(25 * time.Millisecond)
+	waitDone(done, n)
+	assertNoReceive(t, timer)
+	if got, want := idleCount(timer), int8(maxIdle); got != want {
+		t.Fatalf("unexpected idle count; got %v want %v", got, want)
+	}
 	// If we reset now, an existing goroutine should be used (hard to check
 	// directly, but try to tickle the code path anyway).
-	t0 = time.Now()
 	timer.Reset(10 * time.Millisecond)
+	clock.WaitSleepers(1)
+	clock.Advance(10 * time.Millisecond)
 	<-timer.C
-	assertDuration(t, time.Since(t0), 10*time.Millisecond)
-	select {
-	case <-timer.C:
-		t.Fatalf("unexpected receive on timer channel at %v", time.Since(t0))
-	case <-time.After(40 * time.Millisecond):
-	}
-	if got, want := timer.idle, int8(maxIdle); got != want {
+	waitDone(done, 1)
+	assertNoReceive(t, timer)
+	if got, want := idleCount(timer), int8(maxIdle); got != want {
 		t.Fatalf("unexpected idle count; got %v want %v", got, want)
 	}
 }
 
 func TestStopWithoutPreviousReceive(t *testing.T) {
-	t0 := time.Now()
-	timer := NewTimer()
+	clock := NewFakeClock(epoch)
+	timer := NewTimerWithClock(clock)
+	done := watchDone(timer)
 	defer timer.Close()
 	timer.Reset(time.Millisecond)
-	time.Sleep(5 * time.Millisecond)
+	clock.WaitSleepers(1)
+	clock.Advance(time.Millisecond)
+	waitDone(done, 1)
 	timer.Stop()
+	assertNoReceive(t, timer)
+}
+
+func TestRealClockTimer(t *testing.T) {
+	timer := NewTimer()
+	defer timer.Close()
+	if timer.Clock() != RealClock {
+		t.Fatalf("unexpected clock %#v", timer.Clock())
+	}
+	// Only check that the timer doesn't fire too soon,
+	// because the time it takes depends on the system load.
+	t0 := time.Now()
+	timer.Reset(10 * time.Millisecond)
+	<-timer.C
+	if got, want := time.Since(t0), 10*time.Millisecond; got < want {
+		t.Fatalf("timer fired too soon; got %v want >=%v", got, want)
+	}
+}
+
+func TestNewTimerWithNilClock(t *testing.T) {
+	timer := NewTimerWithClock(nil)
+	defer timer.Close()
+	if timer.Clock() != RealClock {
+		t.Fatalf("unexpected clock %#v", timer.Clock())
+	}
+}
+
+func assertNoReceive(t *testing.T, timer *Timer) {
 	select {
 	case <-timer.C:
-		t.Fatalf("unexpected receive on timer channel at %v", time.Since(t0))
-	case <-time.After(time.Millisecond):
+		t.Fatalf("unexpected receive on timer channel")
+	default:
 	}
 }
 
-func assertDuration(t *testing.T, got, want time.Duration) {
-	if got < want {
-		t.Fatalf("duration too small; got %v want at least %v", got, want)
+// watchDone returns a channel that receives a value each time
+// one of the timer's sleeper goroutines finishes sleeping with
+// nothing more to wait for. It must be called before the
+// timer is used.
+func watchDone(timer *Timer) <-chan struct{} {
+	done := make(chan struct{}, 100)
+	timer.doneHook = func() {
+		done <- struct{}{}
 	}
-	if got > want+margin {
-		t.Fatalf("duration too large; got %v want not more than %v+margin", got, want)
+	return done
+}
+
+// waitDone waits for n sleeper goroutines to finish.
+func waitDone(done <-chan struct{}, n int) {
+	for i := 0; i < n; i++ {
+		<-done
 	}
 }
+
+// idleCount returns the number of idle goroutines.
+func idleCount(timer *Timer) int8 {
+	timer.mu.Lock()
+	defer timer.mu.Unlock()
+	return timer.idle
+}
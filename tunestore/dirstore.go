@@ -0,0 +1,150 @@
+// +build !tinygo
+
+package tunestore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DirStore is a Store that holds each tune in
+// its own file within a directory.
+type DirStore struct {
+	dir string
+}
+
+// NewDirStore returns a Store that keeps tunes in the given
+// directory, which must already exist. Files in the directory
+// that don't have valid tune names are ignored.
+func NewDirStore(dir string) *DirStore {
+	return &DirStore{
+		dir: dir,
+	}
+}
+
+// List implements Store.List.
+func (s *DirStore) List() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() && CheckName(info.Name()) == nil {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load implements Store.Load.
+func (s *DirStore) Load(name string) ([]byte, error) {
+	if CheckName(name) != nil {
+		return nil, ErrNotFound
+	}
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Add implements Store.Add.
+func (s *DirStore) Add(name string, data []byte) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a
+	// partially written tune is never seen.
+	f, err := ioutil.TempFile(s.dir, ".tune")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+// Delete implements Store.Delete.
+func (s *DirStore) Delete(name string) error {
+	if CheckName(name) != nil {
+		return ErrNotFound
+	}
+	err := os.Remove(filepath.Join(s.dir, name))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DirFS implements FS on the host filesystem, with
+// paths interpreted relative to the directory it names.
+type DirFS string
+
+// ReadDir implements FS.ReadDir.
+func (fs DirFS) ReadDir(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(fs.path(dir))
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	return names, nil
+}
+
+// ReadFile implements FS.ReadFile.
+func (fs DirFS) ReadFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(fs.path(path))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// WriteFile implements FS.WriteFile.
+func (fs DirFS) WriteFile(path string, data []byte) error {
+	return ioutil.WriteFile(fs.path(path), data, 0666)
+}
+
+// Rename implements FS.Rename.
+func (fs DirFS) Rename(oldPath, newPath string) error {
+	return os.Rename(fs.path(oldPath), fs.path(newPath))
+}
+
+// Remove implements FS.Remove.
+func (fs DirFS) Remove(path string) error {
+	err := os.Remove(fs.path(path))
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Mkdir implements FS.Mkdir.
+func (fs DirFS) Mkdir(path string) error {
+	err := os.Mkdir(fs.path(path), 0777)
+	if os.IsExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (fs DirFS) path(path string) string {
+	return filepath.Join(string(fs), filepath.FromSlash(path))
+}
@@ -0,0 +1,109 @@
+package tunestore
+
+import (
+	"sort"
+)
+
+// FS represents a filesystem, such as littlefs on a flash
+// device. Paths are slash-separated.
+type FS interface {
+	// ReadDir returns the names of the regular files
+	// in the given directory.
+	ReadDir(dir string) ([]string, error)
+
+	// ReadFile returns the contents of the given file.
+	// It returns ErrNotFound if the file doesn't exist.
+	ReadFile(path string) ([]byte, error)
+
+	// WriteFile creates the given file, replacing any
+	// existing file, and writes data to it.
+	WriteFile(path string, data []byte) error
+
+	// Rename renames a file, atomically replacing
+	// any existing file at newPath.
+	Rename(oldPath, newPath string) error
+
+	// Remove removes the given file.
+	// It returns ErrNotFound if the file doesn't exist.
+	Remove(path string) error
+
+	// Mkdir creates the given directory if it
+	// doesn't already exist.
+	Mkdir(path string) error
+}
+
+// FSStore is a Store that holds each tune in its own
+// file within a directory on a filesystem.
+type FSStore struct {
+	fs  FS
+	dir string
+}
+
+// NewFSStore returns a Store that keeps tunes in the given
+// directory on fs, creating the directory if needed. Files in the
+// directory that don't have valid tune names are ignored.
+func NewFSStore(fs FS, dir string) (*FSStore, error) {
+	if err := fs.Mkdir(dir); err != nil {
+		return nil, err
+	}
+	return &FSStore{
+		fs:  fs,
+		dir: dir,
+	}, nil
+}
+
+// List implements Store.List.
+func (s *FSStore) List() ([]string, error) {
+	files, err := s.fs.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range files {
+		if CheckName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load implements Store.Load.
+func (s *FSStore) Load(name string) ([]byte, error) {
+	if CheckName(name) != nil {
+		return nil, ErrNotFound
+	}
+	return s.fs.ReadFile(s.path(name))
+}
+
+// Add implements Store.Add.
+func (s *FSStore) Add(name string, data []byte) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a
+	// partially written tune is never seen. Its name
+	// isn't a valid tune name so List ignores it.
+	tmp := s.path(".new")
+	if err := s.fs.WriteFile(tmp, data); err != nil {
+		s.fs.Remove(tmp)
+		return err
+	}
+	if err := s.fs.Rename(tmp, s.path(name)); err != nil {
+		s.fs.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Delete implements Store.Delete.
+func (s *FSStore) Delete(name string) error {
+	if CheckName(name) != nil {
+		return ErrNotFound
+	}
+	return s.fs.Remove(s.path(name))
+}
+
+func (s *FSStore) path(name string) string {
+	return s.dir + "/" + name
+}
@@ -0,0 +1,141 @@
+package tunestore
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestFSStorePersistence(t *testing.T) {
+	c := qt.New(t)
+	fs := newMemFS()
+	s, err := NewFSStore(fs, "/tunes")
+	c.Assert(err, qt.IsNil)
+	err = s.Add("foo", []byte{1, 2, 3})
+	c.Assert(err, qt.IsNil)
+	err = s.Add("bar", []byte{4, 5})
+	c.Assert(err, qt.IsNil)
+	err = s.Delete("foo")
+	c.Assert(err, qt.IsNil)
+
+	// Other files in the directory are ignored.
+	err = fs.WriteFile("/tunes/.hidden", []byte{99})
+	c.Assert(err, qt.IsNil)
+
+	s, err = NewFSStore(fs, "/tunes")
+	c.Assert(err, qt.IsNil)
+	names, err := s.List()
+	c.Assert(err, qt.IsNil)
+	c.Assert(names, qt.DeepEquals, []string{"bar"})
+	data, err := s.Load("bar")
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.DeepEquals, []byte{4, 5})
+}
+
+func TestFSStoreFailedWrite(t *testing.T) {
+	c := qt.New(t)
+	fs := newMemFS()
+	s, err := NewFSStore(fs, "/tunes")
+	c.Assert(err, qt.IsNil)
+	err = s.Add("foo", []byte{1, 2, 3})
+	c.Assert(err, qt.IsNil)
+
+	// Simulate the filesystem filling up.
+	fs.failWrite = true
+	err = s.Add("foo", []byte{4, 5, 6})
+	c.Assert(err, qt.ErrorMatches, `write failed`)
+
+	// The old tune is still there and the
+	// temporary file has been removed.
+	data, err := s.Load("foo")
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.DeepEquals, []byte{1, 2, 3})
+	files, err := fs.ReadDir("/tunes")
+	c.Assert(err, qt.IsNil)
+	c.Assert(files, qt.DeepEquals, []string{"foo"})
+}
+
+// memFS implements FS in memory.
+type memFS struct {
+	files map[string][]byte
+	dirs  map[string]bool
+	// failWrite causes WriteFile to fail after creating the file.
+	failWrite bool
+}
+
+func newMemFS() *memFS {
+	return &memFS{
+		files: make(map[string][]byte),
+		dirs:  map[string]bool{"/": true},
+	}
+}
+
+func (fs *memFS) ReadDir(dir string) ([]string, error) {
+	if !fs.dirs[dir] {
+		return nil, errors.New("no such directory")
+	}
+	var names []string
+	for path := range fs.files {
+		if name := strings.TrimPrefix(path, dir+"/"); name != path && !strings.Contains(name, "/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (fs *memFS) ReadFile(path string) ([]byte, error) {
+	data, ok := fs.files[path]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (fs *memFS) WriteFile(path string, data []byte) error {
+	if !fs.dirs[parent(path)] {
+		return errors.New("no such directory")
+	}
+	if fs.failWrite {
+		fs.files[path] = nil
+		return errors.New("write failed")
+	}
+	fs.files[path] = append([]byte(nil), data...)
+	return nil
+}
+
+func (fs *memFS) Rename(oldPath, newPath string) error {
+	data, ok := fs.files[oldPath]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(fs.files, oldPath)
+	fs.files[newPath] = data
+	return nil
+}
+
+func (fs *memFS) Remove(path string) error {
+	if _, ok := fs.files[path]; !ok {
+		return ErrNotFound
+	}
+	delete(fs.files, path)
+	return nil
+}
+
+func (fs *memFS) Mkdir(path string) error {
+	if !fs.dirs[parent(path)] {
+		return errors.New("no such directory")
+	}
+	fs.dirs[path] = true
+	return nil
+}
+
+func parent(path string) string {
+	if i := strings.LastIndex(path, "/"); i > 0 {
+		return path[:i]
+	}
+	return "/"
+}
@@ -0,0 +1,114 @@
+// Package tunestore provides persistent storage for tunes.
+//
+// Tunes are stored as opaque byte slices (usually in the format
+// read by sequence.ActionsForTune), each identified by a name.
+package tunestore
+
+import (
+	"errors"
+	"sort"
+)
+
+// Store represents a collection of named tunes.
+type Store interface {
+	// List returns the names of all the tunes in the store
+	// in alphabetical order.
+	List() ([]string, error)
+
+	// Load returns the data for the tune with the given name.
+	// It returns ErrNotFound if there is no such tune.
+	Load(name string) ([]byte, error)
+
+	// Add adds a tune to the store, replacing any
+	// existing tune with the same name.
+	Add(name string, data []byte) error
+
+	// Delete removes the tune with the given name.
+	// It returns ErrNotFound if there is no such tune.
+	Delete(name string) error
+}
+
+// MaxNameLen holds the maximum length of a tune name.
+const MaxNameLen = 64
+
+var (
+	// ErrNotFound is returned when a tune doesn't exist.
+	ErrNotFound = errors.New("tune not found")
+
+	// ErrInvalidName is returned when a tune name isn't valid.
+	ErrInvalidName = errors.New("invalid tune name")
+)
+
+// CheckName checks that the given name is a valid tune name.
+// A name must be non-empty, no longer than MaxNameLen bytes
+// and contain only ASCII letters, digits and the characters
+// '-', '_' and '.' (but must not start with '.').
+// It returns ErrInvalidName if not.
+func CheckName(name string) error {
+	if len(name) == 0 || len(name) > MaxNameLen || name[0] == '.' {
+		return ErrInvalidName
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case 'a' <= c && c <= 'z',
+			'A' <= c && c <= 'Z',
+			'0' <= c && c <= '9',
+			c == '-', c == '_', c == '.':
+		default:
+			return ErrInvalidName
+		}
+	}
+	return nil
+}
+
+// MemStore is a Store that holds tunes in memory.
+// The zero value is an empty store ready to use.
+type MemStore struct {
+	tunes map[string][]byte
+}
+
+// NewMemStore returns a new empty MemStore.
+func NewMemStore() *MemStore {
+	return &MemStore{}
+}
+
+// List implements Store.List.
+func (s *MemStore) List() ([]string, error) {
+	names := make([]string, 0, len(s.tunes))
+	for name := range s.tunes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Load implements Store.Load.
+func (s *MemStore) Load(name string) ([]byte, error) {
+	data, ok := s.tunes[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// Add implements Store.Add.
+func (s *MemStore) Add(name string, data []byte) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	if s.tunes == nil {
+		s.tunes = make(map[string][]byte)
+	}
+	s.tunes[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// Delete implements Store.Delete.
+func (s *MemStore) Delete(name string) error {
+	if _, ok := s.tunes[name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.tunes, name)
+	return nil
+}
@@ -0,0 +1,99 @@
+package tunestore
+
+import (
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+)
+
+func TestMemStore(t *testing.T) {
+	testStore(qt.New(t), NewMemStore())
+}
+
+func TestDirStore(t *testing.T) {
+	c := qt.New(t)
+	testStore(c, NewDirStore(c.TempDir()))
+}
+
+func TestFSStore(t *testing.T) {
+	c := qt.New(t)
+	s, err := NewFSStore(newMemFS(), "/tunes")
+	c.Assert(err, qt.IsNil)
+	testStore(c, s)
+}
+
+func TestFSStoreOnDirFS(t *testing.T) {
+	c := qt.New(t)
+	s, err := NewFSStore(DirFS(c.TempDir()), "/tunes")
+	c.Assert(err, qt.IsNil)
+	testStore(c, s)
+}
+
+func TestCheckName(t *testing.T) {
+	c := qt.New(t)
+	for _, name := range []string{"a", "happy-birthday", "Tune_2.txt", strings.Repeat("x", MaxNameLen)} {
+		c.Check(CheckName(name), qt.IsNil, qt.Commentf("%q", name))
+	}
+	for _, name := range []string{"", ".hidden", "a/b", "a b", "\x00", strings.Repeat("x", MaxNameLen+1)} {
+		c.Check(CheckName(name), qt.Equals, ErrInvalidName, qt.Commentf("%q", name))
+	}
+}
+
+// testStore runs some generic tests on an empty store.
+func testStore(c *qt.C, s Store) {
+	names, err := s.List()
+	c.Assert(err, qt.IsNil)
+	c.Assert(names, qt.HasLen, 0)
+
+	_, err = s.Load("foo")
+	c.Assert(err, qt.Equals, ErrNotFound)
+
+	err = s.Delete("foo")
+	c.Assert(err, qt.Equals, ErrNotFound)
+
+	err = s.Add("foo", []byte{1, 2, 3})
+	c.Assert(err, qt.IsNil)
+	err = s.Add("bar", []byte{4, 5})
+	c.Assert(err, qt.IsNil)
+	err = s.Add("empty", nil)
+	c.Assert(err, qt.IsNil)
+
+	names, err = s.List()
+	c.Assert(err, qt.IsNil)
+	c.Assert(names, qt.DeepEquals, []string{"bar", "empty", "foo"})
+
+	data, err := s.Load("foo")
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.DeepEquals, []byte{1, 2, 3})
+
+	// Changing the returned data doesn't affect the stored tune.
+	data[0] = 99
+	data, err = s.Load("foo")
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.DeepEquals, []byte{1, 2, 3})
+
+	data, err = s.Load("empty")
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.HasLen, 0)
+
+	// Adding a tune with an existing name replaces it.
+	err = s.Add("foo", []byte{6})
+	c.Assert(err, qt.IsNil)
+	data, err = s.Load("foo")
+	c.Assert(err, qt.IsNil)
+	c.Assert(data, qt.DeepEquals, []byte{6})
+
+	err = s.Delete("foo")
+	c.Assert(err, qt.IsNil)
+	_, err = s.Load("foo")
+	c.Assert(err, qt.Equals, ErrNotFound)
+	names, err = s.List()
+	c.Assert(err, qt.IsNil)
+	c.Assert(names, qt.DeepEquals, []string{"bar", "empty"})
+
+	err = s.Add("../foo", []byte{1})
+	c.Assert(err, qt.Equals, ErrInvalidName)
+	_, err = s.Load("../foo")
+	c.Assert(err, qt.Equals, ErrNotFound)
+}
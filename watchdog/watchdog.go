@@ -0,0 +1,221 @@
+// Package watchdog makes sure that the doorbell solenoids can't be
+// left energised, which would burn them out.
+//
+// A Watchdog sits between the rest of the firmware and the output
+// pins, keeping track of when each channel was turned on. Its Run
+// method forces off any channel that's been on for longer than the
+// configured maximum, so that even if the goroutine playing a
+// sequence stalls between turning a solenoid on and turning it off
+// again, the solenoid is turned off soon enough.
+//
+// Run can also update a hardware watchdog timer (see Hardware), so
+// that if the Watchdog itself stops running, the whole system is
+// reset, which turns the outputs off too.
+package watchdog
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/timer"
+)
+
+// Pins represents a set of output pins, one per channel.
+// It's the same as protect.Pins so that a Watchdog can
+// be used as the pins of a protect.Protector.
+type Pins interface {
+	// Len returns the number of channels.
+	Len() int
+	// Set sets the output for the given channel.
+	Set(ch int, on bool) error
+	// SetPins sets the outputs of all the channels for which
+	// mask is high to their values in values, as nearly
+	// simultaneously as possible.
+	SetPins(values, mask mcp23017.PinSlice) error
+}
+
+// Hardware represents a hardware watchdog timer that resets
+// the system unless it's updated often enough.
+// It's notably implemented by machine.Watchdog.
+type Hardware interface {
+	// Update restarts the watchdog timeout.
+	Update()
+}
+
+// Watchdog limits how long each channel of a set of pins can be on for.
+// It's safe to use concurrently.
+type Watchdog struct {
+	pins  Pins
+	maxOn time.Duration
+	clock timer.Clock
+
+	mu sync.Mutex
+	// onSince holds the time that each channel was turned
+	// on, or the zero time if it's off.
+	onSince []time.Time
+	// off and expired are used by Check to avoid allocation.
+	off, expired mcp23017.PinSlice
+}
+
+// New returns a Watchdog that makes sure that no channel of
+// the given pins stays on for longer than maxOn, using the given
+// clock to find out the current time. If clock is nil, the real
+// clock will be used. The pins are only checked while
+// Run is running.
+func New(pins Pins, maxOn time.Duration, clock timer.Clock) *Watchdog {
+	if clock == nil {
+		clock = timer.RealClock
+	}
+	return &Watchdog{
+		pins:    pins,
+		maxOn:   maxOn,
+		clock:   clock,
+		onSince: make([]time.Time, pins.Len()),
+		off:     mcp23017.NewPinSlice(pins.Len()),
+		expired: mcp23017.NewPinSlice(pins.Len()),
+	}
+}
+
+// Len returns the number of channels.
+func (w *Watchdog) Len() int {
+	return len(w.onSince)
+}
+
+// Set sets the output for the given channel. Turning on a channel
+// that's already on doesn't extend the time it can stay on.
+func (w *Watchdog) Set(ch int, on bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if ch < 0 || ch >= len(w.onSince) {
+		return errors.New("channel " + strconv.Itoa(ch) + " out of range")
+	}
+	if err := w.pins.Set(ch, on); err != nil {
+		if on {
+			// We don't know whether the channel is on or
+			// not, so assume that it is.
+			w.turnedOn(ch)
+		}
+		return err
+	}
+	if on {
+		w.turnedOn(ch)
+	} else {
+		w.onSince[ch] = time.Time{}
+	}
+	return nil
+}
+
+// SetPins sets the outputs of all the channels for which mask
+// is high to their respective values in values.
+func (w *Watchdog) SetPins(values, mask mcp23017.PinSlice) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	err := w.pins.SetPins(values, mask)
+	for ch := range w.onSince {
+		switch {
+		case !mask.Get(ch):
+		case values.Get(ch):
+			// Even if there's an error, the
+			// channel might be on.
+			w.turnedOn(ch)
+		case err == nil:
+			w.onSince[ch] = time.Time{}
+		}
+	}
+	return err
+}
+
+// turnedOn records that the channel has been turned on.
+// Called with w.mu held.
+func (w *Watchdog) turnedOn(ch int) {
+	if w.onSince[ch].IsZero() {
+		w.onSince[ch] = w.clock.Now()
+	}
+}
+
+// Check turns off any channels that have been on for at least
+// the maximum time and returns them. If the channels can't be
+// turned off, they're still treated as on so that they'll be
+// tried again by the next Check, and the error is returned.
+func (w *Watchdog) Check() ([]int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.clock.Now()
+	var forced []int
+	for ch, t := range w.onSince {
+		expired := !t.IsZero() && now.Sub(t) >= w.maxOn
+		w.expired.Set(ch, expired)
+		if expired {
+			forced = append(forced, ch)
+		}
+	}
+	if len(forced) == 0 {
+		return nil, nil
+	}
+	// Turn them all off at once so that the time the
+	// watchdog takes doesn't depend on how many channels
+	// have been left on.
+	if err := w.pins.SetPins(w.off, w.expired); err != nil {
+		return nil, err
+	}
+	for _, ch := range forced {
+		w.onSince[ch] = time.Time{}
+	}
+	return forced, nil
+}
+
+// Run calls Check repeatedly until stop is closed, calling
+// forced (if it's not nil) with the result of each Check that
+// turns off a channel or fails. It waits no longer than
+// interval between checks, and checks as soon as any channel
+// reaches the maximum time so channels aren't left on
+// for any longer than that.
+//
+// If hw is non-nil, it's updated before every check, so its
+// timeout should be comfortably longer than interval.
+func (w *Watchdog) Run(interval time.Duration, hw Hardware, stop <-chan struct{}, forced func(chans []int, err error)) {
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if hw != nil {
+			hw.Update()
+		}
+		chans, err := w.Check()
+		if (len(chans) > 0 || err != nil) && forced != nil {
+			forced(chans, err)
+		}
+		w.clock.Sleep(w.sleepTime(interval))
+	}
+}
+
+// sleepTime returns how long Run should sleep for before the
+// next check. It's never longer than the maximum on time,
+// so any channel that's turned on during the sleep
+// can't reach the maximum before it ends.
+func (w *Watchdog) sleepTime(interval time.Duration) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	d := interval
+	if w.maxOn < d {
+		d = w.maxOn
+	}
+	now := w.clock.Now()
+	for _, t := range w.onSince {
+		if t.IsZero() {
+			continue
+		}
+		// Channels that are already past the maximum
+		// couldn't be turned off, so there's no point
+		// trying again until the next interval.
+		if dt := t.Add(w.maxOn).Sub(now); dt > 0 && dt < d {
+			d = dt
+		}
+	}
+	return d
+}
@@ -0,0 +1,323 @@
+package watchdog
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+
+	"github.com/rogpeppe/doorbell/mcp23017"
+	"github.com/rogpeppe/doorbell/timer"
+)
+
+const ms = time.Millisecond
+
+var epoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestSet(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	bus, pins := newPins(c, clock)
+	w := New(pins, 100*ms, clock)
+	c.Assert(w.Len(), qt.Equals, mcp23017.PinCount)
+
+	c.Assert(w.Set(3, true), qt.IsNil)
+	c.Assert(bus.gpio(), qt.Equals, mcp23017.Pins(1<<3))
+	c.Assert(w.Set(3, false), qt.IsNil)
+	c.Assert(bus.gpio(), qt.Equals, mcp23017.Pins(0))
+
+	c.Assert(w.Set(16, true), qt.ErrorMatches, `channel 16 out of range`)
+	c.Assert(w.Set(-1, true), qt.ErrorMatches, `channel -1 out of range`)
+}
+
+func TestCheck(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	bus, pins := newPins(c, clock)
+	w := New(pins, 100*ms, clock)
+
+	c.Assert(w.Set(0, true), qt.IsNil)
+	clock.Advance(50 * ms)
+	c.Assert(w.Set(1, true), qt.IsNil)
+	c.Assert(w.Set(2, true), qt.IsNil)
+	clock.Advance(10 * ms)
+	c.Assert(w.Set(2, false), qt.IsNil)
+	// Turning on a channel that's already on doesn't
+	// restart its time.
+	c.Assert(w.Set(0, true), qt.IsNil)
+
+	forced, err := w.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(forced, qt.HasLen, 0)
+
+	clock.Advance(40 * ms)
+	forced, err = w.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(forced, qt.DeepEquals, []int{0})
+	c.Assert(bus.gpio(), qt.Equals, mcp23017.Pins(1<<1))
+
+	clock.Advance(100 * ms)
+	forced, err = w.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(forced, qt.DeepEquals, []int{1})
+	c.Assert(bus.gpio(), qt.Equals, mcp23017.Pins(0))
+}
+
+func TestSetPins(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	bus, pins := newPins(c, clock)
+	w := New(pins, 100*ms, clock)
+
+	values := mcp23017.PinSlice{0b0101}
+	mask := mcp23017.PinSlice{0b0111}
+	c.Assert(w.SetPins(values, mask), qt.IsNil)
+	c.Assert(bus.gpio(), qt.Equals, mcp23017.Pins(0b0101))
+
+	clock.Advance(50 * ms)
+	values = mcp23017.PinSlice{0b1010}
+	mask = mcp23017.PinSlice{0b1011}
+	c.Assert(w.SetPins(values, mask), qt.IsNil)
+	c.Assert(bus.gpio(), qt.Equals, mcp23017.Pins(0b1110))
+
+	// Channel 2 has been on since the start; channels
+	// 1 and 3 were turned on 50ms later.
+	clock.Advance(50 * ms)
+	forced, err := w.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(forced, qt.DeepEquals, []int{2})
+	clock.Advance(50 * ms)
+	forced, err = w.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(forced, qt.DeepEquals, []int{1, 3})
+	c.Assert(bus.gpio(), qt.Equals, mcp23017.Pins(0))
+}
+
+func TestCheckWithError(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	bus, pins := newPins(c, clock)
+	w := New(pins, 100*ms, clock)
+
+	c.Assert(w.Set(0, true), qt.IsNil)
+	c.Assert(w.Set(1, true), qt.IsNil)
+	clock.Advance(100 * ms)
+	bus.setErr(errors.New("bus error"))
+	forced, err := w.Check()
+	c.Assert(err, qt.ErrorMatches, `.*: bus error`)
+	c.Assert(forced, qt.HasLen, 0)
+
+	// The channels are tried again by the next check.
+	bus.setErr(nil)
+	forced, err = w.Check()
+	c.Assert(err, qt.IsNil)
+	c.Assert(forced, qt.DeepEquals, []int{0, 1})
+	c.Assert(bus.gpio(), qt.Equals, mcp23017.Pins(0))
+}
+
+func TestRun(t *testing.T) {
+	c := qt.New(t)
+	clock := timer.NewFakeClock(epoch)
+	bus, pins := newPins(c, clock)
+	const maxOn = 100 * ms
+	w := New(pins, maxOn, clock)
+
+	type forcedCall struct {
+		When  time.Duration
+		Chans []int
+	}
+	var (
+		mu     sync.Mutex
+		calls  []forcedCall
+		hw     fakeHardware
+		stop   = make(chan struct{})
+		exited = make(chan struct{})
+	)
+	go func() {
+		defer close(exited)
+		// Use an interval much longer than the maximum so that
+		// we're checking that the channels are turned off on time
+		// rather than at the next interval.
+		w.Run(time.Second, &hw, stop, func(chans []int, err error) {
+			c.Check(err, qt.IsNil)
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, forcedCall{
+				When:  clock.Now().Sub(epoch),
+				Chans: chans,
+			})
+		})
+	}()
+	clock.WaitSleepers(1)
+
+	// Turn on channels at various times and never turn them
+	// off, as if the player had stalled. Each entry in the
+	// schedule holds the channels to turn on at that time.
+	schedule := map[time.Duration][]int{
+		0:        {0},
+		30 * ms:  {3, 4},
+		100 * ms: {5},
+		170 * ms: {0},
+		250 * ms: {6},
+		260 * ms: {6},
+	}
+	for now := time.Duration(0); now < 500*ms; now += 10 * ms {
+		for _, ch := range schedule[now] {
+			c.Assert(w.Set(ch, true), qt.IsNil)
+		}
+		clock.Advance(10 * ms)
+		// Wait for the watchdog to go back to sleep
+		// if it's been woken.
+		clock.WaitSleepers(1)
+	}
+	close(stop)
+	clock.Advance(time.Second)
+	<-exited
+
+	c.Assert(bus.gpio(), qt.Equals, mcp23017.Pins(0))
+	for pin, d := range bus.maxHigh {
+		c.Check(d <= maxOn, qt.IsTrue, qt.Commentf("pin %d on for %v", pin, d))
+	}
+	c.Assert(calls, qt.DeepEquals, []forcedCall{
+		{When: 100 * ms, Chans: []int{0}},
+		{When: 130 * ms, Chans: []int{3, 4}},
+		{When: 200 * ms, Chans: []int{5}},
+		{When: 270 * ms, Chans: []int{0}},
+		{When: 350 * ms, Chans: []int{6}},
+	})
+	c.Assert(hw.updates() > len(calls), qt.IsTrue)
+}
+
+// newPins returns a fake bus holding a single device with all
+// its pins configured as outputs, and the pins of the device.
+func newPins(c *qt.C, clock timer.Clock) (*fakeBus, devPins) {
+	bus := &fakeBus{
+		clock: clock,
+		regs: [0x16]uint8{
+			0xff, 0xff, // IODIRA, IODIRB
+		},
+	}
+	dev, err := mcp23017.NewI2C(bus, 0x20)
+	c.Assert(err, qt.IsNil)
+	modes := make([]mcp23017.PinMode, mcp23017.PinCount)
+	for i := range modes {
+		modes[i] = mcp23017.Output
+	}
+	c.Assert(dev.SetModes(modes), qt.IsNil)
+	pins := make(devPins, mcp23017.PinCount)
+	for i := range pins {
+		pins[i] = dev.Pin(i)
+	}
+	return bus, pins
+}
+
+// devPins implements Pins on the pins of a device.
+type devPins []mcp23017.Pin
+
+func (p devPins) Len() int {
+	return len(p)
+}
+
+func (p devPins) Set(ch int, on bool) error {
+	return p[ch].Set(on)
+}
+
+func (p devPins) SetPins(values, mask mcp23017.PinSlice) error {
+	for ch, pin := range p {
+		if mask.Get(ch) {
+			if err := pin.Set(values.Get(ch)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// gpioRegister holds the address of the GPIOA register.
+const gpioRegister = 0x12
+
+// fakeBus implements mcp23017.I2C for a single device, keeping
+// track of the longest time that each output has been high.
+type fakeBus struct {
+	clock timer.Clock
+
+	mu   sync.Mutex
+	err  error
+	regs [0x16]uint8
+	// highSince holds the time that each output went high.
+	highSince [mcp23017.PinCount]time.Time
+	// maxHigh holds the longest time that each output
+	// has been high for.
+	maxHigh [mcp23017.PinCount]time.Duration
+}
+
+func (bus *fakeBus) ReadRegister(addr uint8, r uint8, buf []byte) error {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.err != nil {
+		return bus.err
+	}
+	copy(buf, bus.regs[r:])
+	return nil
+}
+
+func (bus *fakeBus) WriteRegister(addr uint8, r uint8, buf []byte) error {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	if bus.err != nil {
+		return bus.err
+	}
+	before := bus.getGPIO()
+	copy(bus.regs[r:], buf)
+	after := bus.getGPIO()
+	now := bus.clock.Now()
+	for pin := 0; pin < mcp23017.PinCount; pin++ {
+		switch {
+		case !before.Get(pin) && after.Get(pin):
+			bus.highSince[pin] = now
+		case before.Get(pin) && !after.Get(pin):
+			if d := now.Sub(bus.highSince[pin]); d > bus.maxHigh[pin] {
+				bus.maxHigh[pin] = d
+			}
+		}
+	}
+	return nil
+}
+
+func (bus *fakeBus) setErr(err error) {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	bus.err = err
+}
+
+func (bus *fakeBus) gpio() mcp23017.Pins {
+	bus.mu.Lock()
+	defer bus.mu.Unlock()
+	return bus.getGPIO()
+}
+
+// getGPIO returns the values of the GPIO registers.
+// Called with bus.mu held.
+func (bus *fakeBus) getGPIO() mcp23017.Pins {
+	return mcp23017.Pins(bus.regs[gpioRegister]) | mcp23017.Pins(bus.regs[gpioRegister+1])<<8
+}
+
+// fakeHardware implements Hardware by counting updates.
+type fakeHardware struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (hw *fakeHardware) Update() {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	hw.n++
+}
+
+func (hw *fakeHardware) updates() int {
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+	return hw.n
+}